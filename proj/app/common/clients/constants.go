@@ -0,0 +1,19 @@
+package clients
+
+import (
+	"time"
+
+	"grs/common/resilience"
+)
+
+// Backoff used for every retried call: 200ms, 400ms, 800ms... up to 5s, 4 attempts in total
+var DEFAULT_BACKOFF = resilience.Backoff{
+	Initial:    200 * time.Millisecond,
+	Max:        5 * time.Second,
+	Multiplier: 2,
+	Attempts:   4,
+}
+
+// Consecutive failures after which a service is considered down, and how long to wait before trying it again
+const BREAKER_THRESHOLD int = 5
+const BREAKER_COOLDOWN time.Duration = 30 * time.Second
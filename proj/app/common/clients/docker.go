@@ -0,0 +1,165 @@
+// Wraps the Docker and Elasticsearch clients with retries, backoff and a circuit breaker
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"grs/common/resilience"
+)
+
+// Docker API client whose calls go through a resilience.Policy.
+// Calls not redefined here go straight to the embedded client
+type Docker struct {
+	*client.Client
+	policy *resilience.Policy
+}
+
+func NewDocker() (*Docker, error) {
+	cl, err := client.NewClientWithOpts(client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("In NewDocker: Failed to create Docker API client -> %s", err))
+	}
+
+	return &Docker{
+		Client: cl,
+		policy: &resilience.Policy{
+			Service:  "docker",
+			Backoff:  DEFAULT_BACKOFF,
+			Breaker:  resilience.NewCircuitBreaker(BREAKER_THRESHOLD, BREAKER_COOLDOWN),
+			Classify: classifyDockerError,
+		},
+	}, nil
+}
+
+// Errors where the daemon answered but refused the request are not worth retrying
+func classifyDockerError(err error) resilience.Kind {
+	switch {
+	case errdefs.IsNotFound(err), errdefs.IsConflict(err), errdefs.IsInvalidParameter(err),
+		errdefs.IsUnauthorized(err), errdefs.IsForbidden(err), errdefs.IsNotImplemented(err),
+		errdefs.IsNotModified(err):
+		return resilience.Permanent
+	}
+
+	return resilience.Transient
+}
+
+func (d *Docker) ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error) {
+	var res []types.Container
+	err := d.policy.Do(ctx, "ContainerList", true, func(ctx context.Context) (err error) {
+		res, err = d.Client.ContainerList(ctx, options)
+		return err
+	})
+
+	return res, err
+}
+
+func (d *Docker) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	var res types.ContainerJSON
+	err := d.policy.Do(ctx, "ContainerInspect", true, func(ctx context.Context) (err error) {
+		res, err = d.Client.ContainerInspect(ctx, containerID)
+		return err
+	})
+
+	return res, err
+}
+
+func (d *Docker) ContainerStats(ctx context.Context, containerID string, stream bool) (types.ContainerStats, error) {
+	var res types.ContainerStats
+	err := d.policy.Do(ctx, "ContainerStats", true, func(ctx context.Context) (err error) {
+		res, err = d.Client.ContainerStats(ctx, containerID, stream)
+		return err
+	})
+
+	return res, err
+}
+
+func (d *Docker) NetworkList(ctx context.Context, options types.NetworkListOptions) ([]types.NetworkResource, error) {
+	var res []types.NetworkResource
+	err := d.policy.Do(ctx, "NetworkList", true, func(ctx context.Context) (err error) {
+		res, err = d.Client.NetworkList(ctx, options)
+		return err
+	})
+
+	return res, err
+}
+
+func (d *Docker) NetworkInspect(ctx context.Context, networkID string, options types.NetworkInspectOptions) (types.NetworkResource, error) {
+	var res types.NetworkResource
+	err := d.policy.Do(ctx, "NetworkInspect", true, func(ctx context.Context) (err error) {
+		res, err = d.Client.NetworkInspect(ctx, networkID, options)
+		return err
+	})
+
+	return res, err
+}
+
+// Not retried: if the response is lost we can't know whether the container was created
+func (d *Docker) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error) {
+	var res container.CreateResponse
+	err := d.policy.Do(ctx, "ContainerCreate", false, func(ctx context.Context) (err error) {
+		res, err = d.Client.ContainerCreate(ctx, config, hostConfig, networkingConfig, platform, containerName)
+		return err
+	})
+
+	return res, err
+}
+
+// A retry after a lost response finds the container already started: the daemon answers 304, which is a success
+func (d *Docker) ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error {
+	return d.policy.Do(ctx, "ContainerStart", true, func(ctx context.Context) error {
+		if err := d.Client.ContainerStart(ctx, containerID, options); err != nil && !errdefs.IsNotModified(err) {
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (d *Docker) ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error {
+	return d.policy.Do(ctx, "ContainerStop", true, func(ctx context.Context) error {
+		return d.Client.ContainerStop(ctx, containerID, options)
+	})
+}
+
+func (d *Docker) ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error {
+	return d.policy.Do(ctx, "ContainerRemove", true, func(ctx context.Context) error {
+		return d.Client.ContainerRemove(ctx, containerID, options)
+	})
+}
+
+func (d *Docker) ContainerExecCreate(ctx context.Context, containerName string, config types.ExecConfig) (types.IDResponse, error) {
+	var res types.IDResponse
+	err := d.policy.Do(ctx, "ContainerExecCreate", true, func(ctx context.Context) (err error) {
+		res, err = d.Client.ContainerExecCreate(ctx, containerName, config)
+		return err
+	})
+
+	return res, err
+}
+
+// Not retried: an exec runs its command every time it is started
+func (d *Docker) ContainerExecStart(ctx context.Context, execID string, config types.ExecStartCheck) error {
+	return d.policy.Do(ctx, "ContainerExecStart", false, func(ctx context.Context) error {
+		return d.Client.ContainerExecStart(ctx, execID, config)
+	})
+}
+
+// Not retried: attaching starts the exec, which can't be started twice
+func (d *Docker) ContainerExecAttach(ctx context.Context, execID string, config types.ExecStartCheck) (types.HijackedResponse, error) {
+	var res types.HijackedResponse
+	err := d.policy.Do(ctx, "ContainerExecAttach", false, func(ctx context.Context) (err error) {
+		res, err = d.Client.ContainerExecAttach(ctx, execID, config)
+		return err
+	})
+
+	return res, err
+}
@@ -0,0 +1,189 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+
+	"grs/common/resilience"
+)
+
+// Elasticsearch client whose calls go through a resilience.Policy
+type Elastic struct {
+	*elasticsearch.Client
+	policy *resilience.Policy
+}
+
+// Error returned when Elasticsearch answers with an error status
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Elasticsearch answered %d -> %s", e.StatusCode, e.Body)
+}
+
+func NewElastic() (*Elastic, error) {
+	es, err := elasticsearch.NewDefaultClient()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("In NewElastic: Failed to create Elasticsearch client -> %s", err))
+	}
+
+	return &Elastic{
+		Client: es,
+		policy: &resilience.Policy{
+			Service:  "elasticsearch",
+			Backoff:  DEFAULT_BACKOFF,
+			Breaker:  resilience.NewCircuitBreaker(BREAKER_THRESHOLD, BREAKER_COOLDOWN),
+			Classify: classifyElasticError,
+		},
+	}, nil
+}
+
+// Overloaded or unavailable clusters are retried, requests that were rejected are not
+func classifyElasticError(err error) resilience.Kind {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case 429, 502, 503, 504:
+			return resilience.Transient
+		}
+
+		return resilience.Permanent
+	}
+
+	return resilience.Transient
+}
+
+// Returns true if calls to Elasticsearch are currently being rejected by the circuit breaker
+func (e *Elastic) IsDown() bool {
+	return e.policy.Breaker.IsOpen()
+}
+
+// Runs an esapi request built by newRequest, retrying it if idempotent. The body of a successful response is returned
+func (e *Elastic) Do(ctx context.Context, op string, idempotent bool, newRequest func() esapi.Request) ([]byte, error) {
+	var body []byte
+
+	err := e.policy.Do(ctx, op, idempotent, func(ctx context.Context) error {
+		res, err := newRequest().Do(ctx, e.Client)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		body, err = io.ReadAll(res.Body)
+		if err != nil {
+			return err
+		}
+
+		if res.IsError() {
+			return &StatusError{StatusCode: res.StatusCode, Body: string(body)}
+		}
+
+		return nil
+	})
+
+	return body, err
+}
+
+// Indexes one JSON document. Retrying may index a duplicate sample, which is better than losing it
+func (e *Elastic) Index(ctx context.Context, index string, document []byte) error {
+	_, err := e.Do(ctx, "Index", true, func() esapi.Request {
+		return esapi.IndexRequest{
+			Index:   index,
+			Body:    bytes.NewReader(document),
+			Refresh: "true",
+		}
+	})
+
+	return err
+}
+
+// Sends a _bulk request with an NDJSON body. The response body is returned so the
+// caller can check the result of each item
+func (e *Elastic) Bulk(ctx context.Context, body []byte) ([]byte, error) {
+	return e.Do(ctx, "Bulk", true, func() esapi.Request {
+		return esapi.BulkRequest{
+			Body: bytes.NewReader(body),
+		}
+	})
+}
+
+// Creates or updates an ILM policy
+func (e *Elastic) PutLifecycle(ctx context.Context, policy string, body []byte) error {
+	_, err := e.Do(ctx, "PutLifecycle", true, func() esapi.Request {
+		return esapi.ILMPutLifecycleRequest{
+			Policy: policy,
+			Body:   bytes.NewReader(body),
+		}
+	})
+
+	return err
+}
+
+// Creates or updates a composable index template
+func (e *Elastic) PutIndexTemplate(ctx context.Context, name string, body []byte) error {
+	_, err := e.Do(ctx, "PutIndexTemplate", true, func() esapi.Request {
+		return esapi.IndicesPutIndexTemplateRequest{
+			Name: name,
+			Body: bytes.NewReader(body),
+		}
+	})
+
+	return err
+}
+
+// Returns the composable index template called name, as answered by GET _index_template/<name>
+func (e *Elastic) GetIndexTemplate(ctx context.Context, name string) ([]byte, error) {
+	return e.Do(ctx, "GetIndexTemplate", true, func() esapi.Request {
+		return esapi.IndicesGetIndexTemplateRequest{
+			Name: name,
+		}
+	})
+}
+
+// Marks a data stream to roll over to a new backing index on its next write, so the index
+// template in place applies from then on
+func (e *Elastic) Rollover(ctx context.Context, dataStream string) error {
+	lazy := true
+
+	_, err := e.Do(ctx, "Rollover", true, func() esapi.Request {
+		return esapi.IndicesRolloverRequest{
+			Alias: dataStream,
+			Lazy:  &lazy,
+		}
+	})
+
+	return err
+}
+
+// Returns the indices, aliases and data streams called name, as answered by GET _resolve/index/<name>
+func (e *Elastic) ResolveIndex(ctx context.Context, name string) ([]byte, error) {
+	return e.Do(ctx, "ResolveIndex", true, func() esapi.Request {
+		return esapi.IndicesResolveIndexRequest{
+			Name: []string{name},
+		}
+	})
+}
+
+// Returns true if err is an answer of Elasticsearch saying that what was asked for doesn't exist
+func IsNotFound(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == 404
+}
+
+// Runs a search request on index and returns the response body
+func (e *Elastic) Search(ctx context.Context, index string, body []byte) ([]byte, error) {
+	return e.Do(ctx, "Search", true, func() esapi.Request {
+		return esapi.SearchRequest{
+			Index: []string{index},
+			Body:  bytes.NewReader(body),
+		}
+	})
+}
@@ -0,0 +1,59 @@
+// Publishes application events to every registered handler
+package events
+
+import (
+	"sync"
+	"time"
+
+	. "grs/common/types"
+)
+
+// Event types
+const COLLECTION_FAILED string = "collection_failed"
+const SCALE_UP_FAILED string = "scale_up_failed"
+const SCALE_DOWN_FAILED string = "scale_down_failed"
+const ROLLBACK string = "rollback"
+const NGINX_RELOAD_FAILED string = "nginx_reload_failed"
+const INDEX_FAILED string = "index_failed"
+const SHUTDOWN_FAILED string = "shutdown_failed"
+const PROVISION_FAILED string = "provision_failed"
+const CONFIG_RELOAD_FAILED string = "config_reload_failed"
+const REPLICA_REPLACED string = "replica_replaced"
+const REPLACEMENT_FAILED string = "replacement_failed"
+
+var (
+	mu       sync.RWMutex
+	handlers []func(Event)
+)
+
+// Registers a handler that is called with every published event
+func Subscribe(handler func(Event)) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	handlers = append(handlers, handler)
+}
+
+// Sends an event to every handler
+func Publish(e Event) {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(e)
+	}
+}
+
+// Publishes a failure of the given type
+func Failure(source string, eventType string, message string, err error) {
+	Publish(Event{
+		Source:  source,
+		Type:    eventType,
+		Message: message,
+		Error:   err.Error(),
+	})
+}
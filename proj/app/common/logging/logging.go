@@ -0,0 +1,118 @@
+// Configures the structured logger shared by every package
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	. "grs/common/types"
+)
+
+// Log formats
+const FORMAT_TEXT string = "text"
+const FORMAT_JSON string = "json"
+
+// Level of the default logger, it can be changed while the application runs
+var level = new(slog.LevelVar)
+
+type contextKey struct{}
+
+// Makes the default logger write to stderr with the level and format of the config file.
+// The standard log package goes through it too
+func Setup(config *Config) error {
+	if err := SetLevel(config.Logging.Level); err != nil {
+		return err
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+
+	switch config.Logging.Format {
+	case FORMAT_TEXT:
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case FORMAT_JSON:
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		return errors.New(fmt.Sprintf("In logging.Setup: Unknown log format %s", config.Logging.Format))
+	}
+
+	slog.SetDefault(slog.New(handler))
+
+	return nil
+}
+
+// Changes the level of the default logger to debug, info, warn or error
+func SetLevel(name string) error {
+	var l slog.Level
+
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return errors.New(fmt.Sprintf("In logging.SetLevel: Unknown log level %s", name))
+	}
+
+	level.Set(l)
+
+	return nil
+}
+
+func Level() slog.Level {
+	return level.Level()
+}
+
+// Returns a context whose logger is logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// Returns the logger of ctx, with the attributes of the iteration it belongs to, or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+
+	return slog.Default()
+}
+
+// Returns the logger of ctx for one component of the application
+func Component(ctx context.Context, name string) *slog.Logger {
+	return FromContext(ctx).With("component", name)
+}
+
+// Logs err and exits. Used when the application can't start
+func Fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// Serves the level of the default logger on GET and changes it on PUT, with the level as body
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			body, err := io.ReadAll(io.LimitReader(r.Body, 64))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if err := SetLevel(strings.TrimSpace(string(body))); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			slog.Info("Log level changed", "component", "logging", "level", Level().String())
+		default:
+			http.Error(w, "only GET and PUT are supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		fmt.Fprintln(w, strings.ToLower(Level().String()))
+	})
+}
@@ -0,0 +1,29 @@
+package resilience
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Exponential backoff between attempts of the same call
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Attempts   int
+}
+
+// Returns how long to wait before retrying after the given attempt (starting at 1).
+// Half of the delay is random so clients failing together don't retry together
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := float64(b.Initial) * math.Pow(b.Multiplier, float64(attempt-1))
+
+	if d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+
+	half := d / 2
+
+	return time.Duration(half + rand.Float64()*half)
+}
@@ -0,0 +1,91 @@
+package resilience
+
+import (
+	"sync"
+	"time"
+)
+
+type breakerState int
+
+const (
+	closed breakerState = iota
+	open
+	halfOpen
+)
+
+// Stops calling a service after Threshold consecutive failures. After Cooldown one
+// call is let through: if it succeeds the breaker closes again, otherwise it stays open
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+	}
+}
+
+// Returns ErrCircuitOpen if calls are not allowed right now
+func (cb *CircuitBreaker) Allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case open:
+		if time.Since(cb.openedAt) < cb.Cooldown {
+			return ErrCircuitOpen
+		}
+		cb.state = halfOpen
+		return nil
+	case halfOpen:
+		// A trial call is already in flight
+		return ErrCircuitOpen
+	}
+
+	return nil
+}
+
+func (cb *CircuitBreaker) Success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.state = closed
+	cb.failures = 0
+}
+
+func (cb *CircuitBreaker) Failure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures++
+
+	if cb.state == halfOpen || cb.failures >= cb.Threshold {
+		cb.state = open
+		cb.openedAt = time.Now()
+	}
+}
+
+// Gives up on a trial call without counting it as a success or a failure
+func (cb *CircuitBreaker) Abort() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == halfOpen {
+		cb.state = open
+	}
+}
+
+// Returns true if the breaker is currently rejecting calls
+func (cb *CircuitBreaker) IsOpen() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	return cb.state != closed
+}
@@ -0,0 +1,61 @@
+// Implements retries with exponential backoff and a circuit breaker for calls to external services
+package resilience
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Tells whether a failed call is worth retrying
+type Kind int
+
+const (
+	// The call may succeed if retried (connection refused, timeout, 5xx...)
+	Transient Kind = iota
+	// Retrying won't help (not found, bad request...)
+	Permanent
+	// The call was not made because the circuit breaker is open
+	CircuitOpen
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Transient:
+		return "transient"
+	case Permanent:
+		return "permanent"
+	case CircuitOpen:
+		return "circuit open"
+	}
+
+	return "unknown"
+}
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Error returned by every call made through a Policy
+type Error struct {
+	Service  string
+	Op       string
+	Kind     Kind
+	Attempts int
+	Err      error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("In %s.%s: %s error after %d attempt(s) -> %s", e.Service, e.Op, e.Kind, e.Attempts, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Returns true if err is a resilience Error of the given kind
+func IsKind(err error, kind Kind) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind == kind
+	}
+
+	return false
+}
@@ -0,0 +1,82 @@
+package resilience
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"grs/common/telemetry"
+)
+
+// Retries and circuit breaking applied to every call to one service
+type Policy struct {
+	Service  string
+	Backoff  Backoff
+	Breaker  *CircuitBreaker
+	Classify func(error) Kind
+}
+
+// Runs fn through the circuit breaker. Transient failures are retried with backoff,
+// but only if the call is idempotent, since a lost response may hide a call that went through
+func (p *Policy) Do(ctx context.Context, op string, idempotent bool, fn func(context.Context) error) (err error) {
+	ctx, span := telemetry.StartSpan(ctx, fmt.Sprintf("%s.%s", p.Service, op))
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	attempts := 1
+	if idempotent {
+		attempts = max(p.Backoff.Attempts, 1)
+	}
+
+	kind := Transient
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if breakerErr := p.Breaker.Allow(); breakerErr != nil {
+			return &Error{Service: p.Service, Op: op, Kind: CircuitOpen, Attempts: attempt - 1, Err: breakerErr}
+		}
+
+		start := time.Now()
+		err = fn(ctx)
+		telemetry.ObserveCall(p.Service, op, time.Since(start), err)
+
+		if err == nil {
+			p.Breaker.Success()
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			// Our own cancellation says nothing about the service
+			p.Breaker.Abort()
+			return &Error{Service: p.Service, Op: op, Kind: Permanent, Attempts: attempt, Err: err}
+		}
+
+		kind = p.classify(err)
+
+		if kind == Permanent {
+			// The service answered, so it is up
+			p.Breaker.Success()
+			return &Error{Service: p.Service, Op: op, Kind: kind, Attempts: attempt, Err: err}
+		}
+
+		p.Breaker.Failure()
+
+		if attempt == attempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return &Error{Service: p.Service, Op: op, Kind: Permanent, Attempts: attempt, Err: ctx.Err()}
+		case <-time.After(p.Backoff.Delay(attempt)):
+		}
+	}
+
+	return &Error{Service: p.Service, Op: op, Kind: kind, Attempts: attempts, Err: err}
+}
+
+func (p *Policy) classify(err error) Kind {
+	if p.Classify == nil {
+		return Transient
+	}
+
+	return p.Classify(err)
+}
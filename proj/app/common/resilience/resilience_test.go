@@ -0,0 +1,238 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	backoff := Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}
+
+	tests := []struct {
+		attempt int
+		// The delay before jitter, half of it is random
+		full time.Duration
+	}{
+		{attempt: 1, full: 100 * time.Millisecond},
+		{attempt: 2, full: 200 * time.Millisecond},
+		{attempt: 3, full: 400 * time.Millisecond},
+		{attempt: 4, full: 800 * time.Millisecond},
+		{attempt: 5, full: time.Second},
+		{attempt: 50, full: time.Second},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 100; i++ {
+			if d := backoff.Delay(test.attempt); d < test.full/2 || d > test.full {
+				t.Fatalf("Delay(%d) = %s, want between %s and %s", test.attempt, d, test.full/2, test.full)
+			}
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	const (
+		allow    = "allow"
+		reject   = "reject"
+		success  = "success"
+		failure  = "failure"
+		abort    = "abort"
+		cooldown = "cooldown"
+	)
+
+	tests := []struct {
+		name string
+		// allow and reject call Allow and expect it to let the call through or not, cooldown lets
+		// the cooldown pass, the others report the outcome of a call
+		steps []string
+		open  bool
+	}{
+		{name: "closed", steps: []string{allow, failure, allow, failure}},
+		{name: "opens at the threshold", steps: []string{allow, failure, allow, failure, allow, failure, reject}, open: true},
+		{name: "a success resets the failures", steps: []string{failure, failure, success, failure, failure, allow}},
+		{name: "trial call after the cooldown", steps: []string{failure, failure, failure, reject, cooldown, allow}, open: true},
+		{name: "one trial call at a time", steps: []string{failure, failure, failure, cooldown, allow, reject}, open: true},
+		{name: "trial success closes", steps: []string{failure, failure, failure, cooldown, allow, success, allow, failure, allow}},
+		{name: "trial failure reopens", steps: []string{failure, failure, failure, cooldown, allow, failure, reject}, open: true},
+		// A single failure in half-open is enough, whatever the threshold
+		{name: "trial failure waits a new cooldown", steps: []string{failure, failure, failure, cooldown, allow, failure, cooldown, allow}, open: true},
+		{name: "aborted trial stays open", steps: []string{failure, failure, failure, cooldown, allow, abort, cooldown, allow, success}},
+		{name: "abort while closed", steps: []string{abort, allow}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cb := NewCircuitBreaker(3, time.Hour)
+
+			for i, step := range test.steps {
+				switch step {
+				case allow, reject:
+					err := cb.Allow()
+					if (step == allow) != (err == nil) {
+						t.Fatalf("step %d: Allow() = %v, want %s", i, err, step)
+					}
+					if err != nil && !errors.Is(err, ErrCircuitOpen) {
+						t.Fatalf("step %d: Allow() = %v, want ErrCircuitOpen", i, err)
+					}
+				case success:
+					cb.Success()
+				case failure:
+					cb.Failure()
+				case abort:
+					cb.Abort()
+				case cooldown:
+					cb.openedAt = cb.openedAt.Add(-cb.Cooldown)
+				}
+			}
+
+			if cb.IsOpen() != test.open {
+				t.Errorf("IsOpen() = %v, want %v", cb.IsOpen(), test.open)
+			}
+		})
+	}
+}
+
+func TestPolicyDo(t *testing.T) {
+	errTransient := errors.New("connection refused")
+	errPermanent := errors.New("not found")
+
+	classify := func(err error) Kind {
+		if errors.Is(err, errPermanent) {
+			return Permanent
+		}
+		return Transient
+	}
+
+	tests := []struct {
+		name       string
+		idempotent bool
+		// Errors returned by each call, nil for a success. Calls past the end succeed
+		results  []error
+		calls    int
+		err      error
+		kind     Kind
+		attempts int
+		open     bool
+	}{
+		{name: "success", idempotent: true, results: []error{nil}, calls: 1},
+		{name: "retried until success", idempotent: true, results: []error{errTransient, errTransient, nil}, calls: 3},
+		{
+			name:       "attempts exhausted",
+			idempotent: true,
+			results:    []error{errTransient, errTransient, errTransient, errTransient},
+			calls:      3,
+			err:        errTransient,
+			kind:       Transient,
+			attempts:   3,
+		},
+		{
+			name:       "not idempotent, not retried",
+			idempotent: false,
+			results:    []error{errTransient, nil},
+			calls:      1,
+			err:        errTransient,
+			kind:       Transient,
+			attempts:   1,
+		},
+		{
+			name:       "permanent, not retried",
+			idempotent: true,
+			results:    []error{errPermanent, nil},
+			calls:      1,
+			err:        errPermanent,
+			kind:       Permanent,
+			attempts:   1,
+		},
+		{
+			// The breaker opens after 2 failures, so the third attempt is not made
+			name:       "stopped by the breaker",
+			idempotent: true,
+			results:    []error{errTransient, errTransient, errTransient},
+			calls:      2,
+			err:        ErrCircuitOpen,
+			kind:       CircuitOpen,
+			attempts:   2,
+			open:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			threshold := 5
+			if test.open {
+				threshold = 2
+			}
+
+			policy := &Policy{
+				Service:  "test",
+				Backoff:  Backoff{Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 2, Attempts: 3},
+				Breaker:  NewCircuitBreaker(threshold, time.Hour),
+				Classify: classify,
+			}
+
+			calls := 0
+			err := policy.Do(context.Background(), "op", test.idempotent, func(context.Context) error {
+				calls++
+				if calls <= len(test.results) {
+					return test.results[calls-1]
+				}
+				return nil
+			})
+
+			if calls != test.calls {
+				t.Errorf("%d calls, want %d", calls, test.calls)
+			}
+
+			if test.err == nil {
+				if err != nil {
+					t.Fatalf("Do() error = %v, want nil", err)
+				}
+				return
+			}
+
+			var resilienceErr *Error
+			if !errors.As(err, &resilienceErr) {
+				t.Fatalf("Do() error = %v, want a resilience Error", err)
+			}
+
+			if !errors.Is(err, test.err) || resilienceErr.Kind != test.kind || resilienceErr.Attempts != test.attempts {
+				t.Errorf("Do() error = %v (%s, %d attempts), want %v (%s, %d attempts)",
+					err, resilienceErr.Kind, resilienceErr.Attempts, test.err, test.kind, test.attempts)
+			}
+
+			if !IsKind(err, test.kind) {
+				t.Errorf("IsKind(%v, %s) = false", err, test.kind)
+			}
+
+			if policy.Breaker.IsOpen() != test.open {
+				t.Errorf("breaker open = %v, want %v", policy.Breaker.IsOpen(), test.open)
+			}
+		})
+	}
+}
+
+func TestPolicyDoCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	policy := &Policy{
+		Service: "test",
+		Backoff: Backoff{Initial: time.Hour, Max: time.Hour, Multiplier: 2, Attempts: 3},
+		Breaker: NewCircuitBreaker(1, time.Hour),
+	}
+
+	err := policy.Do(ctx, "op", true, func(context.Context) error {
+		cancel()
+		return context.Canceled
+	})
+
+	if !IsKind(err, Permanent) {
+		t.Errorf("Do() error = %v, want a permanent error", err)
+	}
+
+	// Our own cancellation says nothing about the service
+	if policy.Breaker.IsOpen() {
+		t.Error("breaker opened by a canceled call")
+	}
+}
@@ -0,0 +1,200 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	. "grs/common/types"
+)
+
+// Name of the tracer and meter of the application
+const INSTRUMENTATION_NAME string = "grs"
+
+// The global providers are no-ops until SetupOpenTelemetry is called, and then the tracer
+// and the instruments created here delegate to the real ones
+var (
+	tracer = otel.Tracer(INSTRUMENTATION_NAME)
+	meter  = otel.Meter(INSTRUMENTATION_NAME)
+)
+
+var (
+	otelIterationDuration, _ = meter.Float64Histogram("grs.loop.iteration.duration",
+		metric.WithDescription("Time taken by one iteration of the collect and scale loop"), metric.WithUnit("s"))
+
+	otelCallDuration, _ = meter.Float64Histogram("grs.api.call.duration",
+		metric.WithDescription("Latency of the calls to the external APIs, one observation per attempt"), metric.WithUnit("s"))
+
+	otelCallErrors, _ = meter.Int64Counter("grs.api.call.errors",
+		metric.WithDescription("Failed attempts to call the external APIs"))
+
+	otelNginxReloads, _ = meter.Int64Counter("grs.nginx.reloads",
+		metric.WithDescription("Nginx reloads after a configuration change, by result"))
+
+	otelDecisions, _ = meter.Int64Counter("grs.scaler.decisions",
+		metric.WithDescription("Decisions taken by the scaler, by action and outcome"))
+
+	otelReplacements, _ = meter.Int64Counter("grs.replica.replacements",
+		metric.WithDescription("Replicas replaced because they failed their liveness checks, exited or ran out of memory, by reason and result"))
+)
+
+// Last replica counts of every service, read by the observable gauges
+var (
+	replicasMu    sync.Mutex
+	replicaCounts = map[string]int64{}
+	desiredCounts = map[string]int64{}
+)
+
+func init() {
+	current, _ := meter.Int64ObservableGauge("grs.service.replicas",
+		metric.WithDescription("Replicas of the service running when the scaler last evaluated it"))
+	desired, _ := meter.Int64ObservableGauge("grs.service.desired_replicas",
+		metric.WithDescription("Replicas of the service wanted by the last decision of the scaler"))
+
+	meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		replicasMu.Lock()
+		defer replicasMu.Unlock()
+
+		for service, count := range replicaCounts {
+			o.ObserveInt64(current, count, metric.WithAttributes(attribute.String("service", service)))
+		}
+
+		for service, count := range desiredCounts {
+			o.ObserveInt64(desired, count, metric.WithAttributes(attribute.String("service", service)))
+		}
+
+		return nil
+	}, current, desired)
+}
+
+// Exports traces and metrics over OTLP/HTTP to config.OpenTelemetry.Endpoint. Does nothing
+// if no endpoint is configured. The returned function flushes and stops the exporters
+func SetupOpenTelemetry(ctx context.Context, config *Config) (func(context.Context) error, error) {
+	if config.OpenTelemetry.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(config.OpenTelemetry.ServiceName)))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("In telemetry.SetupOpenTelemetry: Failed to create resource -> %s", err))
+	}
+
+	traceOptions := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.OpenTelemetry.Endpoint)}
+	metricOptions := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpoint(config.OpenTelemetry.Endpoint)}
+
+	if config.OpenTelemetry.Insecure {
+		traceOptions = append(traceOptions, otlptracehttp.WithInsecure())
+		metricOptions = append(metricOptions, otlpmetrichttp.WithInsecure())
+	}
+
+	traceExporter, err := otlptracehttp.New(ctx, traceOptions...)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("In telemetry.SetupOpenTelemetry: Failed to create trace exporter -> %s", err))
+	}
+
+	metricExporter, err := otlpmetrichttp.New(ctx, metricOptions...)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("In telemetry.SetupOpenTelemetry: Failed to create metric exporter -> %s", err))
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter), sdktrace.WithResource(res))
+	meterProvider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter, sdkmetric.WithInterval(config.OpenTelemetry.MetricInterval))),
+		sdkmetric.WithResource(res),
+	)
+
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetMeterProvider(meterProvider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	shutdown := func(ctx context.Context) error {
+		return errors.Join(tracerProvider.Shutdown(ctx), meterProvider.Shutdown(ctx))
+	}
+
+	return shutdown, nil
+}
+
+// Starts a span that is a child of the span in ctx, if any
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Ends span, marking it as failed if err is not nil
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// Records the decision of the scaler on span and counts it
+func RecordDecision(ctx context.Context, span trace.Span, service string, decision *Decision) {
+	span.SetAttributes(
+		attribute.String("service", service),
+		attribute.String("decision.id", decision.ID),
+		attribute.String("decision.action", decision.Action),
+		attribute.String("decision.outcome", decision.Outcome),
+		attribute.String("decision.reason", decision.Reason),
+		attribute.Int("decision.current_replicas", decision.CurrentReplicas),
+		attribute.Int("decision.desired_replicas", decision.DesiredReplicas),
+		attribute.StringSlice("decision.containers", decision.Containers),
+	)
+
+	otelDecisions.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("service", service),
+		attribute.String("action", decision.Action),
+		attribute.String("outcome", decision.Outcome),
+	))
+}
+
+func recordIteration(elapsed time.Duration) {
+	otelIterationDuration.Record(context.Background(), elapsed.Seconds())
+}
+
+func recordCall(service string, op string, elapsed time.Duration, err error) {
+	attrs := metric.WithAttributes(attribute.String("service", service), attribute.String("op", op))
+
+	otelCallDuration.Record(context.Background(), elapsed.Seconds(), attrs)
+
+	if err != nil {
+		otelCallErrors.Add(context.Background(), 1, attrs)
+	}
+}
+
+func recordNginxReload(result string) {
+	otelNginxReloads.Add(context.Background(), 1, metric.WithAttributes(attribute.String("result", result)))
+}
+
+func recordReplicas(service string, current int, desired int) {
+	replicasMu.Lock()
+	defer replicasMu.Unlock()
+
+	replicaCounts[service] = int64(current)
+	desiredCounts[service] = int64(desired)
+}
+
+func recordReplacement(service string, reason string, result string) {
+	otelReplacements.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("service", service),
+		attribute.String("reason", reason),
+		attribute.String("result", result),
+	))
+}
@@ -0,0 +1,192 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+
+	. "grs/common/types"
+)
+
+// OTLP/HTTP receiver keeping the metrics it is sent. Traces are accepted and ignored
+type otlpReceiver struct {
+	mu       sync.Mutex
+	requests []*collectormetrics.ExportMetricsServiceRequest
+}
+
+func (r *otlpReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var response proto.Message = &collectormetrics.ExportMetricsServiceResponse{}
+
+	switch req.URL.Path {
+	case "/v1/metrics":
+		request := &collectormetrics.ExportMetricsServiceRequest{}
+		if err := proto.Unmarshal(body, request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		r.mu.Lock()
+		r.requests = append(r.requests, request)
+		r.mu.Unlock()
+	case "/v1/traces":
+		// Answered with an empty message, which decodes as any response
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	out, _ := proto.Marshal(response)
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(out)
+}
+
+// Returns the last data points received for every metric, by name, and the service name of the resource
+func (r *otlpReceiver) metrics() (map[string]*metricspb.Metric, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	metrics := map[string]*metricspb.Metric{}
+	serviceName := ""
+
+	for _, request := range r.requests {
+		for _, resourceMetrics := range request.ResourceMetrics {
+			for _, attr := range resourceMetrics.Resource.GetAttributes() {
+				if attr.Key == "service.name" {
+					serviceName = attr.Value.GetStringValue()
+				}
+			}
+
+			for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+				for _, metric := range scopeMetrics.Metrics {
+					metrics[metric.Name] = metric
+				}
+			}
+		}
+	}
+
+	return metrics, serviceName
+}
+
+// Returns the attributes of a data point as sorted key=value pairs joined by commas
+func pointKey(attrs []*commonpb.KeyValue) string {
+	var pairs []string
+	for _, attr := range attrs {
+		pairs = append(pairs, attr.Key+"="+attr.Value.GetStringValue())
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+func TestSetupOpenTelemetryExportsMetrics(t *testing.T) {
+	receiver := &otlpReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	config := &Config{}
+	config.OpenTelemetry.Endpoint = strings.TrimPrefix(server.URL, "http://")
+	config.OpenTelemetry.Insecure = true
+	config.OpenTelemetry.ServiceName = "grs-test"
+	// Only the export on shutdown runs during the test
+	config.OpenTelemetry.MetricInterval = time.Hour
+
+	ctx := context.Background()
+
+	shutdown, err := SetupOpenTelemetry(ctx, config)
+	if err != nil {
+		t.Fatalf("SetupOpenTelemetry() error = %v", err)
+	}
+
+	SetReplicas("web", 2, 3)
+	ObserveIteration(250 * time.Millisecond)
+	ObserveCall("docker", "ContainerList", 10*time.Millisecond, nil)
+	ObserveCall("docker", "ContainerList", 20*time.Millisecond, errors.New("timeout"))
+	ObserveNginxReload(nil)
+	ObserveNginxReload(nil)
+	ObserveNginxReload(errors.New("nginx: [emerg]"))
+	ObserveReplacement("web", "oom", nil)
+
+	_, span := StartSpan(ctx, "scale")
+	RecordDecision(ctx, span, "web", &Decision{Action: "scale_up", Outcome: "success"})
+	EndSpan(span, nil)
+
+	if err := shutdown(ctx); err != nil {
+		t.Fatalf("shutdown() error = %v", err)
+	}
+
+	metrics, serviceName := receiver.metrics()
+	if len(metrics) == 0 {
+		t.Fatal("no metrics were exported")
+	}
+
+	if serviceName != "grs-test" {
+		t.Errorf("service.name = %q, want grs-test", serviceName)
+	}
+
+	tests := []struct {
+		metric string
+		// Value of every data point, by its attributes. Histograms give their count of observations
+		points map[string]float64
+	}{
+		{metric: "grs.service.replicas", points: map[string]float64{"service=web": 2}},
+		{metric: "grs.service.desired_replicas", points: map[string]float64{"service=web": 3}},
+		{metric: "grs.loop.iteration.duration", points: map[string]float64{"": 1}},
+		{metric: "grs.api.call.duration", points: map[string]float64{"op=ContainerList,service=docker": 2}},
+		{metric: "grs.api.call.errors", points: map[string]float64{"op=ContainerList,service=docker": 1}},
+		{metric: "grs.nginx.reloads", points: map[string]float64{"result=success": 2, "result=failure": 1}},
+		{metric: "grs.replica.replacements", points: map[string]float64{"reason=oom,result=success,service=web": 1}},
+		{metric: "grs.scaler.decisions", points: map[string]float64{"action=scale_up,outcome=success,service=web": 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.metric, func(t *testing.T) {
+			metric, ok := metrics[test.metric]
+			if !ok {
+				t.Fatalf("%s was not exported", test.metric)
+			}
+
+			points := map[string]float64{}
+
+			switch data := metric.Data.(type) {
+			case *metricspb.Metric_Sum:
+				for _, point := range data.Sum.DataPoints {
+					points[pointKey(point.Attributes)] = float64(point.GetAsInt())
+				}
+			case *metricspb.Metric_Gauge:
+				for _, point := range data.Gauge.DataPoints {
+					points[pointKey(point.Attributes)] = float64(point.GetAsInt())
+				}
+			case *metricspb.Metric_Histogram:
+				for _, point := range data.Histogram.DataPoints {
+					points[pointKey(point.Attributes)] = float64(point.Count)
+				}
+			default:
+				t.Fatalf("%s has unexpected data %T", test.metric, metric.Data)
+			}
+
+			for key, value := range test.points {
+				if points[key] != value {
+					t.Errorf("%s{%s} = %v, want %v (got %v)", test.metric, key, points[key], value, points)
+				}
+			}
+		})
+	}
+}
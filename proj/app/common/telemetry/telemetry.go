@@ -0,0 +1,199 @@
+// Exposes metrics about the autoscaler itself, for Prometheus to scrape and over OTLP, and traces every iteration
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Results of an Nginx reload
+const RELOAD_SUCCESS string = "success"
+const RELOAD_FAILURE string = "failure"
+
+// Results of the replacement of a replica
+const REPLACEMENT_SUCCESS string = "success"
+const REPLACEMENT_FAILURE string = "failure"
+
+var registry = prometheus.NewRegistry()
+
+var (
+	replicas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grs_service_replicas",
+		Help: "Replicas of the service running when the scaler last evaluated it",
+	}, []string{"service"})
+
+	desiredReplicas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grs_service_desired_replicas",
+		Help: "Replicas of the service wanted by the last decision of the scaler",
+	}, []string{"service"})
+
+	iterationDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "grs_loop_iteration_duration_seconds",
+		Help:    "Time taken by one iteration of the collect and scale loop",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+	})
+
+	callDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grs_api_call_duration_seconds",
+		Help:    "Latency of the calls to the Docker, Elasticsearch, Grafana and InfluxDB APIs, one observation per attempt",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"service", "op"})
+
+	callErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grs_api_call_errors_total",
+		Help: "Failed attempts to call the Docker, Elasticsearch, Grafana and InfluxDB APIs",
+	}, []string{"service", "op"})
+
+	nginxReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grs_nginx_reloads_total",
+		Help: "Nginx reloads after a configuration change, by result",
+	}, []string{"result"})
+
+	lastCollection = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "grs_last_successful_collection_timestamp_seconds",
+		Help: "Unix time of the last iteration where the stats of the replicas were collected",
+	})
+
+	replacements = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grs_replica_replacements_total",
+		Help: "Replicas replaced because they failed their liveness checks, exited or ran out of memory, by reason and result",
+	}, []string{"service", "reason", "result"})
+)
+
+// Where the Elasticsearch counters are read from, set by the last RegisterElasticsearchCounters
+var (
+	elasticMu       sync.Mutex
+	elasticCounts   func() map[string]int64
+	registerElastic sync.Once
+)
+
+func init() {
+	registry.MustRegister(
+		replicas,
+		desiredReplicas,
+		iterationDuration,
+		callDuration,
+		callErrors,
+		nginxReloads,
+		lastCollection,
+		replacements,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Starts serving the metrics on http://<address>/metrics.
+// Call Shutdown on the returned server to stop
+func Serve(address string) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	// Listen right away so a port already in use is reported at startup
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("In telemetry.Serve: Failed to listen on %s -> %s", address, err))
+	}
+
+	server := &http.Server{Handler: mux}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Stopped serving metrics", "component", "telemetry", "error", err)
+		}
+	}()
+
+	return server, nil
+}
+
+// Records the running and desired replicas of a service
+func SetReplicas(service string, current int, desired int) {
+	replicas.WithLabelValues(service).Set(float64(current))
+	desiredReplicas.WithLabelValues(service).Set(float64(desired))
+	recordReplicas(service, current, desired)
+}
+
+func ObserveIteration(elapsed time.Duration) {
+	iterationDuration.Observe(elapsed.Seconds())
+	recordIteration(elapsed)
+}
+
+// Records one attempt to call an API. err is the error of the attempt, if any
+func ObserveCall(service string, op string, elapsed time.Duration, err error) {
+	callDuration.WithLabelValues(service, op).Observe(elapsed.Seconds())
+
+	if err != nil {
+		callErrors.WithLabelValues(service, op).Inc()
+	}
+
+	recordCall(service, op, elapsed, err)
+}
+
+// Records the result of an Nginx reload
+func ObserveNginxReload(err error) {
+	if err != nil {
+		nginxReloads.WithLabelValues(RELOAD_FAILURE).Inc()
+		recordNginxReload(RELOAD_FAILURE)
+		return
+	}
+
+	nginxReloads.WithLabelValues(RELOAD_SUCCESS).Inc()
+	recordNginxReload(RELOAD_SUCCESS)
+}
+
+// Records the replacement of a replica of service. err is the error of the replacement, if any
+func ObserveReplacement(service string, reason string, err error) {
+	result := REPLACEMENT_SUCCESS
+	if err != nil {
+		result = REPLACEMENT_FAILURE
+	}
+
+	replacements.WithLabelValues(service, reason, result).Inc()
+	recordReplacement(service, reason, result)
+}
+
+func SetLastCollection(timestamp time.Time) {
+	lastCollection.Set(float64(timestamp.Unix()))
+}
+
+// Reports the documents of the Elasticsearch sink, read from counts, as grs_elasticsearch_documents_total.
+// The counters are registered once, so a new sink only replaces where they are read from
+func RegisterElasticsearchCounters(counts func() map[string]int64) error {
+	elasticMu.Lock()
+	elasticCounts = counts
+	elasticMu.Unlock()
+
+	var err error
+
+	registerElastic.Do(func() {
+		for _, result := range []string{"indexed", "spooled", "replayed", "dropped"} {
+			result := result
+
+			counter := prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name:        "grs_elasticsearch_documents_total",
+				Help:        "Documents given to the Elasticsearch sink, by what happened to them",
+				ConstLabels: prometheus.Labels{"result": result},
+			}, func() float64 {
+				elasticMu.Lock()
+				defer elasticMu.Unlock()
+
+				return float64(elasticCounts()[result])
+			})
+
+			if registerErr := registry.Register(counter); registerErr != nil {
+				err = errors.New(fmt.Sprintf("In telemetry.RegisterElasticsearchCounters: Failed to register counter -> %s", registerErr))
+				return
+			}
+		}
+	})
+
+	return err
+}
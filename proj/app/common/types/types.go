@@ -1,9 +1,15 @@
 package types
 
-import "sort"
+import (
+	"time"
+)
 
 // Holds the metrics collected from a container
 type Metrics struct {
+	// When Docker read the metrics, and read the previous ones that the CPU usage is computed against
+	Read    time.Time `json:"read"`
+	PreRead time.Time `json:"preread"`
+
 	MemStats struct {
 		Stats struct {
 			Cache float64 `json:"cache"`
@@ -26,65 +32,225 @@ type Metrics struct {
 		} `json:"cpu_usage"`
 		SystemCPUUsage float64 `json:"system_cpu_usage"`
 	} `json:"precpu_stats"`
+
+	Networks map[string]struct {
+		RxBytes uint64 `json:"rx_bytes"`
+		TxBytes uint64 `json:"tx_bytes"`
+		RxPackets uint64 `json:"rx_packets"`
+		TxPackets uint64 `json:"tx_packets"`
+	} `json:"networks"`
 }
 
-// Holds relevant metrics of a container
+// Holds relevant metrics of a container, sampled at Timestamp. The JSON fields are the schema of the
+// stats documents, see sinks.StatsDocument. Usages are percentages: memory of the limit of the container,
+// CPU of one CPU, so it is above 100 when the container uses more than one
 type Stats struct {
-	UsedMemory float64
-	AvailableMemory float64
-	MemoryUsage string
-	NumberOfCPUs int16
-	CPUUsage string
+	Timestamp     time.Time         `json:"@timestamp"`
+	ContainerID   string            `json:"container_id"`
+	ContainerName string            `json:"container_name"`
+	Image         string            `json:"image"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	// Time the CPU usage is measured over, between the previous sample of Docker and this one. 0 if unknown
+	IntervalMs      float64 `json:"interval_ms"`
+	UsedMemory      float64 `json:"used_memory"`
+	AvailableMemory float64 `json:"available_memory"`
+	MemoryUsage     float64 `json:"memory_usage"`
+	NumberOfCPUs    int16   `json:"number_of_cpus"`
+	CPUUsage        float64 `json:"cpu_usage"`
+}
+
+// Holds the network traffic of the load balancer. The counters only grow while the container runs
+type LoadBalancerStats struct {
+	RxBytes uint64 `json:"rx_bytes"`
+	TxBytes uint64 `json:"tx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	TxPackets uint64 `json:"tx_packets"`
+}
+
+// Outcome of one evaluation of the scaler, with everything needed to explain it
+type Decision struct {
+	ID              string         `json:"id"`
+	Timestamp       time.Time      `json:"@timestamp"`
+	Policy          string         `json:"policy"`
+	Inputs          DecisionInputs `json:"inputs"`
+	CurrentReplicas int            `json:"current_replicas"`
+	DesiredReplicas int            `json:"desired_replicas"`
+	Action          string         `json:"action"`
+	Reason          string         `json:"reason"`
+	Containers      []string       `json:"containers,omitempty"`
+	Upstream        []string       `json:"upstream,omitempty"`
+	DryRun          bool           `json:"dry_run,omitempty"`
+	Outcome         string         `json:"outcome"`
+	Error           string         `json:"error,omitempty"`
+	DurationMs      float64        `json:"duration_ms"`
+}
+
+// What the scaler looked at to take a decision
+type DecisionInputs struct {
+	Samples            int     `json:"samples"`
+	AvgCPUUsage        float64 `json:"avg_cpu_usage"`
+	MaxCPUUsage        float64 `json:"max_cpu_usage"`
+	AvgMemoryUsage     float64 `json:"avg_memory_usage"`
+	MaxMemoryUsage     float64 `json:"max_memory_usage"`
+	CPUThreshold       float64 `json:"cpu_threshold"`
+	MemoryThreshold    float64 `json:"memory_threshold"`
+	MinReplicas        int     `json:"min_replicas"`
+	TriggerCPUUsage    float64 `json:"trigger_cpu_usage"`
+	TriggerMemoryUsage float64 `json:"trigger_memory_usage"`
 }
 
 // Holds data parsed from the application's config file
 type Config struct {
-	Period string `yaml:"period"`
+	Period time.Duration `yaml:"period"`
+
+	Service string `yaml:"service"`
 
 	Metrics struct {
 		CPU struct {
-			Threshold string `yaml:"threshold"`
+			Threshold float64 `yaml:"threshold"`
 		} `yaml:"cpu"`
 
 		Memory struct {
-			Threshold string `yaml:"threshold"`
+			Threshold float64 `yaml:"threshold"`
 		} `yaml:"memory"`
 		
 	} `yaml:"metrics"`
-}
 
-type Pair[K comparable, V comparable] struct {
-	Key K
-	Value V
-}
+	MinReplicas int `yaml:"min_replicas"`
 
-type StatsSorter struct {
-	stats []Pair[string, Stats]
-	by func(s1, s2 Stats) bool
-}
+	DryRun bool `yaml:"dry_run"`
 
-type By func(s1, s2 Stats) bool
+	Elasticsearch struct {
+		Index string `yaml:"index"`
+		BatchSize int `yaml:"batch_size"`
+		FlushInterval time.Duration `yaml:"flush_interval"`
+		SpoolPath string `yaml:"spool_path"`
+		SpoolMaxSize int64 `yaml:"spool_max_size"`
+		Retention string `yaml:"retention"`
+		RolloverMaxAge string `yaml:"rollover_max_age"`
+		RolloverMaxSize string `yaml:"rollover_max_size"`
+		DecisionIndex string `yaml:"decision_index"`
+		LoadBalancerIndex string `yaml:"load_balancer_index"`
+	} `yaml:"elasticsearch"`
 
-func (by By) Sort(stats []Pair[string, Stats]) {
-	ss := &StatsSorter{
-		stats: stats,
-		by: by,
-	}
+	Sinks []string `yaml:"sinks"`
 
-	sort.Sort(ss)
-}
+	Prometheus struct {
+		Address string `yaml:"address"`
+	} `yaml:"prometheus"`
 
-// Len is part of sort.Interface
-func (s *StatsSorter) Len() int {
-	return len(s.stats)
-}
+	InfluxDB struct {
+		URL string `yaml:"url"`
+		Token string `yaml:"token"`
+	} `yaml:"influxdb"`
+
+	Grafana struct {
+		URL string `yaml:"url"`
+		User string `yaml:"user"`
+		Password string `yaml:"password"`
+		Token string `yaml:"token"`
+		Provision bool `yaml:"provision"`
+		ElasticsearchURL string `yaml:"elasticsearch_url"`
+		DatasourceAddress string `yaml:"datasource_address"`
+		DatasourceURL string `yaml:"datasource_url"`
+		DatasourceToken string `yaml:"datasource_token"`
+	} `yaml:"grafana"`
+
+	JSONL struct {
+		Path string `yaml:"path"`
+		MaxSize int64 `yaml:"max_size"`
+		MaxBackups int `yaml:"max_backups"`
+	} `yaml:"jsonl"`
+
+	Control struct {
+		Address string `yaml:"address"`
+		GRPCAddress string `yaml:"grpc_address"`
+		TokensFile string `yaml:"tokens_file"`
+		TLS struct {
+			CertFile string `yaml:"cert_file"`
+			KeyFile string `yaml:"key_file"`
+			ClientCAFile string `yaml:"client_ca_file"`
+		} `yaml:"tls"`
+	} `yaml:"control"`
+
+	Telemetry struct {
+		Address string `yaml:"address"`
+	} `yaml:"telemetry"`
+
+	Logging struct {
+		Level string `yaml:"level"`
+		Format string `yaml:"format"`
+	} `yaml:"logging"`
+
+	OpenTelemetry struct {
+		Endpoint string `yaml:"endpoint"`
+		Insecure bool `yaml:"insecure"`
+		ServiceName string `yaml:"service_name"`
+		MetricInterval time.Duration `yaml:"metric_interval"`
+	} `yaml:"opentelemetry"`
+
+	OnShutdown string `yaml:"on_shutdown"`
+
+	// How a scale down picks the replica to stop
+	VictimSelection struct {
+		Strategy string `yaml:"strategy"`
+		// Used by the weighted strategy
+		Weights struct {
+			CPU float64 `yaml:"cpu"`
+			Memory float64 `yaml:"memory"`
+			Connections float64 `yaml:"connections"`
+			Age float64 `yaml:"age"`
+		} `yaml:"weights"`
+		// Strategy by service name, overriding Strategy
+		Services map[string]string `yaml:"services"`
+	} `yaml:"victim_selection"`
+
+	// How a scale down stops the replica it picked
+	Drain struct {
+		// Longest wait for the requests the replica is serving to finish, once it is out of the load balancer
+		Timeout time.Duration `yaml:"timeout"`
+		// Time the replica has to exit after SIGTERM, before it is killed
+		GracePeriod time.Duration `yaml:"grace_period"`
+	} `yaml:"drain"`
+
+	// How a new replica is checked before it is added to the load balancer
+	Readiness struct {
+		// none, http, tcp or docker
+		Check string `yaml:"check"`
+		// Port of the replica the http and tcp checks connect to
+		Port int `yaml:"port"`
+		// Path the http check requests, and the status it expects
+		Path string `yaml:"path"`
+		ExpectedStatus int `yaml:"expected_status"`
+		// Checks in a row the replica must pass to be ready
+		SuccessThreshold int `yaml:"success_threshold"`
+		// Time between two checks, also the longest a check can take
+		Interval time.Duration `yaml:"interval"`
+		// Longest wait for the replica to be ready, after which it is removed
+		Timeout time.Duration `yaml:"timeout"`
+	} `yaml:"readiness"`
 
-// Less is part of sort.Interface
-func (s *StatsSorter) Less(i, j int) bool {
-	return s.by(s.stats[i].Value, s.stats[j].Value)
+	// How the replicas in the load balancer are checked while they run. One that fails is replaced
+	Liveness struct {
+		// none, http, tcp or docker
+		Check string `yaml:"check"`
+		// Port of the replica the http and tcp checks connect to
+		Port int `yaml:"port"`
+		// Path the http check requests, and the status it expects
+		Path string `yaml:"path"`
+		ExpectedStatus int `yaml:"expected_status"`
+		// Checks in a row a replica must fail to be replaced
+		FailureThreshold int `yaml:"failure_threshold"`
+		// Time between two checks of a replica, also the longest a check can take
+		Interval time.Duration `yaml:"interval"`
+	} `yaml:"liveness"`
 }
 
-// Swap if part of sort.Interface
-func (s *StatsSorter) Swap(i, j int) {
-	s.stats[i], s.stats[j] = s.stats[j], s.stats[i] 
+// Something that happened in the application that operators should know about, like a failed scale action
+type Event struct {
+	Timestamp time.Time `json:"timestamp"`
+	Source    string    `json:"source"`
+	Type      string    `json:"type"`
+	Message   string    `json:"message"`
+	Error     string    `json:"error,omitempty"`
 }
@@ -0,0 +1,446 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	logging "grs/common/logging"
+	. "grs/common/types"
+)
+
+// A problem with a field of the config file, at the line and column of its value (or of its
+// section, if it is missing). Line is 0 when the problem isn't tied to a place in the file
+type ConfigError struct {
+	Line    int
+	Column  int
+	Field   string
+	Message string
+	// The environment variable or flag the value comes from, like "env GRS_PERIOD", if it isn't from the file
+	Source string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Source != "" {
+		return fmt.Sprintf("%s: %s: %s", e.Source, e.Field, e.Message)
+	}
+
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.Field, e.Message)
+	}
+
+	return fmt.Sprintf("line %d, column %d: %s: %s", e.Line, e.Column, e.Field, e.Message)
+}
+
+// Returns the problems of a config error returned by ConfigParser, one per field
+func ConfigErrors(err error) []*ConfigError {
+	var configErrors []*ConfigError
+
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			configErrors = append(configErrors, ConfigErrors(e)...)
+		}
+
+		return configErrors
+	}
+
+	var configErr *ConfigError
+	if errors.As(err, &configErr) {
+		return []*ConfigError{configErr}
+	}
+
+	if err != nil {
+		configErrors = append(configErrors, &ConfigError{Field: "config", Message: err.Error()})
+	}
+
+	return configErrors
+}
+
+// Decodes a config file strictly: every key must be a field of the config, and every value must have
+// the type of its field. Keeps the node of every field found, by its path like "metrics.cpu.threshold"
+type configDecoder struct {
+	nodes map[string]*yaml.Node
+	// Fields set by an override, see ConfigOverride
+	sources map[string]ConfigSource
+	// Fields whose value couldn't be decoded, so their range isn't checked too
+	invalid map[string]bool
+	errors  []error
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func (d *configDecoder) fail(node *yaml.Node, field string, format string, args ...any) {
+	configErr := &ConfigError{Line: node.Line, Column: node.Column, Field: field, Message: fmt.Sprintf(format, args...)}
+	if source, ok := d.sources[field]; ok {
+		configErr.Source = source.String()
+	}
+
+	d.errors = append(d.errors, configErr)
+}
+
+// Returns the problems found, in the order of the file
+func (d *configDecoder) err() error {
+	sort.SliceStable(d.errors, func(i, j int) bool {
+		a, b := d.errors[i].(*ConfigError), d.errors[j].(*ConfigError)
+		return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+	})
+
+	return errors.Join(d.errors...)
+}
+
+// Returns the node of path, or of its closest section found in the file, to point an error at
+func (d *configDecoder) at(path string) *yaml.Node {
+	for path != "" {
+		if node, ok := d.nodes[path]; ok {
+			return node
+		}
+
+		i := strings.LastIndex(path, ".")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+
+	return &yaml.Node{}
+}
+
+func (d *configDecoder) decode(node *yaml.Node, out reflect.Value, path string) {
+	// An empty file has no document, every field gets its default
+	if node.Kind == 0 {
+		return
+	}
+
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) > 0 {
+			d.decode(node.Content[0], out, path)
+		}
+		return
+	}
+
+	if path != "" {
+		d.nodes[path] = node
+	}
+
+	// An empty value, like "period:", leaves the field to its default
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+
+	if out.Kind() == reflect.Struct && out.Type() != durationType {
+		if node.Kind != yaml.MappingNode {
+			d.fail(node, fieldName(path), "must be a mapping of fields")
+			return
+		}
+
+		fields := map[string]int{}
+		for i := 0; i < out.NumField(); i++ {
+			fields[yamlName(out.Type().Field(i))] = i
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+
+			field := key.Value
+			if path != "" {
+				field = path + "." + key.Value
+			}
+
+			index, ok := fields[key.Value]
+			if !ok {
+				d.fail(key, field, "unknown field")
+				continue
+			}
+
+			d.decode(value, out.Field(index), field)
+		}
+
+		return
+	}
+
+	if err := node.Decode(out.Addr().Interface()); err != nil {
+		d.fail(node, path, "%q is not %s", node.Value, typeDescription(out.Type()))
+		d.invalid[path] = true
+	}
+}
+
+func fieldName(path string) string {
+	if path == "" {
+		return "config"
+	}
+
+	return path
+}
+
+func yamlName(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("yaml"), ",")[0]
+}
+
+func typeDescription(t reflect.Type) string {
+	switch {
+	case t == durationType:
+		return "a duration like 5s, 1m30s or 500ms"
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return "a number"
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return "an integer"
+	case t.Kind() == reflect.Bool:
+		return "true or false"
+	case t.Kind() == reflect.Slice:
+		return "a list of " + strings.TrimPrefix(typeDescription(t.Elem()), "a ") + "s"
+	case t.Kind() == reflect.Map:
+		return "a mapping of names to " + strings.TrimPrefix(typeDescription(t.Elem()), "a ") + "s"
+	}
+
+	return "a string"
+}
+
+// Settings of a field of the config file that the JSON Schema and ConfigParser share
+type fieldRule struct {
+	Enum    []string
+	Minimum *float64
+	Maximum *float64
+	// Values above Minimum only, not equal to it
+	ExclusiveMinimum bool
+	Default          any
+}
+
+func bound(value float64) *float64 {
+	return &value
+}
+
+var VICTIM_STRATEGIES = []string{VICTIM_LEAST_CPU, VICTIM_LEAST_MEMORY, VICTIM_FEWEST_CONNECTIONS, VICTIM_NEWEST, VICTIM_OLDEST, VICTIM_WEIGHTED}
+
+var PROBES = []string{PROBE_NONE, PROBE_HTTP, PROBE_TCP, PROBE_DOCKER}
+
+// Values, ranges and defaults of the fields of the config file, by path. The defaults are set by ConfigParser
+var CONFIG_RULES = map[string]fieldRule{
+	"period":                               {Minimum: bound(float64(MIN_PERIOD)), Default: DEFAULT_PERIOD.String()},
+	"service":                              {Default: DEFAULT_SERVICE},
+	"metrics.cpu.threshold":                {Minimum: bound(0), ExclusiveMinimum: true, Default: DEFAULT_CPU_THRESHOLD},
+	"metrics.memory.threshold":             {Minimum: bound(0), ExclusiveMinimum: true, Maximum: bound(100), Default: DEFAULT_MEMORY_THRESHOLD},
+	"min_replicas":                         {Minimum: bound(1), Default: DEFAULT_MIN_REPLICAS},
+	"elasticsearch.index":                  {Default: DEFAULT_ES_INDEX},
+	"elasticsearch.batch_size":             {Minimum: bound(1), Default: DEFAULT_ES_BATCH_SIZE},
+	"elasticsearch.flush_interval":         {Minimum: bound(0), ExclusiveMinimum: true, Default: DEFAULT_ES_FLUSH_INTERVAL.String()},
+	"elasticsearch.spool_path":             {Default: DEFAULT_ES_SPOOL_PATH},
+	"elasticsearch.spool_max_size":         {Minimum: bound(1), Default: DEFAULT_ES_SPOOL_MAX_SIZE},
+	"elasticsearch.retention":              {Default: DEFAULT_ES_RETENTION},
+	"elasticsearch.rollover_max_age":       {Default: DEFAULT_ES_ROLLOVER_MAX_AGE},
+	"elasticsearch.rollover_max_size":      {Default: DEFAULT_ES_ROLLOVER_MAX_SIZE},
+	"elasticsearch.decision_index":         {Default: DEFAULT_ES_DECISION_INDEX},
+	"elasticsearch.load_balancer_index":    {Default: DEFAULT_ES_LOAD_BALANCER_INDEX},
+	"sinks":                                {Enum: []string{SINK_ELASTICSEARCH, SINK_PROMETHEUS, SINK_INFLUXDB, SINK_JSONL, SINK_GRAFANA, SINK_GRAFANA_JSON}, Default: []string{SINK_ELASTICSEARCH}},
+	"prometheus.address":                   {Default: DEFAULT_PROMETHEUS_ADDRESS},
+	"grafana.url":                          {Default: DEFAULT_GRAFANA_URL},
+	"grafana.elasticsearch_url":            {Default: DEFAULT_GRAFANA_ELASTICSEARCH_URL},
+	"grafana.datasource_address":           {Default: DEFAULT_GRAFANA_DATASOURCE_ADDRESS},
+	"grafana.datasource_url":               {Default: DEFAULT_GRAFANA_DATASOURCE_URL},
+	"jsonl.path":                           {Default: DEFAULT_JSONL_PATH},
+	"jsonl.max_size":                       {Minimum: bound(1), Default: DEFAULT_JSONL_MAX_SIZE},
+	"jsonl.max_backups":                    {Minimum: bound(1), Default: DEFAULT_JSONL_MAX_BACKUPS},
+	"control.address":                      {Default: DEFAULT_CONTROL_ADDRESS},
+	"control.grpc_address":                 {Default: DEFAULT_CONTROL_GRPC_ADDRESS},
+	"telemetry.address":                    {Default: DEFAULT_TELEMETRY_ADDRESS},
+	"logging.level":                        {Enum: []string{"debug", "info", "warn", "error"}, Default: DEFAULT_LOG_LEVEL},
+	"logging.format":                       {Enum: []string{logging.FORMAT_TEXT, logging.FORMAT_JSON}, Default: DEFAULT_LOG_FORMAT},
+	"opentelemetry.service_name":           {Default: DEFAULT_OTEL_SERVICE_NAME},
+	"opentelemetry.metric_interval":        {Minimum: bound(0), ExclusiveMinimum: true, Default: DEFAULT_OTEL_METRIC_INTERVAL.String()},
+	"on_shutdown":                          {Enum: []string{ON_SHUTDOWN_NONE, ON_SHUTDOWN_SCALE_TO_MIN}, Default: ON_SHUTDOWN_NONE},
+	"victim_selection.strategy":            {Enum: VICTIM_STRATEGIES, Default: DEFAULT_VICTIM_STRATEGY},
+	"victim_selection.weights.cpu":         {Minimum: bound(0), Default: DEFAULT_VICTIM_WEIGHT_CPU},
+	"victim_selection.weights.memory":      {Minimum: bound(0), Default: DEFAULT_VICTIM_WEIGHT_MEMORY},
+	"victim_selection.weights.connections": {Minimum: bound(0), Default: DEFAULT_VICTIM_WEIGHT_CONNECTIONS},
+	"victim_selection.weights.age":         {Minimum: bound(0), Default: DEFAULT_VICTIM_WEIGHT_AGE},
+	"victim_selection.services":            {Enum: VICTIM_STRATEGIES},
+	"drain.timeout":                        {Minimum: bound(0), ExclusiveMinimum: true, Default: DEFAULT_DRAIN_TIMEOUT.String()},
+	"drain.grace_period":                   {Minimum: bound(0), ExclusiveMinimum: true, Default: DEFAULT_DRAIN_GRACE_PERIOD.String()},
+	"readiness.check":                      {Enum: PROBES, Default: DEFAULT_READINESS_CHECK},
+	"readiness.port":                       {Minimum: bound(1), Maximum: bound(65535), Default: DEFAULT_READINESS_PORT},
+	"readiness.path":                       {Default: DEFAULT_READINESS_PATH},
+	"readiness.expected_status":            {Minimum: bound(100), Maximum: bound(599), Default: DEFAULT_READINESS_EXPECTED_STATUS},
+	"readiness.success_threshold":          {Minimum: bound(1), Default: DEFAULT_READINESS_SUCCESS_THRESHOLD},
+	"readiness.interval":                   {Minimum: bound(0), ExclusiveMinimum: true, Default: DEFAULT_READINESS_INTERVAL.String()},
+	"readiness.timeout":                    {Minimum: bound(0), ExclusiveMinimum: true, Default: DEFAULT_READINESS_TIMEOUT.String()},
+	"liveness.check":                       {Enum: PROBES, Default: DEFAULT_LIVENESS_CHECK},
+	"liveness.port":                        {Minimum: bound(1), Maximum: bound(65535), Default: DEFAULT_LIVENESS_PORT},
+	"liveness.path":                        {Default: DEFAULT_LIVENESS_PATH},
+	"liveness.expected_status":             {Minimum: bound(100), Maximum: bound(599), Default: DEFAULT_LIVENESS_EXPECTED_STATUS},
+	"liveness.failure_threshold":           {Minimum: bound(1), Default: DEFAULT_LIVENESS_FAILURE_THRESHOLD},
+	"liveness.interval":                    {Minimum: bound(0), ExclusiveMinimum: true, Default: DEFAULT_LIVENESS_INTERVAL.String()},
+}
+
+// Checks the value of every field that has a rule and was set in the file
+func (d *configDecoder) check(config *Config) {
+	root := reflect.ValueOf(config).Elem()
+
+	for path, rule := range CONFIG_RULES {
+		node, ok := d.nodes[path]
+		if !ok || node.Tag == "!!null" || d.invalid[path] {
+			continue
+		}
+
+		value := lookup(root, path)
+
+		if rule.Enum != nil {
+			values := []string{value.String()}
+			nodes := []*yaml.Node{node}
+			switch value.Kind() {
+			case reflect.Slice:
+				values = value.Interface().([]string)
+				nodes = node.Content
+			case reflect.Map:
+				// The values of a mapping, like the strategy of each service
+				values, nodes = nil, nil
+				for i := 0; i+1 < len(node.Content); i += 2 {
+					values = append(values, node.Content[i+1].Value)
+					nodes = append(nodes, node.Content[i+1])
+				}
+			}
+
+			for i, v := range values {
+				if !contains(rule.Enum, v) {
+					d.fail(nodes[min(i, len(nodes)-1)], path, "%q is not one of %s", v, strings.Join(rule.Enum, ", "))
+				}
+			}
+		}
+
+		var number float64
+		switch value.Kind() {
+		case reflect.Int, reflect.Int64:
+			number = float64(value.Int())
+		case reflect.Float64:
+			number = value.Float()
+		default:
+			continue
+		}
+
+		display := node.Value
+
+		switch {
+		case rule.Minimum != nil && rule.ExclusiveMinimum && number <= *rule.Minimum:
+			d.fail(node, path, "must be above %s, got %s", formatBound(value.Type(), *rule.Minimum), display)
+		case rule.Minimum != nil && !rule.ExclusiveMinimum && number < *rule.Minimum:
+			d.fail(node, path, "must be at least %s, got %s", formatBound(value.Type(), *rule.Minimum), display)
+		case rule.Maximum != nil && number > *rule.Maximum:
+			d.fail(node, path, "must be at most %s, got %s", formatBound(value.Type(), *rule.Maximum), display)
+		}
+	}
+}
+
+func formatBound(t reflect.Type, value float64) string {
+	if t == durationType {
+		return time.Duration(value).String()
+	}
+
+	return fmt.Sprint(value)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the field of root at path, a dotted path of YAML names
+func lookup(root reflect.Value, path string) reflect.Value {
+	value := root
+
+	for _, name := range strings.Split(path, ".") {
+		for i := 0; i < value.NumField(); i++ {
+			if yamlName(value.Type().Field(i)) == name {
+				value = value.Field(i)
+				break
+			}
+		}
+	}
+
+	return value
+}
+
+// Returns the JSON Schema of the config file, for editors to complete and check it
+func ConfigSchema() map[string]any {
+	schema := typeSchema(reflect.TypeOf(Config{}), "")
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "GRS autoscaler config"
+
+	return schema
+}
+
+func typeSchema(t reflect.Type, path string) map[string]any {
+	var schema map[string]any
+
+	switch {
+	case t == durationType:
+		schema = map[string]any{"type": "string", "pattern": `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`}
+	case t.Kind() == reflect.Struct:
+		properties := map[string]any{}
+		for i := 0; i < t.NumField(); i++ {
+			name := yamlName(t.Field(i))
+
+			field := name
+			if path != "" {
+				field = path + "." + name
+			}
+
+			properties[name] = typeSchema(t.Field(i).Type, field)
+		}
+
+		schema = map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+	case t.Kind() == reflect.Slice:
+		schema = map[string]any{"type": "array", "items": typeSchema(t.Elem(), "")}
+	case t.Kind() == reflect.Map:
+		schema = map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem(), "")}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		schema = map[string]any{"type": "number"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		schema = map[string]any{"type": "integer"}
+	case t.Kind() == reflect.Bool:
+		schema = map[string]any{"type": "boolean"}
+	default:
+		schema = map[string]any{"type": "string"}
+	}
+
+	rule, ok := CONFIG_RULES[path]
+	if !ok {
+		return schema
+	}
+
+	if rule.Default != nil {
+		schema["default"] = rule.Default
+	}
+
+	if rule.Enum != nil {
+		if items, ok := schema["items"].(map[string]any); ok {
+			items["enum"] = rule.Enum
+		} else if values, ok := schema["additionalProperties"].(map[string]any); ok {
+			values["enum"] = rule.Enum
+		} else {
+			schema["enum"] = rule.Enum
+		}
+	}
+
+	// Durations are strings in the file, their range is only checked by ConfigParser
+	if t != durationType {
+		if rule.Minimum != nil && rule.ExclusiveMinimum {
+			schema["exclusiveMinimum"] = *rule.Minimum
+		} else if rule.Minimum != nil {
+			schema["minimum"] = *rule.Minimum
+		}
+
+		if rule.Maximum != nil {
+			schema["maximum"] = *rule.Maximum
+		}
+	}
+
+	return schema
+}
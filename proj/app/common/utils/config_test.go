@@ -0,0 +1,263 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	. "grs/common/types"
+)
+
+func TestConfigParser(t *testing.T) {
+	tests := []struct {
+		name   string
+		yaml   string
+		check  func(*Config) any
+		expect any
+	}{
+		{
+			name:   "empty file gets the default period",
+			yaml:   "",
+			check:  func(c *Config) any { return c.Period },
+			expect: DEFAULT_PERIOD,
+		},
+		{
+			name:   "empty value gets the default threshold",
+			yaml:   "metrics:\n  cpu:\n    threshold:\n",
+			check:  func(c *Config) any { return c.Metrics.CPU.Threshold },
+			expect: DEFAULT_CPU_THRESHOLD,
+		},
+		{
+			name:   "duration",
+			yaml:   "period: 1m30s\n",
+			check:  func(c *Config) any { return c.Period },
+			expect: 90 * time.Second,
+		},
+		{
+			name:   "sinks default to elasticsearch",
+			yaml:   "period: 10s\n",
+			check:  func(c *Config) any { return c.Sinks },
+			expect: []string{SINK_ELASTICSEARCH},
+		},
+		{
+			name:   "a weight set to 0 is kept",
+			yaml:   "victim_selection:\n  weights:\n    cpu: 0\n",
+			check:  func(c *Config) any { return c.VictimSelection.Weights.CPU },
+			expect: float64(0),
+		},
+		{
+			name:   "an unset weight gets its default",
+			yaml:   "victim_selection:\n  weights:\n    cpu: 0\n",
+			check:  func(c *Config) any { return c.VictimSelection.Weights.Memory },
+			expect: DEFAULT_VICTIM_WEIGHT_MEMORY,
+		},
+		{
+			name:   "service strategies",
+			yaml:   "victim_selection:\n  services:\n    api: oldest\n",
+			check:  func(c *Config) any { return c.VictimSelection.Services["api"] },
+			expect: VICTIM_OLDEST,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err, config := ConfigParser([]byte(test.yaml))
+			if err != nil {
+				t.Fatalf("ConfigParser() error = %v", err)
+			}
+
+			if got := test.check(config); !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("got %v, want %v", got, test.expect)
+			}
+		})
+	}
+}
+
+func TestConfigParserErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		yaml   string
+		errors []ConfigError
+	}{
+		{
+			name:   "unknown field",
+			yaml:   "period: 5s\nmetrics:\n  cpu:\n    treshold: 50\n",
+			errors: []ConfigError{{Line: 4, Column: 5, Field: "metrics.cpu.treshold", Message: "unknown field"}},
+		},
+		{
+			name:   "wrong type",
+			yaml:   "period: five\n",
+			errors: []ConfigError{{Line: 1, Column: 9, Field: "period", Message: `"five" is not a duration like 5s, 1m30s or 500ms`}},
+		},
+		{
+			name:   "not a mapping",
+			yaml:   "metrics: 50\n",
+			errors: []ConfigError{{Line: 1, Column: 10, Field: "metrics", Message: "must be a mapping of fields"}},
+		},
+		{
+			name:   "exclusive minimum",
+			yaml:   "metrics:\n  cpu:\n    threshold: 0\n",
+			errors: []ConfigError{{Line: 3, Column: 16, Field: "metrics.cpu.threshold", Message: "must be above 0, got 0"}},
+		},
+		{
+			name:   "maximum",
+			yaml:   "metrics:\n  memory:\n    threshold: 150\n",
+			errors: []ConfigError{{Line: 3, Column: 16, Field: "metrics.memory.threshold", Message: "must be at most 100, got 150"}},
+		},
+		{
+			name: "enum of a list",
+			yaml: "sinks:\n  - elasticsearch\n  - kafka\n",
+			errors: []ConfigError{{Line: 3, Column: 5, Field: "sinks",
+				Message: `"kafka" is not one of elasticsearch, prometheus, influxdb, jsonl, grafana, grafana_json`}},
+		},
+		{
+			name: "errors in the order of the file",
+			yaml: "min_replicas: 0\nperiod: 500ms\nlogging:\n  level: verbose\n",
+			errors: []ConfigError{
+				{Line: 1, Column: 15, Field: "min_replicas", Message: "must be at least 1, got 0"},
+				{Line: 2, Column: 9, Field: "period", Message: "must be at least " + MIN_PERIOD.String() + ", got 500ms"},
+				{Line: 4, Column: 10, Field: "logging.level", Message: `"verbose" is not one of debug, info, warn, error`},
+			},
+		},
+		{
+			name:   "path without a slash",
+			yaml:   "readiness:\n  path: healthz\n",
+			errors: []ConfigError{{Line: 2, Column: 9, Field: "readiness.path", Message: "must start with /"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err, config := ConfigParser([]byte(test.yaml))
+			if err == nil {
+				t.Fatalf("ConfigParser() = %+v, want an error", config)
+			}
+
+			var got []ConfigError
+			for _, configErr := range ConfigErrors(err) {
+				got = append(got, *configErr)
+			}
+
+			if !reflect.DeepEqual(got, test.errors) {
+				t.Errorf("ConfigErrors() = %+v, want %+v", got, test.errors)
+			}
+		})
+	}
+}
+
+func TestLayeredConfigParser(t *testing.T) {
+	file := "period: 10s\nmetrics:\n  cpu:\n    threshold: 50\n"
+
+	env := func(field string, value string) ConfigOverride {
+		return ConfigOverride{Field: field, Value: value, Layer: CONFIG_LAYER_ENV, Name: ConfigEnvName(field)}
+	}
+	flag := func(field string, value string) ConfigOverride {
+		return ConfigOverride{Field: field, Value: value, Layer: CONFIG_LAYER_FLAG, Name: field}
+	}
+
+	tests := []struct {
+		name      string
+		overrides []ConfigOverride
+		field     string
+		expect    any
+		source    ConfigSource
+	}{
+		{
+			name:   "file",
+			field:  "metrics.cpu.threshold",
+			expect: float64(50),
+			source: ConfigSource{Layer: CONFIG_LAYER_FILE, Line: 4},
+		},
+		{
+			name:   "default",
+			field:  "min_replicas",
+			expect: DEFAULT_MIN_REPLICAS,
+			source: ConfigSource{Layer: CONFIG_LAYER_DEFAULT},
+		},
+		{
+			name:      "env over the file",
+			overrides: []ConfigOverride{env("metrics.cpu.threshold", "60")},
+			field:     "metrics.cpu.threshold",
+			expect:    float64(60),
+			source:    ConfigSource{Layer: CONFIG_LAYER_ENV, Name: "GRS_METRICS_CPU_THRESHOLD"},
+		},
+		{
+			name:      "flag over env",
+			overrides: []ConfigOverride{env("metrics.cpu.threshold", "60"), flag("metrics.cpu.threshold", "70")},
+			field:     "metrics.cpu.threshold",
+			expect:    float64(70),
+			source:    ConfigSource{Layer: CONFIG_LAYER_FLAG, Name: "metrics.cpu.threshold"},
+		},
+		{
+			name:      "section missing from the file",
+			overrides: []ConfigOverride{env("drain.timeout", "45s")},
+			field:     "drain.timeout",
+			expect:    45 * time.Second,
+			source:    ConfigSource{Layer: CONFIG_LAYER_ENV, Name: "GRS_DRAIN_TIMEOUT"},
+		},
+		{
+			name:      "comma separated list",
+			overrides: []ConfigOverride{env("sinks", "elasticsearch, jsonl")},
+			field:     "sinks",
+			expect:    []string{SINK_ELASTICSEARCH, SINK_JSONL},
+			source:    ConfigSource{Layer: CONFIG_LAYER_ENV, Name: "GRS_SINKS"},
+		},
+		{
+			name:      "comma separated mapping",
+			overrides: []ConfigOverride{flag("victim_selection.services", "api=oldest,web=newest")},
+			field:     "victim_selection.services",
+			expect:    map[string]string{"api": VICTIM_OLDEST, "web": VICTIM_NEWEST},
+			source:    ConfigSource{Layer: CONFIG_LAYER_FLAG, Name: "victim_selection.services"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err, config, sources := LayeredConfigParser([]byte(file), test.overrides)
+			if err != nil {
+				t.Fatalf("LayeredConfigParser() error = %v", err)
+			}
+
+			if got := ConfigValue(config, test.field); !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("%s = %v, want %v", test.field, got, test.expect)
+			}
+
+			if got := sources[test.field]; got != test.source {
+				t.Errorf("source of %s = %+v, want %+v", test.field, got, test.source)
+			}
+		})
+	}
+}
+
+func TestLayeredConfigParserOverrideErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		override ConfigOverride
+		message  string
+	}{
+		{
+			name:     "env",
+			override: ConfigOverride{Field: "period", Value: "soon", Layer: CONFIG_LAYER_ENV, Name: "GRS_PERIOD"},
+			message:  `env GRS_PERIOD: period: "soon" is not a duration like 5s, 1m30s or 500ms`,
+		},
+		{
+			name:     "flag",
+			override: ConfigOverride{Field: "readiness.check", Value: "ping", Layer: CONFIG_LAYER_FLAG, Name: "readiness.check"},
+			message:  `flag --readiness.check: readiness.check: "ping" is not one of none, http, tcp, docker`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err, _, _ := LayeredConfigParser([]byte("period: 10s\n"), []ConfigOverride{test.override})
+			if err == nil {
+				t.Fatal("LayeredConfigParser() error = nil, want an error")
+			}
+
+			if got := err.Error(); !strings.Contains(got, test.message) {
+				t.Errorf("error = %q, want %q", got, test.message)
+			}
+		})
+	}
+}
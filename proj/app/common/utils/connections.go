@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	clients "grs/common/clients"
+)
+
+// State of an established connection in /proc/net/tcp
+const TCP_ESTABLISHED string = "01"
+
+// Returns the number of connections the load balancer has open to each replica, by IP address. These are
+// the requests Nginx is proxying to the replica, read from the TCP sockets of the load balancer
+func GetActiveConnections(cl *clients.Docker, ctx *context.Context) (map[string]int, error) {
+	output, err := execOutput(GRS_LOAD_BALANCER, []string{"sh", "-c", "cat /proc/net/tcp /proc/net/tcp6 2>/dev/null"}, cl, ctx)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("In GetActiveConnections: Failed to read the sockets of the load balancer -> %s", err))
+	}
+
+	connections := map[string]int{}
+
+	for _, line := range strings.Split(output, "\n") {
+		// sl local_address rem_address st ...
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[3] != TCP_ESTABLISHED {
+			continue
+		}
+
+		address, _, _ := strings.Cut(fields[2], ":")
+
+		ip := parseProcNetIP(address)
+		if ip == nil {
+			continue
+		}
+
+		connections[ip.String()]++
+	}
+
+	return connections, nil
+}
+
+// Parses an address of /proc/net/tcp or tcp6: hexadecimal 32 bits words in host byte order, little endian here
+func parseProcNetIP(address string) net.IP {
+	raw, err := hex.DecodeString(address)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return nil
+	}
+
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		ip[i], ip[i+1], ip[i+2], ip[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+
+	// IPv4 addresses of tcp6 sockets are mapped to IPv6, make them look like the others
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+
+	return ip
+}
+
+// Runs cmd in the container with name containerName and returns what it writes to stdout
+func execOutput(containerName string, cmd []string, cl *clients.Docker, ctx *context.Context) (string, error) {
+	execID, err := cl.ContainerExecCreate(*ctx, containerName, types.ExecConfig{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("In execOutput: Failed to create exec in %s -> %s", containerName, err))
+	}
+
+	response, err := cl.ContainerExecAttach(*ctx, execID.ID, types.ExecStartCheck{})
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("In execOutput: Failed to run exec in %s -> %s", containerName, err))
+	}
+	defer response.Close()
+
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, response.Reader); err != nil {
+		return "", errors.New(fmt.Sprintf("In execOutput: Failed to read the output of exec in %s -> %s", containerName, err))
+	}
+
+	return stdout.String(), nil
+}
+
+// Sends GET path to address:port from the load balancer, the way it proxies the requests, and returns the
+// status the replica answered with. Redirects aren't followed. Uses the wget of the load balancer image
+func HTTPStatusFromLoadBalancer(address string, port int, path string, timeout time.Duration, cl *clients.Docker, ctx *context.Context) (int, error) {
+	url := fmt.Sprintf("http://%s%s", net.JoinHostPort(address, strconv.Itoa(port)), path)
+
+	// wget prints the headers of every answer with -S, the first status line is the one of the replica.
+	// The URL is passed as an argument so the path is never read by the shell
+	script := fmt.Sprintf(`wget -q -S -T %d -O /dev/null "$1" 2>&1 | awk '/^ *HTTP\//{print $2; exit}'`, probeSeconds(timeout))
+
+	output, err := execOutput(GRS_LOAD_BALANCER, []string{"sh", "-c", script, "sh", url}, cl, ctx)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("In HTTPStatusFromLoadBalancer: Failed to run wget in the load balancer -> %s", err))
+	}
+
+	status, err := strconv.Atoi(strings.TrimSpace(output))
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("In HTTPStatusFromLoadBalancer: No answer from %s", url))
+	}
+
+	return status, nil
+}
+
+// Connects to address:port from the load balancer. Returns nil if the connection was accepted
+func DialFromLoadBalancer(address string, port int, timeout time.Duration, cl *clients.Docker, ctx *context.Context) error {
+	script := fmt.Sprintf(`nc -z -w %d "$1" "$2" && echo open`, probeSeconds(timeout))
+
+	output, err := execOutput(GRS_LOAD_BALANCER, []string{"sh", "-c", script, "sh", address, strconv.Itoa(port)}, cl, ctx)
+	if err != nil {
+		return errors.New(fmt.Sprintf("In DialFromLoadBalancer: Failed to run nc in the load balancer -> %s", err))
+	}
+
+	if strings.TrimSpace(output) != "open" {
+		return errors.New(fmt.Sprintf("In DialFromLoadBalancer: %s refused the connection or didn't answer", net.JoinHostPort(address, strconv.Itoa(port))))
+	}
+
+	return nil
+}
+
+// wget and nc take whole seconds, at least one
+func probeSeconds(timeout time.Duration) int {
+	return max(1, int(timeout.Seconds()))
+}
+
+// Returns the IP address of a container on the GRS network, without the prefix length of the endpoint
+func EndpointIP(endpoint types.EndpointResource) string {
+	ip, _, _ := strings.Cut(endpoint.IPv4Address, "/")
+	return ip
+}
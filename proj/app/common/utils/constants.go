@@ -1,9 +1,157 @@
 package utils
 
+import "time"
+
 const GRS_NETWORK string = "grs-net"
 const GRS_IMAGE string = "grs"
 const GRS_LOAD_BALANCER string = "load_balancer"
 
+const DEFAULT_SERVICE string = "web-service"
+
+// Prefix of the environment variables that override the fields of the config file, like GRS_PERIOD
+const CONFIG_ENV_PREFIX string = "GRS_"
+
+// Layers of the config, from the lowest precedence to the highest: a flag overrides an environment
+// variable, which overrides the file, which overrides the defaults
+const CONFIG_LAYER_DEFAULT string = "default"
+const CONFIG_LAYER_FILE string = "file"
+const CONFIG_LAYER_ENV string = "env"
+const CONFIG_LAYER_FLAG string = "flag"
+
+// Defaults of the scaling settings of the config file. CPU usage is a share of one CPU, so its threshold can be over 100
+const DEFAULT_PERIOD time.Duration = 5 * time.Second
+const MIN_PERIOD time.Duration = time.Second
+const DEFAULT_CPU_THRESHOLD float64 = 80
+const DEFAULT_MEMORY_THRESHOLD float64 = 80
+
+const DEFAULT_MIN_REPLICAS int = 1
+
+// Most replicas the control API can hold a service at, so a typo can't start hundreds of containers
+const MAX_MANUAL_REPLICAS int = 50
+
+// Time given to each step of the shutdown, so a slow one doesn't leave the next ones without time.
+// SHUTDOWN_TIMEOUT is the time on_shutdown: scale_to_min has
+const CONTROL_SHUTDOWN_TIMEOUT time.Duration = 5 * time.Second
+const SINK_SHUTDOWN_TIMEOUT time.Duration = 10 * time.Second
+const SHUTDOWN_TIMEOUT time.Duration = 30 * time.Second
+const TELEMETRY_SHUTDOWN_TIMEOUT time.Duration = 5 * time.Second
+
+// Defaults of the elasticsearch section of the config file
+const DEFAULT_ES_INDEX string = "containers"
+const DEFAULT_ES_BATCH_SIZE int = 500
+const DEFAULT_ES_FLUSH_INTERVAL time.Duration = 5 * time.Second
+const DEFAULT_ES_SPOOL_PATH string = "elasticsearch.spool"
+const DEFAULT_ES_SPOOL_MAX_SIZE int64 = 64 * 1024 * 1024
+const DEFAULT_ES_RETENTION string = "7d"
+const DEFAULT_ES_ROLLOVER_MAX_AGE string = "1d"
+const DEFAULT_ES_ROLLOVER_MAX_SIZE string = "5gb"
+
+const DEFAULT_ES_DECISION_INDEX string = "scaling-decisions"
+const DEFAULT_ES_LOAD_BALANCER_INDEX string = "load-balancer"
+
+// Possible values for the sinks field of the config file
+const SINK_ELASTICSEARCH string = "elasticsearch"
+const SINK_PROMETHEUS string = "prometheus"
+const SINK_INFLUXDB string = "influxdb"
+const SINK_JSONL string = "jsonl"
+const SINK_GRAFANA string = "grafana"
+const SINK_GRAFANA_JSON string = "grafana_json"
+
+const DEFAULT_GRAFANA_URL string = "http://localhost:3000"
+const DEFAULT_GRAFANA_ELASTICSEARCH_URL string = "http://elastic:9200"
+const DEFAULT_GRAFANA_DATASOURCE_ADDRESS string = ":9102"
+const DEFAULT_GRAFANA_DATASOURCE_URL string = "http://host.docker.internal:9102"
+const DATASOURCE_HISTORY_SIZE int = 1000
+const CONTROL_DECISION_HISTORY_SIZE int = 1000
+const GRAFANA_PROVISION_ATTEMPTS int = 10
+const GRAFANA_PROVISION_RETRY time.Duration = 15 * time.Second
+const DEFAULT_PROMETHEUS_ADDRESS string = ":9101"
+const DEFAULT_TELEMETRY_ADDRESS string = ":9103"
+const DEFAULT_CONTROL_ADDRESS string = "127.0.0.1:9104"
+const DEFAULT_CONTROL_GRPC_ADDRESS string = "127.0.0.1:9105"
+const CONFIG_WATCH_INTERVAL time.Duration = 2 * time.Second
+const DEFAULT_LOG_LEVEL string = "info"
+const DEFAULT_LOG_FORMAT string = "text"
+const DEFAULT_OTEL_SERVICE_NAME string = "grs-autoscaler"
+const DEFAULT_OTEL_METRIC_INTERVAL time.Duration = 15 * time.Second
+const DEFAULT_JSONL_PATH string = "metrics.jsonl"
+const DEFAULT_JSONL_MAX_SIZE int64 = 10 * 1024 * 1024
+const DEFAULT_JSONL_MAX_BACKUPS int = 5
+
+// Actions of a scaling decision
+const ACTION_SCALE_UP string = "scale_up"
+const ACTION_SCALE_DOWN string = "scale_down"
+const ACTION_NONE string = "none"
+
+// Outcomes of a scaling decision
+const OUTCOME_SUCCESS string = "success"
+const OUTCOME_FAILED string = "failed"
+const OUTCOME_ROLLED_BACK string = "rolled_back"
+const OUTCOME_NOOP string = "noop"
+const OUTCOME_PLANNED string = "planned"
+
+// The desired replicas are the running replicas times usage/threshold of the first replica that is
+// over or under the thresholds, for the metric that needs the most replicas
+const POLICY_MANUAL string = "manual"
+const POLICY_PROPORTIONAL string = "proportional"
+
+// Possible values for the on_shutdown field of the config file
+const ON_SHUTDOWN_NONE string = "none"
+const ON_SHUTDOWN_SCALE_TO_MIN string = "scale_to_min"
+
+// Strategies to pick the replica a scale down stops, see victim_selection in the config file
+const VICTIM_LEAST_CPU string = "least_cpu"
+const VICTIM_LEAST_MEMORY string = "least_memory"
+const VICTIM_FEWEST_CONNECTIONS string = "fewest_connections"
+const VICTIM_NEWEST string = "newest"
+const VICTIM_OLDEST string = "oldest"
+const VICTIM_WEIGHTED string = "weighted"
+
+const DEFAULT_VICTIM_STRATEGY string = VICTIM_LEAST_MEMORY
+
+// Weights of the weighted strategy, each used when it is not set
+const DEFAULT_VICTIM_WEIGHT_CPU float64 = 1
+const DEFAULT_VICTIM_WEIGHT_MEMORY float64 = 1
+const DEFAULT_VICTIM_WEIGHT_CONNECTIONS float64 = 1
+const DEFAULT_VICTIM_WEIGHT_AGE float64 = 0
+
+// Defaults of the drain section of the config file. The connections of a draining replica are checked every DRAIN_POLL_INTERVAL
+const DEFAULT_DRAIN_TIMEOUT time.Duration = 30 * time.Second
+const DEFAULT_DRAIN_GRACE_PERIOD time.Duration = 10 * time.Second
+const DRAIN_POLL_INTERVAL time.Duration = time.Second
+const DRAIN_STOP_SIGNAL string = "SIGTERM"
+
+// Checks a replica can be probed with. none doesn't check it
+const PROBE_NONE string = "none"
+const PROBE_HTTP string = "http"
+const PROBE_TCP string = "tcp"
+const PROBE_DOCKER string = "docker"
+
+// Defaults of the readiness section of the config file
+const DEFAULT_READINESS_CHECK string = PROBE_NONE
+const DEFAULT_READINESS_PORT int = 80
+const DEFAULT_READINESS_PATH string = "/"
+const DEFAULT_READINESS_EXPECTED_STATUS int = 200
+const DEFAULT_READINESS_SUCCESS_THRESHOLD int = 3
+const DEFAULT_READINESS_INTERVAL time.Duration = time.Second
+const DEFAULT_READINESS_TIMEOUT time.Duration = time.Minute
+
+// Defaults of the liveness section of the config file
+const DEFAULT_LIVENESS_CHECK string = PROBE_NONE
+const DEFAULT_LIVENESS_PORT int = 80
+const DEFAULT_LIVENESS_PATH string = "/"
+const DEFAULT_LIVENESS_EXPECTED_STATUS int = 200
+const DEFAULT_LIVENESS_FAILURE_THRESHOLD int = 3
+const DEFAULT_LIVENESS_INTERVAL time.Duration = 5 * time.Second
+
+// Wait before watching the Docker events again, after the stream failed
+const DOCKER_EVENTS_RETRY time.Duration = 5 * time.Second
+
+// Why a replica is replaced: it failed its liveness checks, it exited or it ran out of memory
+const REPLACE_LIVENESS string = "liveness"
+const REPLACE_DIE string = "die"
+const REPLACE_OOM string = "oom"
+
 const NGINX_CONFIG_PATH string = "../load_balancer/config.conf"
 const NGINX_DEFAULT_CONF string = `
 pid /run/nginx;
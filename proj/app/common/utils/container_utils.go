@@ -8,63 +8,122 @@ import (
 	"os"
 
 	"strings"
+	"sync"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/client"
 
 	"github.com/tufanbarisyildirim/gonginx/config"
 	"github.com/tufanbarisyildirim/gonginx/dumper"
 	"github.com/tufanbarisyildirim/gonginx/parser"
+	"go.opentelemetry.io/otel/attribute"
 
+	clients "grs/common/clients"
+	events "grs/common/events"
+	logging "grs/common/logging"
+	telemetry "grs/common/telemetry"
 	. "grs/common/types"
 )
 
-// Returns the stats of container with name containerName
-func GetContainerStats(containerName string, cl *client.Client, ctx *context.Context) (*Stats, error) {
+// Guards the Nginx config file, so the liveness monitor never reads it while the scaler rewrites it. The file
+// is rewritten in place rather than renamed over, since the load balancer mounts it by inode
+var nginxConfigMu sync.RWMutex
 
-	containerID, err := GetContainerID(containerName, cl, ctx)
+// Returns the stats of container with name containerName, with its ID, name, image and labels
+func GetContainerStats(containerName string, cl *clients.Docker, ctx *context.Context) (*Stats, error) {
+
+	ctr, err := GetContainer(containerName, cl, ctx)
 
 	if err != nil {
 		return nil, err
 	}
 
-	metrics, err := cl.ContainerStats(*ctx, *containerID, false)
-
-	defer metrics.Body.Close()
+	data, err := getRawContainerStats(ctr.ID, containerName, cl, ctx)
 
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(metrics.Body)
+	if err != nil {
+		return nil, err
+	}
 
-	stats, err := StatsParser(buf.Bytes())
+	stats, err := StatsParser(data)
 
 	if err != nil {
 		return nil, err
 	}
 
+	stats.ContainerID = ctr.ID
+	stats.ContainerName = containerName
+	stats.Image = ctr.Image
+	stats.Labels = ctr.Labels
+
 	return stats, nil
 }
 
+// Returns the network traffic of the load balancer container
+func GetLoadBalancerStats(cl *clients.Docker, ctx *context.Context) (*LoadBalancerStats, error) {
+
+	containerID, err := GetContainerID(GRS_LOAD_BALANCER, cl, ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := getRawContainerStats(*containerID, GRS_LOAD_BALANCER, cl, ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return LoadBalancerStatsParser(data)
+}
+
+// Returns the JSON returned by the Docker stats command for the container with ID containerID and name containerName
+func getRawContainerStats(containerID string, containerName string, cl *clients.Docker, ctx *context.Context) ([]byte, error) {
+
+	metrics, err := cl.ContainerStats(*ctx, containerID, false)
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("In GetContainerStats: Failed to get stats of container %s -> %s", containerName, err.Error()))
+	}
+
+	defer metrics.Body.Close()
+
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(metrics.Body); err != nil {
+		return nil, errors.New(fmt.Sprintf("In GetContainerStats: Failed to read stats of container %s -> %s", containerName, err.Error()))
+	}
+
+	return buf.Bytes(), nil
+}
+
 // Returns the ID of a container with name containerName
-func GetContainerID(containerName string, cl *client.Client, ctx *context.Context) (*string, error) {
-	containers, _ := cl.ContainerList(*ctx, container.ListOptions{All: true})
+func GetContainerID(containerName string, cl *clients.Docker, ctx *context.Context) (*string, error) {
+	ctr, err := GetContainer(containerName, cl, ctx)
 
-	var containerID string
+	if err != nil {
+		return nil, err
+	}
 
-	for _, c := range containers {
-		if strings.Compare(c.Names[0], fmt.Sprintf("/%s", containerName)) == 0 {
-			containerID = c.ID
-		} 
+	return &ctr.ID, nil
+}
+
+// Returns the container with name containerName, as listed by Docker
+func GetContainer(containerName string, cl *clients.Docker, ctx *context.Context) (*types.Container, error) {
+	containers, err := cl.ContainerList(*ctx, container.ListOptions{All: true})
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("In GetContainer: Failed to list containers -> %s", err.Error()))
 	}
 
-	if strings.Compare(containerID, "") == 0 {
-		return nil, errors.New(fmt.Sprintf("In getContainerID: No container was found with name %s", containerName))
+	for _, c := range containers {
+		if len(c.Names) > 0 && strings.Compare(c.Names[0], fmt.Sprintf("/%s", containerName)) == 0 {
+			return &c, nil
+		}
 	}
 
-	return &containerID, nil
+	return nil, errors.New(fmt.Sprintf("In GetContainer: No container was found with name %s", containerName))
 }
 
-func GetContainerName(containerID string, cl *client.Client, ctx *context.Context) (*string, error) {
+func GetContainerName(containerID string, cl *clients.Docker, ctx *context.Context) (*string, error) {
 	data, err := cl.ContainerInspect(*ctx, containerID)
 
 	if err != nil {
@@ -75,12 +134,12 @@ func GetContainerName(containerID string, cl *client.Client, ctx *context.Contex
 }
 
 // Returns the ID of a network with name networkName
-func GetNetworkID(networkName string, cl *client.Client, ctx *context.Context) (*string, error) {
+func GetNetworkID(networkName string, cl *clients.Docker, ctx *context.Context) (*string, error) {
 
 	networks, err := cl.NetworkList(*ctx, types.NetworkListOptions{})
 
 	if err != nil {
-		return nil, errors.New("In getNetworkID: Failed to get networks") 
+		return nil, errors.New(fmt.Sprintf("In getNetworkID: Failed to get networks -> %s", err.Error()))
 	}
 
 	for _, network := range networks {
@@ -93,7 +152,7 @@ func GetNetworkID(networkName string, cl *client.Client, ctx *context.Context) (
 }
 
 // Returns the containers on a network with name networkName
-func GetContainersOnNetwork(networkName string, cl *client.Client, ctx *context.Context) (*map[string]types.EndpointResource, error) {
+func GetContainersOnNetwork(networkName string, cl *clients.Docker, ctx *context.Context) (*map[string]types.EndpointResource, error) {
 
 	networkID, err := GetNetworkID(networkName, cl, ctx)
 
@@ -104,150 +163,187 @@ func GetContainersOnNetwork(networkName string, cl *client.Client, ctx *context.
 	networkInfo, err := cl.NetworkInspect(*ctx, *networkID, types.NetworkInspectOptions{})
 
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("In getContainersOnNetwork: Failed to inspect network with name %s", networkName))
+		return nil, errors.New(fmt.Sprintf("In getContainersOnNetwork: Failed to inspect network with name %s -> %s", networkName, err.Error()))
 	}
 
 	return &networkInfo.Containers, nil
 }
 
-// Returns a list with the containers names sorted by CPU or Memory Usage
-func SortContainersByUsage(allStats map[string]Stats, byCPUUsage bool) []string {
-
-	var pl []Pair[string, Stats]
+// Updates Nginx config file and send signal to update the service
+func UpdateNginxConfig(newConf string, cl *clients.Docker, ctx *context.Context) error {
 
-	for name, s := range allStats {
-		entry := new(Pair[string, Stats])
-		entry.Key = name
-		entry.Value = s
-		pl = append(pl, *entry)
+	writeErr := writeNginxConfig(newConf, ctx)
+	if writeErr != nil {
+		return writeErr
 	}
 
-	if byCPUUsage {
+	reloadCtx, span := telemetry.StartSpan(*ctx, "nginx.reload", attribute.String("container.name", GRS_LOAD_BALANCER))
+	var err error
+	defer func() { telemetry.EndSpan(span, err) }()
 
-		cpuUsage := func(s1, s2 Stats) bool {
-			return s1.CPUUsage < s2.CPUUsage
-		}
-	
-		By(cpuUsage).Sort(pl)
+	execID, execErr := cl.ContainerExecCreate(reloadCtx, GRS_LOAD_BALANCER, types.ExecConfig{
+		Tty: true,
+		Cmd: []string {"kill", "-1", "1"},
+		Privileged: true,
+		WorkingDir: "/",
+	})
 
-	} else {
-		memoryUsage := func(s1, s2 Stats) bool {
-			return s1.MemoryUsage < s2.MemoryUsage
-		}
-	
-		By(memoryUsage).Sort(pl)
+	if execErr != nil {
+		err = errors.New(fmt.Sprintf("In UpdateNginxConfig: Failed to create exec signal to Nginx -> %s", execErr.Error()))
+		events.Failure("nginx", events.NGINX_RELOAD_FAILED, "Nginx config was written but not reloaded", err)
+		telemetry.ObserveNginxReload(err)
+		return err
 	}
 
-	var sortedContainersNames []string
-
-	for _, p := range pl {
-		sortedContainersNames = append(sortedContainersNames, p.Key)
+	execStartErr := cl.ContainerExecStart(reloadCtx, execID.ID, types.ExecStartCheck{Tty: true});
+	
+	if execStartErr != nil {
+		err = errors.New(fmt.Sprintf("In UpdateNginxConfig: Failed to send signal to Nginx -> %s", execStartErr.Error()))
+		events.Failure("nginx", events.NGINX_RELOAD_FAILED, "Nginx config was written but not reloaded", err)
+		telemetry.ObserveNginxReload(err)
+		return err
 	}
 
-	return sortedContainersNames
+	telemetry.ObserveNginxReload(nil)
+	logging.Component(*ctx, "nginx").Info("Nginx config reloaded")
+
+	return nil
 }
 
-// Updates Nginx config file and send signal to update the service
-func UpdateNginxConfig(newConf string, cl *client.Client, ctx *context.Context) error {
-	
+// Writes the Nginx config file that is mounted into the load balancer
+func writeNginxConfig(newConf string, ctx *context.Context) (err error) {
+	_, span := telemetry.StartSpan(*ctx, "nginx.write_config", attribute.String("nginx.config_path", NGINX_CONFIG_PATH))
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	nginxConfigMu.Lock()
+	defer nginxConfigMu.Unlock()
+
 	f, openErr := os.Create(NGINX_CONFIG_PATH)
 
 	if openErr != nil {
-		return errors.New(fmt.Sprintf("In UpdateNginxConfig: Failed to create/open config file -> %s", openErr.Error()))
+		return errors.New(fmt.Sprintf("In writeNginxConfig: Failed to create/open config file -> %s", openErr.Error()))
 	}
 
+	defer f.Close()
+
 	_, writeErr := f.WriteString(newConf)
 
 	if writeErr != nil {
-		return errors.New(fmt.Sprintf("In UpdateNginxConfig: Failed to write to configfile -> %s", writeErr.Error()))
-	}
-
-	execID, execErr := cl.ContainerExecCreate(*ctx, GRS_LOAD_BALANCER, types.ExecConfig{
-		Tty: true,
-		Cmd: []string {"kill", "-1", "1"},
-		Privileged: true,
-		WorkingDir: "/",
-	})
-
-	if execErr != nil {
-		return errors.New(fmt.Sprintf("In UpdateNginxConfig: Failed to create exec signal to Nginx -> %s", execErr.Error()))
-	}
-
-	execStartErr := cl.ContainerExecStart(*ctx, execID.ID, types.ExecStartCheck{Tty: true});
-	
-	if execStartErr != nil {
-		return errors.New(fmt.Sprintf("In UpdateNginxConfig: Failed to send signal to Nginx -> %s", execStartErr.Error()))
+		return errors.New(fmt.Sprintf("In writeNginxConfig: Failed to write to configfile -> %s", writeErr.Error()))
 	}
 
 	return nil
 }
 
-func AddNewServer(newServer string, cl *client.Client, ctx *context.Context) error {
+// The Nginx config before and after a change of the upstream servers
+type NginxChange struct {
+	Old string
+	New string
+	// Addresses of the upstream servers in New
+	Upstream []string
+}
+
+// Returns the Nginx config with the containers of add added to the upstream and the ones of remove
+// removed from it. Containers are given by name, without the leading slash
+func PlanUpstreamChange(add []string, remove []string) (*NginxChange, error) {
 
 	oldConf, openErr := openNginxConfigFile()
 	if openErr != nil {
-		return openErr
+		return nil, openErr
 	}
 
 	p := parser.NewStringParser(*oldConf)
 
 	conf, err := p.Parse()
 	if err != nil {
-		return errors.New(fmt.Sprintf("In AddNewServer: Failed to parse Nginx old config -> %s", err.Error()))
+		return nil, errors.New(fmt.Sprintf("In PlanUpstreamChange: Failed to parse Nginx old config -> %s", err.Error()))
 	}
 
 	upstreams := conf.FindUpstreams()
 
-	upstreams[0].AddServer(&config.UpstreamServer{
-		Address: fmt.Sprintf("%s:80", newServer[1:]),
-	})
+	for _, serverToRemove := range remove {
+		servers := upstreams[0].UpstreamServers
+		serverToRemoveIndex := -1
 
-	newConf := dumper.DumpBlock(conf.Block, dumper.IndentedStyle)
+		for index, server := range servers {
+			if strings.Compare(server.Address, fmt.Sprintf("%s:80", serverToRemove)) == 0 {
+				serverToRemoveIndex = index
+			}
+		}
 
-	fmt.Println(newConf)
+		if serverToRemoveIndex == -1 {
+			return nil, errors.New(fmt.Sprintf("Couldn't find server to remove with address %s", serverToRemove))
+		}
 
-	UpdateNginxConfig(newConf, cl, ctx)
-	
-	return nil
-}
+		servers[serverToRemoveIndex] = servers[len(servers) - 1]
+		upstreams[0].UpstreamServers = servers[:len(servers) - 1]
+	}
 
-func RemoveServer(serverToRemove string, cl *client.Client, ctx *context.Context) error {
+	for _, newServer := range add {
+		upstreams[0].AddServer(&config.UpstreamServer{
+			Address: fmt.Sprintf("%s:80", newServer),
+		})
+	}
 
-	oldConf, openErr := openNginxConfigFile()
-	if openErr != nil {
-		return openErr
+	change := &NginxChange{Old: *oldConf, New: dumper.DumpBlock(conf.Block, dumper.IndentedStyle)}
+
+	for _, server := range upstreams[0].UpstreamServers {
+		change.Upstream = append(change.Upstream, server.Address)
 	}
 
-	p := parser.NewStringParser(*oldConf)
+	return change, nil
+}
 
-	conf, err := p.Parse()
+// Returns the names of the containers in the upstream of the load balancer
+func GetUpstreamServers() ([]string, error) {
+	change, err := PlanUpstreamChange(nil, nil)
 	if err != nil {
-		return errors.New(fmt.Sprintf("In RemoveServer: Failed parse Nginx old config -> %s", err.Error()))
+		return nil, errors.New(fmt.Sprintf("In GetUpstreamServers: Couldn't read the nginx config -> %s", err.Error()))
 	}
 
-	upstreams := conf.FindUpstreams()
+	var names []string
+	for _, server := range change.Upstream {
+		names = append(names, strings.TrimSuffix(server, ":80"))
+	}
 
-	servers := upstreams[0].UpstreamServers
-	serverToRemoveIndex := -1
+	return names, nil
+}
 
-	for index, server := range servers {
-		if strings.Compare(server.Address, fmt.Sprintf("%s:80", serverToRemove)) == 0 {
-			serverToRemoveIndex = index
-		}
-	}
+// Returns the Nginx config with newServer, a container name starting with a slash, added to the upstream
+func PlanAddServer(newServer string) (*NginxChange, error) {
+	return PlanUpstreamChange([]string{newServer[1:]}, nil)
+}
 
-	if serverToRemoveIndex == -1 {
-		return errors.New(fmt.Sprintf("Couldn't find server to remove with address %s", serverToRemove))
+func AddNewServer(newServer string, cl *clients.Docker, ctx *context.Context) error {
+
+	change, err := PlanAddServer(newServer)
+	if err != nil {
+		return errors.New(fmt.Sprintf("In AddNewServer: Couldn't plan the nginx config -> %s", err.Error()))
 	}
 
-	servers[serverToRemoveIndex] = servers[len(servers) - 1]
-	servers = servers[:len(servers) - 1]
+	logging.Component(*ctx, "nginx").Debug("Adding server to the Nginx config", "container", newServer, "config", change.New)
 
-	upstreams[0].UpstreamServers = servers
+	updateErr := UpdateNginxConfig(change.New, cl, ctx)
+	if updateErr != nil {
+		return errors.New(fmt.Sprintf("In AddNewServer: Couldn't update nginx config -> %s", updateErr.Error()))
+	}
 	
-	newConf := dumper.DumpBlock(conf.Block, dumper.IndentedStyle)
+	return nil
+}
+
+// Returns the Nginx config with serverToRemove, a container name, removed from the upstream
+func PlanRemoveServer(serverToRemove string) (*NginxChange, error) {
+	return PlanUpstreamChange(nil, []string{serverToRemove})
+}
+
+func RemoveServer(serverToRemove string, cl *clients.Docker, ctx *context.Context) error {
 
-	updateErr := UpdateNginxConfig(newConf, cl, ctx)
+	change, err := PlanRemoveServer(serverToRemove)
+	if err != nil {
+		return errors.New(fmt.Sprintf("In RemoveServer: Couldn't plan the nginx config -> %s", err.Error()))
+	}
+
+	updateErr := UpdateNginxConfig(change.New, cl, ctx)
 	if updateErr != nil {
 		return errors.New(fmt.Sprintf("In RemoveServer: Couldn't update nginx config -> %s", updateErr.Error()))
 	}
@@ -256,12 +352,17 @@ func RemoveServer(serverToRemove string, cl *client.Client, ctx *context.Context
 }
 
 func openNginxConfigFile() (*string, error) {
+	nginxConfigMu.RLock()
+	defer nginxConfigMu.RUnlock()
+
 	f, openErr := os.Open(NGINX_CONFIG_PATH)
 
 	if openErr != nil {
 		return nil, errors.New(fmt.Sprintf("In AddNewServer: Failed to open Nginx old config -> %s", openErr.Error()))
 	}
 
+	defer f.Close()
+
 	fileInfo, statErr := f.Stat()
 	if statErr != nil {
 		return nil, errors.New(fmt.Sprintf("In AddNewServer: Failed to get Nginx old config file info -> %s", statErr.Error()))
@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	. "grs/common/types"
+)
+
+// A value of a field of the config set outside of the config file, by an environment variable or a flag
+type ConfigOverride struct {
+	// Path of the field, like "metrics.cpu.threshold"
+	Field string
+	Value string
+	// CONFIG_LAYER_ENV or CONFIG_LAYER_FLAG
+	Layer string
+	// The environment variable or the flag, like "GRS_METRICS_CPU_THRESHOLD"
+	Name string
+}
+
+// Where the value of a field of the config comes from
+type ConfigSource struct {
+	// CONFIG_LAYER_DEFAULT, CONFIG_LAYER_FILE, CONFIG_LAYER_ENV or CONFIG_LAYER_FLAG
+	Layer string
+	// The environment variable or the flag, for the env and flag layers
+	Name string
+	// Line of the value, for the file layer
+	Line int
+}
+
+func (s ConfigSource) String() string {
+	switch s.Layer {
+	case CONFIG_LAYER_ENV:
+		return "env " + s.Name
+	case CONFIG_LAYER_FLAG:
+		return "flag --" + s.Name
+	}
+
+	return s.Layer
+}
+
+// Returns the paths of the fields of the config, like "metrics.cpu.threshold", in the order of Config
+func ConfigFields() []string {
+	var fields []string
+	collectFields(reflect.TypeOf(Config{}), "", &fields)
+
+	return fields
+}
+
+func collectFields(t reflect.Type, path string, fields *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := yamlName(t.Field(i))
+		if path != "" {
+			field = path + "." + field
+		}
+
+		if t.Field(i).Type.Kind() == reflect.Struct && t.Field(i).Type != durationType {
+			collectFields(t.Field(i).Type, field, fields)
+		} else {
+			*fields = append(*fields, field)
+		}
+	}
+}
+
+// Returns the value of the field of config at path, see ConfigFields
+func ConfigValue(config *Config, path string) any {
+	return lookup(reflect.ValueOf(config).Elem(), path).Interface()
+}
+
+// Returns the environment variable that overrides the field at path, like GRS_METRICS_CPU_THRESHOLD
+func ConfigEnvName(path string) string {
+	return CONFIG_ENV_PREFIX + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
+
+// Returns the overrides set by the GRS_ environment variables, one per field. Empty variables are ignored
+func EnvOverrides() []ConfigOverride {
+	var overrides []ConfigOverride
+
+	for _, field := range ConfigFields() {
+		name := ConfigEnvName(field)
+
+		if value := os.Getenv(name); value != "" {
+			overrides = append(overrides, ConfigOverride{Field: field, Value: value, Layer: CONFIG_LAYER_ENV, Name: name})
+		}
+	}
+
+	return overrides
+}
+
+// Sets the value of override in the document root, replacing the one of the file if any. Lists are comma
+// separated, and mappings are comma separated name=value pairs
+func (d *configDecoder) override(root *yaml.Node, override ConfigOverride) {
+	if root.Kind == 0 {
+		*root = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+
+	value := &yaml.Node{Kind: yaml.ScalarNode, Value: override.Value}
+	value.Tag = value.ShortTag()
+
+	switch lookup(reflect.ValueOf(&Config{}).Elem(), override.Field).Kind() {
+	case reflect.Slice:
+		value = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range strings.Split(override.Value, ",") {
+			value.Content = append(value.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: strings.TrimSpace(item)})
+		}
+	case reflect.Map:
+		// Like "web-service=least_cpu,api=oldest"
+		value = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, item := range strings.Split(override.Value, ",") {
+			key, v, _ := strings.Cut(item, "=")
+			value.Content = append(value.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: strings.TrimSpace(key)},
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: strings.TrimSpace(v)})
+		}
+	}
+
+	// The file is not a mapping, which decode reports
+	node := root.Content[0]
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+
+	parts := strings.Split(override.Field, ".")
+
+	for i, part := range parts {
+		next := value
+		if i < len(parts)-1 {
+			next = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+
+		found := false
+		for j := 0; j+1 < len(node.Content); j += 2 {
+			if node.Content[j].Value != part {
+				continue
+			}
+
+			found = true
+			if i == len(parts)-1 || node.Content[j+1].Kind != yaml.MappingNode {
+				node.Content[j+1] = next
+			}
+			next = node.Content[j+1]
+		}
+
+		if !found {
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: part}, next)
+		}
+
+		node = next
+	}
+
+	d.sources[override.Field] = ConfigSource{Layer: override.Layer, Name: override.Name}
+}
+
+// Returns whether field is set by the config file, an environment variable or a flag
+func (d *configDecoder) isSet(field string) bool {
+	if _, ok := d.sources[field]; ok {
+		return true
+	}
+
+	node, found := d.nodes[field]
+
+	return found && node.Tag != "!!null"
+}
+
+// Returns where the value of every field comes from, by path
+func (d *configDecoder) fieldSources() map[string]ConfigSource {
+	sources := map[string]ConfigSource{}
+
+	for _, field := range ConfigFields() {
+		source, ok := d.sources[field]
+		if !ok {
+			source = ConfigSource{Layer: CONFIG_LAYER_DEFAULT}
+			if node, found := d.nodes[field]; found && node.Tag != "!!null" {
+				source = ConfigSource{Layer: CONFIG_LAYER_FILE, Line: node.Line}
+			}
+		}
+
+		sources[field] = source
+	}
+
+	return sources
+}
@@ -1,9 +1,15 @@
 package utils
 
 import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"reflect"
+	"strings"
+	"time"
 
 	. "grs/common/types"
 
@@ -23,7 +29,7 @@ func PrettyPrint(v any) error {
 	return nil
 }
 
-// Parses the Docker stats command returned data into a Stats struct
+// Parses the Docker stats command returned data into a Stats struct. The identity of the container is left to the caller
 func StatsParser(data []byte) (*Stats, error) {
 
 	var metrics Metrics
@@ -38,14 +44,53 @@ func StatsParser(data []byte) (*Stats, error) {
 	cpuDelta := metrics.CPUStats.CPUUsage.TotalUsage - metrics.PreCPUStats.CPUUsage.TotalUsage
 	systemCPUDelta := metrics.CPUStats.SystemCPUUsage - metrics.PreCPUStats.SystemCPUUsage
 	numberOfCPUs := metrics.CPUStats.NumberOfCPUs
-	cpuUsge := ((cpuDelta / systemCPUDelta) * float64(numberOfCPUs)) * 100.0
 
 	stats := &Stats{
+		Timestamp:       metrics.Read,
 		UsedMemory:      usedMemory,
 		AvailableMemory: availableMemory,
-		MemoryUsage:     fmt.Sprintf("%.03f%%", (usedMemory/availableMemory)*100.0),
-		NumberOfCPUs:    metrics.CPUStats.NumberOfCPUs,
-		CPUUsage:        fmt.Sprintf("%.03f%%", cpuUsge),
+		NumberOfCPUs:    numberOfCPUs,
+	}
+
+	if stats.Timestamp.IsZero() {
+		stats.Timestamp = time.Now()
+	}
+
+	// Docker leaves preread empty when it has no previous sample
+	if !metrics.PreRead.IsZero() && metrics.Read.After(metrics.PreRead) {
+		stats.IntervalMs = float64(metrics.Read.Sub(metrics.PreRead).Microseconds()) / 1000
+	}
+
+	// A container that just started or stopped has no usage yet, which would be NaN
+	if availableMemory > 0 {
+		stats.MemoryUsage = (usedMemory / availableMemory) * 100.0
+	}
+
+	if systemCPUDelta > 0 {
+		stats.CPUUsage = ((cpuDelta / systemCPUDelta) * float64(numberOfCPUs)) * 100.0
+	}
+
+	return stats, nil
+}
+
+// Parses the Docker stats command returned data of the load balancer into a LoadBalancerStats struct
+func LoadBalancerStatsParser(data []byte) (*LoadBalancerStats, error) {
+
+	var metrics Metrics
+	parseErr := json.Unmarshal(data, &metrics)
+
+	if parseErr != nil {
+		return nil, errors.New(fmt.Sprintf("In LoadBalancerStatsParser: Failed to parse JSON data -> %s", parseErr))
+	}
+
+	stats := &LoadBalancerStats{}
+
+	// Sum the traffic of every network the load balancer is connected to
+	for _, network := range metrics.Networks {
+		stats.RxBytes += network.RxBytes
+		stats.TxBytes += network.TxBytes
+		stats.RxPackets += network.RxPackets
+		stats.TxPackets += network.TxPackets
 	}
 
 	return stats, nil
@@ -53,13 +98,278 @@ func StatsParser(data []byte) (*Stats, error) {
 
 // Parses the app's config to a Config struct
 func ConfigParser(data []byte) (error, *Config) {
+	err, config, _ := LayeredConfigParser(data, nil)
+
+	return err, config
+}
+
+// Parses the app's config to a Config struct, with overrides applied in order over the values of the file.
+// Returns where the value of every field comes from, by path
+func LayeredConfigParser(data []byte, overrides []ConfigOverride) (error, *Config, map[string]ConfigSource) {
 	var config Config
+	var root yaml.Node
+
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return errors.New(fmt.Sprintf("In ConfigParser: Failed to parse config file -> %s", err)), nil, nil
+	}
+
+	decoder := &configDecoder{nodes: map[string]*yaml.Node{}, sources: map[string]ConfigSource{}, invalid: map[string]bool{}}
+
+	for _, override := range overrides {
+		decoder.override(&root, override)
+	}
+
+	decoder.decode(&root, reflect.ValueOf(&config).Elem(), "")
+	decoder.check(&config)
+
+	for i, sink := range config.Sinks {
+		if sink == SINK_INFLUXDB && config.InfluxDB.URL == "" {
+			decoder.fail(decoder.at("sinks").Content[i], "sinks", "the influxdb sink needs influxdb.url")
+		}
+	}
+
+	if (config.Control.TLS.CertFile == "") != (config.Control.TLS.KeyFile == "") {
+		decoder.fail(decoder.at("control.tls"), "control.tls", "needs both cert_file and key_file")
+	}
+
+	if config.Control.TLS.ClientCAFile != "" && config.Control.TLS.CertFile == "" {
+		decoder.fail(decoder.at("control.tls.client_ca_file"), "control.tls.client_ca_file", "needs cert_file and key_file")
+	}
+
+	if config.Readiness.Path != "" && !strings.HasPrefix(config.Readiness.Path, "/") {
+		decoder.fail(decoder.at("readiness.path"), "readiness.path", "must start with /")
+	}
+
+	if config.Liveness.Path != "" && !strings.HasPrefix(config.Liveness.Path, "/") {
+		decoder.fail(decoder.at("liveness.path"), "liveness.path", "must start with /")
+	}
+
+	if len(decoder.errors) > 0 {
+		return decoder.err(), nil, nil
+	}
+
+	if config.Period <= 0 {
+		config.Period = DEFAULT_PERIOD
+	}
+
+	if config.Metrics.CPU.Threshold <= 0 {
+		config.Metrics.CPU.Threshold = DEFAULT_CPU_THRESHOLD
+	}
+
+	if config.Metrics.Memory.Threshold <= 0 {
+		config.Metrics.Memory.Threshold = DEFAULT_MEMORY_THRESHOLD
+	}
+
+	if config.Service == "" {
+		config.Service = DEFAULT_SERVICE
+	}
+
+	if config.MinReplicas <= 0 {
+		config.MinReplicas = DEFAULT_MIN_REPLICAS
+	}
+
+	if config.Elasticsearch.Index == "" {
+		config.Elasticsearch.Index = DEFAULT_ES_INDEX
+	}
+
+	if config.Elasticsearch.BatchSize <= 0 {
+		config.Elasticsearch.BatchSize = DEFAULT_ES_BATCH_SIZE
+	}
+
+	if config.Elasticsearch.FlushInterval <= 0 {
+		config.Elasticsearch.FlushInterval = DEFAULT_ES_FLUSH_INTERVAL
+	}
+
+	if config.Elasticsearch.SpoolPath == "" {
+		config.Elasticsearch.SpoolPath = DEFAULT_ES_SPOOL_PATH
+	}
+
+	if config.Elasticsearch.SpoolMaxSize <= 0 {
+		config.Elasticsearch.SpoolMaxSize = DEFAULT_ES_SPOOL_MAX_SIZE
+	}
+
+	if config.Elasticsearch.Retention == "" {
+		config.Elasticsearch.Retention = DEFAULT_ES_RETENTION
+	}
+
+	if config.Elasticsearch.RolloverMaxAge == "" {
+		config.Elasticsearch.RolloverMaxAge = DEFAULT_ES_ROLLOVER_MAX_AGE
+	}
+
+	if config.Elasticsearch.RolloverMaxSize == "" {
+		config.Elasticsearch.RolloverMaxSize = DEFAULT_ES_ROLLOVER_MAX_SIZE
+	}
+
+	if config.Elasticsearch.DecisionIndex == "" {
+		config.Elasticsearch.DecisionIndex = DEFAULT_ES_DECISION_INDEX
+	}
+
+	if config.Elasticsearch.LoadBalancerIndex == "" {
+		config.Elasticsearch.LoadBalancerIndex = DEFAULT_ES_LOAD_BALANCER_INDEX
+	}
+
+	if len(config.Sinks) == 0 {
+		config.Sinks = []string{SINK_ELASTICSEARCH}
+	}
+
+	if config.Grafana.URL == "" {
+		config.Grafana.URL = DEFAULT_GRAFANA_URL
+	}
+
+	if config.Grafana.ElasticsearchURL == "" {
+		config.Grafana.ElasticsearchURL = DEFAULT_GRAFANA_ELASTICSEARCH_URL
+	}
+
+	if config.Grafana.DatasourceAddress == "" {
+		config.Grafana.DatasourceAddress = DEFAULT_GRAFANA_DATASOURCE_ADDRESS
+	}
+
+	if config.Grafana.DatasourceURL == "" {
+		config.Grafana.DatasourceURL = DEFAULT_GRAFANA_DATASOURCE_URL
+	}
+
+	if config.Prometheus.Address == "" {
+		config.Prometheus.Address = DEFAULT_PROMETHEUS_ADDRESS
+	}
+
+	if config.Control.Address == "" {
+		config.Control.Address = DEFAULT_CONTROL_ADDRESS
+	}
+
+	if config.Control.GRPCAddress == "" {
+		config.Control.GRPCAddress = DEFAULT_CONTROL_GRPC_ADDRESS
+	}
+
+	if config.Telemetry.Address == "" {
+		config.Telemetry.Address = DEFAULT_TELEMETRY_ADDRESS
+	}
+
+	if config.Logging.Level == "" {
+		config.Logging.Level = DEFAULT_LOG_LEVEL
+	}
+
+	if config.Logging.Format == "" {
+		config.Logging.Format = DEFAULT_LOG_FORMAT
+	}
+
+	if config.OpenTelemetry.ServiceName == "" {
+		config.OpenTelemetry.ServiceName = DEFAULT_OTEL_SERVICE_NAME
+	}
+
+	if config.OpenTelemetry.MetricInterval <= 0 {
+		config.OpenTelemetry.MetricInterval = DEFAULT_OTEL_METRIC_INTERVAL
+	}
+
+	if config.JSONL.Path == "" {
+		config.JSONL.Path = DEFAULT_JSONL_PATH
+	}
+
+	if config.JSONL.MaxSize <= 0 {
+		config.JSONL.MaxSize = DEFAULT_JSONL_MAX_SIZE
+	}
+
+	if config.JSONL.MaxBackups <= 0 {
+		config.JSONL.MaxBackups = DEFAULT_JSONL_MAX_BACKUPS
+	}
+
+	if config.OnShutdown == "" {
+		config.OnShutdown = ON_SHUTDOWN_NONE
+	}
+
+	if config.VictimSelection.Strategy == "" {
+		config.VictimSelection.Strategy = DEFAULT_VICTIM_STRATEGY
+	}
+
+	// A weight of 0 is valid, so a weight is only defaulted when it is not set at all
+	weights := &config.VictimSelection.Weights
+	if !decoder.isSet("victim_selection.weights.cpu") {
+		weights.CPU = DEFAULT_VICTIM_WEIGHT_CPU
+	}
+
+	if !decoder.isSet("victim_selection.weights.memory") {
+		weights.Memory = DEFAULT_VICTIM_WEIGHT_MEMORY
+	}
+
+	if !decoder.isSet("victim_selection.weights.connections") {
+		weights.Connections = DEFAULT_VICTIM_WEIGHT_CONNECTIONS
+	}
+
+	if !decoder.isSet("victim_selection.weights.age") {
+		weights.Age = DEFAULT_VICTIM_WEIGHT_AGE
+	}
+
+	if config.Drain.Timeout <= 0 {
+		config.Drain.Timeout = DEFAULT_DRAIN_TIMEOUT
+	}
+
+	if config.Drain.GracePeriod <= 0 {
+		config.Drain.GracePeriod = DEFAULT_DRAIN_GRACE_PERIOD
+	}
+
+	if config.Readiness.Check == "" {
+		config.Readiness.Check = DEFAULT_READINESS_CHECK
+	}
+
+	if config.Readiness.Port <= 0 {
+		config.Readiness.Port = DEFAULT_READINESS_PORT
+	}
+
+	if config.Readiness.Path == "" {
+		config.Readiness.Path = DEFAULT_READINESS_PATH
+	}
+
+	if config.Readiness.ExpectedStatus <= 0 {
+		config.Readiness.ExpectedStatus = DEFAULT_READINESS_EXPECTED_STATUS
+	}
+
+	if config.Readiness.SuccessThreshold <= 0 {
+		config.Readiness.SuccessThreshold = DEFAULT_READINESS_SUCCESS_THRESHOLD
+	}
+
+	if config.Readiness.Interval <= 0 {
+		config.Readiness.Interval = DEFAULT_READINESS_INTERVAL
+	}
+
+	if config.Readiness.Timeout <= 0 {
+		config.Readiness.Timeout = DEFAULT_READINESS_TIMEOUT
+	}
+
+	if config.Liveness.Check == "" {
+		config.Liveness.Check = DEFAULT_LIVENESS_CHECK
+	}
+
+	if config.Liveness.Port <= 0 {
+		config.Liveness.Port = DEFAULT_LIVENESS_PORT
+	}
+
+	if config.Liveness.Path == "" {
+		config.Liveness.Path = DEFAULT_LIVENESS_PATH
+	}
+
+	if config.Liveness.ExpectedStatus <= 0 {
+		config.Liveness.ExpectedStatus = DEFAULT_LIVENESS_EXPECTED_STATUS
+	}
+
+	if config.Liveness.FailureThreshold <= 0 {
+		config.Liveness.FailureThreshold = DEFAULT_LIVENESS_FAILURE_THRESHOLD
+	}
+
+	if config.Liveness.Interval <= 0 {
+		config.Liveness.Interval = DEFAULT_LIVENESS_INTERVAL
+	}
+
+	return nil, &config, decoder.fieldSources()
+}
 
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return errors.New(fmt.Sprintf("In ConfigParser: Failed to parse config file -> %s", err)), nil
+// Returns a random ID of 16 hexadecimal characters. If no random bytes can be read, the ID is made of the
+// current time, which still tells the IDs of one process apart
+func RandomID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		binary.BigEndian.PutUint64(id, uint64(time.Now().UnixNano()))
 	}
 
-	return nil, &config
+	return hex.EncodeToString(id)
 }
 
 // Pretty prints YAML
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	. "grs/common/types"
+	. "grs/common/utils"
+)
+
+// Overrides of the environment variables then of the flags, applied over the config file by loadConfig
+var configOverrides []ConfigOverride
+
+// Overrides of the flags, in the order of the command line
+var flagOverrides []ConfigOverride
+
+// Adds a flag to flags for every field of the config, like --metrics.cpu.threshold
+func overrideFlags(flags *flag.FlagSet) {
+	for _, field := range ConfigFields() {
+		field := field
+		set := func(value string) error {
+			flagOverrides = append(flagOverrides, ConfigOverride{Field: field, Value: value, Layer: CONFIG_LAYER_FLAG, Name: field})
+			return nil
+		}
+
+		usage := fmt.Sprintf("overrides %s, or $%s", field, ConfigEnvName(field))
+
+		if reflect.TypeOf(ConfigValue(&Config{}, field)).Kind() == reflect.Bool {
+			flags.BoolFunc(field, usage, set)
+		} else {
+			flags.Func(field, usage, set)
+		}
+	}
+}
+
+// A field of the effective config, as printed by printConfig
+type effectiveField struct {
+	Field  string `json:"field"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// Prints the value of every field of the config once the environment variables and flags are applied, and
+// where it comes from: the default, a line of the config file, an environment variable or a flag. Secrets are
+// hidden. Returns the exit code: 0 if the config is valid, 1 otherwise.
+// Usage: go run . [--config config.yaml] [--<field> value] config [-json]
+func printConfig(path string, args []string) int {
+	flags := flag.NewFlagSet("config", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the fields as JSON")
+	flags.Parse(args)
+
+	config, sources, err := loadLayeredConfig(path)
+	if err != nil {
+		printConfigProblems(path, err)
+		return 1
+	}
+
+	var fields []effectiveField
+
+	for _, field := range ConfigFields() {
+		value := redactedValue(config, field)
+
+		source := sources[field].String()
+		if sources[field].Layer == CONFIG_LAYER_FILE {
+			source = fmt.Sprintf("%s:%d", path, sources[field].Line)
+		}
+
+		fields = append(fields, effectiveField{Field: field, Value: value, Source: source})
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(fields)
+		return 0
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FIELD\tVALUE\tSOURCE")
+
+	for _, field := range fields {
+		value := field.Value
+		if value == "" {
+			value = `""`
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\n", field.Field, value, field.Source)
+	}
+
+	w.Flush()
+
+	return 0
+}
+
+// Formats a value of the config like an override sets it: lists comma separated, mappings as name=value pairs
+func formatConfigValue(value any) string {
+	if list, ok := value.([]string); ok {
+		return strings.Join(list, ",")
+	}
+
+	if mapping, ok := value.(map[string]string); ok {
+		var pairs []string
+		for key, v := range mapping {
+			pairs = append(pairs, key+"="+v)
+		}
+
+		sort.Strings(pairs)
+
+		return strings.Join(pairs, ",")
+	}
+
+	return fmt.Sprint(value)
+}
+
+// Returns the value of field in config as printed, "(hidden)" for a secret that is set
+func redactedValue(config *Config, field string) string {
+	value := formatConfigValue(ConfigValue(config, field))
+	if isSecret(field) && value != "" {
+		return "(hidden)"
+	}
+
+	return value
+}
+
+// Returns every field of config by path, with the secrets hidden, to be logged
+func redactedConfig(config *Config) map[string]string {
+	fields := map[string]string{}
+	for _, field := range ConfigFields() {
+		fields[field] = redactedValue(config, field)
+	}
+
+	return fields
+}
+
+func isSecret(field string) bool {
+	for _, secret := range SECRET_FIELDS {
+		if strings.HasSuffix(field, secret) {
+			return true
+		}
+	}
+
+	return false
+}
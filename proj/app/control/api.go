@@ -0,0 +1,189 @@
+package control
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	logging "grs/common/logging"
+	. "grs/common/types"
+)
+
+type scaleRequest struct {
+	Replicas int    `json:"replicas"`
+	TTL      string `json:"ttl"`
+}
+
+// Serves the control API. GET requests need the read scope, POST and PUT requests the write scope:
+//
+//	GET  /services                 status of every service
+//	GET  /services/<name>          status of one service
+//	POST /services/<name>/scale    {"replicas": 3, "ttl": "15m"} holds the service at 3 replicas, for 15 minutes if ttl is set
+//	POST /services/<name>/pause    stops autoscaling the service
+//	POST /services/<name>/resume   clears the pause and the override
+//	POST /reconcile                runs an iteration of the control loop right away
+//	GET  /log/level                level of the logs
+//	PUT  /log/level                changes the level of the logs to the one in the body
+type API struct {
+	controller *Controller
+	server     *http.Server
+}
+
+// Starts serving the control API on config.Control.Address, over TLS if a certificate is configured
+func NewAPI(config *Config, controller *Controller) (*API, error) {
+	a := &API{controller: controller}
+
+	auth, err := newAuthenticator(config)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if !auth.hasCredentials() {
+		slog.Warn("Neither control.tokens_file nor control.tls.client_ca_file is set, the control APIs are read only",
+			"component", "control")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services", a.handleServices)
+	mux.HandleFunc("/services/", a.handleService)
+	mux.HandleFunc("/reconcile", a.handleReconcile)
+	mux.Handle("/log/level", logging.LevelHandler())
+
+	// Listen right away so a port already in use is reported at startup
+	listener, err := net.Listen("tcp", config.Control.Address)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("In control.NewAPI: Failed to listen on %s -> %s", config.Control.Address, err))
+	}
+
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	a.server = &http.Server{Handler: auth.middleware(mux)}
+
+	go func() {
+		if err := a.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Stopped serving the control API", "component", "control", "error", err)
+		}
+	}()
+
+	return a, nil
+}
+
+// Stops serving, waiting for the requests in progress until ctx is done
+func (a *API) Close(ctx context.Context) error {
+	return a.server.Shutdown(ctx)
+}
+
+func (a *API) handleServices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("only GET is supported"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a.controller.Statuses())
+}
+
+func (a *API) handleService(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/services/"), "/"), "/")
+	service := parts[0]
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		status, err := a.controller.Status(service)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, status)
+	case len(parts) == 2 && r.Method == http.MethodPost:
+		a.handleAction(w, r, service, parts[1])
+	case len(parts) <= 2:
+		writeError(w, http.StatusMethodNotAllowed, errors.New(fmt.Sprintf("%s is not supported on %s", r.Method, r.URL.Path)))
+	default:
+		writeError(w, http.StatusNotFound, errors.New(fmt.Sprintf("unknown path %s", r.URL.Path)))
+	}
+}
+
+func (a *API) handleAction(w http.ResponseWriter, r *http.Request, service string, action string) {
+	var err error
+
+	switch action {
+	case "scale":
+		var request scaleRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, errors.New(fmt.Sprintf("invalid request -> %s", err)))
+			return
+		}
+
+		var ttl time.Duration
+		if request.TTL != "" {
+			ttl, err = time.ParseDuration(request.TTL)
+			if err != nil || ttl <= 0 {
+				writeError(w, http.StatusBadRequest, errors.New(fmt.Sprintf("invalid ttl %s", request.TTL)))
+				return
+			}
+		}
+
+		err = a.controller.Scale(service, request.Replicas, ttl)
+	case "pause":
+		err = a.controller.Pause(service)
+	case "resume":
+		err = a.controller.Resume(service)
+		if err == nil {
+			a.controller.Reconcile()
+		}
+	default:
+		writeError(w, http.StatusNotFound, errors.New(fmt.Sprintf("unknown action %s", action)))
+		return
+	}
+
+	switch {
+	case errors.Is(err, ErrUnknownService):
+		writeError(w, http.StatusNotFound, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	status, _ := a.controller.Status(service)
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (a *API) handleReconcile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("only POST is supported"))
+		return
+	}
+
+	a.controller.Reconcile()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Warn("Failed to write response", "component", "control", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,278 @@
+package control
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	. "grs/common/types"
+)
+
+// Scopes a token can be granted. read is needed for GET requests, write for everything else
+const SCOPE_READ string = "read"
+const SCOPE_WRITE string = "write"
+
+// Largest body a mutating request can have
+const maxRequestBody int64 = 64 * 1024
+
+// A bearer token allowed to call the control API
+type Token struct {
+	Name   string   `yaml:"name"`
+	Token  string   `yaml:"token"`
+	Scopes []string `yaml:"scopes"`
+}
+
+// Who made a request, as told by its token or its client certificate
+type Caller struct {
+	Name   string
+	Scopes []string
+}
+
+// Checks the bearer token of every request, if tokens are configured, and that it has the scope
+// the request needs. Without tokens, a client with a certificate signed by the configured CA has
+// every scope; with neither, anyone who can reach the listen address has the read scope only
+type authenticator struct {
+	tokens []Token
+	mtls   bool
+}
+
+// Reads the tokens file:
+//
+//	tokens:
+//	  - name: grafana
+//	    token: <secret>
+//	    scopes: [read]
+func LoadTokens(path string) ([]Token, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("In control.LoadTokens: Failed to read %s -> %s", path, err))
+	}
+
+	var file struct {
+		Tokens []Token `yaml:"tokens"`
+	}
+
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, errors.New(fmt.Sprintf("In control.LoadTokens: Failed to parse %s -> %s", path, err))
+	}
+
+	if len(file.Tokens) == 0 {
+		return nil, errors.New(fmt.Sprintf("In control.LoadTokens: No tokens in %s", path))
+	}
+
+	for _, token := range file.Tokens {
+		if token.Name == "" || token.Token == "" {
+			return nil, errors.New(fmt.Sprintf("In control.LoadTokens: Every token in %s needs a name and a token", path))
+		}
+
+		for _, scope := range token.Scopes {
+			if scope != SCOPE_READ && scope != SCOPE_WRITE {
+				return nil, errors.New(fmt.Sprintf("In control.LoadTokens: Token %s has unknown scope %s", token.Name, scope))
+			}
+		}
+	}
+
+	return file.Tokens, nil
+}
+
+// Returns the authenticator of the control APIs, with the tokens of config.Control.TokensFile if it is set
+func newAuthenticator(config *Config) (*authenticator, error) {
+	auth := &authenticator{mtls: config.Control.TLS.ClientCAFile != ""}
+
+	if config.Control.TokensFile != "" {
+		tokens, err := LoadTokens(config.Control.TokensFile)
+		if err != nil {
+			return nil, err
+		}
+
+		auth.tokens = tokens
+	}
+
+	return auth, nil
+}
+
+// Returns whether the callers can be told apart, by a token or a client certificate. Otherwise they are read only
+func (a *authenticator) hasCredentials() bool {
+	return len(a.tokens) > 0 || a.mtls
+}
+
+// Checks that the tokens file and the TLS files of the control APIs can be loaded
+func CheckConfig(config *Config) error {
+	if _, err := newAuthenticator(config); err != nil {
+		return err
+	}
+
+	_, err := newTLSConfig(config)
+
+	return err
+}
+
+// Returns the TLS config of the control API, or nil if it serves plain HTTP.
+// With a client CA, clients must present a certificate signed by it
+func newTLSConfig(config *Config) (*tls.Config, error) {
+	if config.Control.TLS.CertFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(config.Control.TLS.CertFile, config.Control.TLS.KeyFile)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("In control.newTLSConfig: Failed to load the certificate -> %s", err))
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if config.Control.TLS.ClientCAFile != "" {
+		pem, err := os.ReadFile(config.Control.TLS.ClientCAFile)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("In control.newTLSConfig: Failed to read the client CA -> %s", err))
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New(fmt.Sprintf("In control.newTLSConfig: No certificate found in %s", config.Control.TLS.ClientCAFile))
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// Returns the caller with the client certificate state and the Authorization header of a request,
+// or an error if it can't be authenticated
+func (a *authenticator) authenticate(state *tls.ConnectionState, authorization string) (*Caller, error) {
+	var certName string
+	if state != nil && len(state.PeerCertificates) > 0 {
+		certName = state.PeerCertificates[0].Subject.CommonName
+	}
+
+	if len(a.tokens) == 0 {
+		switch {
+		case certName != "":
+			return &Caller{Name: "cert:" + certName, Scopes: []string{SCOPE_READ, SCOPE_WRITE}}, nil
+		case a.mtls:
+			return nil, errors.New("a client certificate is required")
+		}
+
+		return &Caller{Name: "anonymous", Scopes: []string{SCOPE_READ}}, nil
+	}
+
+	bearer, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || bearer == "" {
+		return nil, errors.New("a bearer token is required")
+	}
+
+	for _, token := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(bearer), []byte(token.Token)) == 1 {
+			name := "token:" + token.Name
+			if certName != "" {
+				name = fmt.Sprintf("%s (cert:%s)", name, certName)
+			}
+
+			return &Caller{Name: name, Scopes: token.Scopes}, nil
+		}
+	}
+
+	return nil, errors.New("invalid bearer token")
+}
+
+// Returns the middleware of the Grafana JSON datasource: every request needs the read scope, even the POSTs
+// the plugin sends its queries with, and the tokens of the control APIs. The datasource is served over plain
+// HTTP, so it can't check client certificates: with a client CA but no tokens file, it can't be protected
+func DatasourceMiddleware(config *Config) (func(http.Handler) http.Handler, error) {
+	auth, err := newAuthenticator(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if auth.mtls && len(auth.tokens) == 0 {
+		return nil, errors.New("In control.DatasourceMiddleware: The grafana_json sink needs control.tokens_file when control.tls.client_ca_file is set")
+	}
+
+	readScope := func(r *http.Request) string { return SCOPE_READ }
+
+	return func(next http.Handler) http.Handler { return auth.handler(next, readScope) }, nil
+}
+
+// Lets the request through if its caller has the scope it needs, and audit-logs the mutating ones
+func (a *authenticator) middleware(next http.Handler) http.Handler {
+	return a.handler(next, requestScope)
+}
+
+// Returns the scope a request to the control API needs: read for GET, write for everything else
+func requestScope(r *http.Request) string {
+	if r.Method == http.MethodGet {
+		return SCOPE_READ
+	}
+
+	return SCOPE_WRITE
+}
+
+// Lets the request through if its caller has the scope given by scopeOf, and audit-logs the ones that need the write scope
+func (a *authenticator) handler(next http.Handler, scopeOf func(r *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, err := a.authenticate(r.TLS, r.Header.Get("Authorization"))
+		if err != nil {
+			slog.Warn("Rejected control request", "component", "control", "method", r.Method, "path", r.URL.Path,
+				"remote", r.RemoteAddr, "error", err)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+
+		scope := scopeOf(r)
+
+		if !slices.Contains(caller.Scopes, scope) {
+			slog.Warn("Rejected control request", "component", "control", "method", r.Method, "path", r.URL.Path,
+				"remote", r.RemoteAddr, "caller", caller.Name, "error", "missing scope "+scope)
+			writeError(w, http.StatusForbidden, errors.New(fmt.Sprintf("the %s scope is required", scope)))
+			return
+		}
+
+		if scope == SCOPE_READ {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The body is read here to be audit-logged, and handed over again to the handler
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+		if err != nil {
+			writeError(w, http.StatusRequestEntityTooLarge, errors.New(fmt.Sprintf("the body can't be read -> %s", err)))
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		slog.Info("Audit", "component", "control", "audit", true, "caller", caller.Name, "remote", r.RemoteAddr,
+			"method", r.Method, "path", r.URL.Path, "body", strings.TrimSpace(string(body)), "status", recorder.status)
+	})
+}
+
+// Keeps the status code written by a handler, for the audit log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,302 @@
+// Lets operators inspect and steer the autoscaler while it runs
+package control
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	. "grs/common/types"
+	utils "grs/common/utils"
+)
+
+// Replicas the scaler holds a service at, instead of following the thresholds
+type Override struct {
+	Replicas int        `json:"replicas"`
+	Expires  *time.Time `json:"expires,omitempty"`
+}
+
+// What the control API reports about one service
+type ServiceStatus struct {
+	Service      string    `json:"service"`
+	Paused       bool      `json:"paused"`
+	Override     *Override `json:"override,omitempty"`
+	Replicas     int       `json:"replicas"`
+	LastUpdate   time.Time `json:"last_update"`
+	LastStats    []*Stats  `json:"last_stats"`
+	LastDecision *Decision `json:"last_decision,omitempty"`
+}
+
+// A stats sample or a decision recorded for a service, as sent to watchers. Exactly one of Stats and Decision is set
+type WatchEvent struct {
+	Service   string
+	Timestamp time.Time
+	Stats     []*Stats
+	Decision  *Decision
+}
+
+// Returned for a service the controller doesn't know
+var ErrUnknownService = errors.New("unknown service")
+
+// Events a watcher can fall behind by before it is dropped
+const watchBuffer = 64
+
+// Holds the last state of every service and the pauses and overrides set through the API.
+// The control loop asks it how to scale each service and tells it what happened
+type Controller struct {
+	mu        sync.Mutex
+	services  map[string]*ServiceStatus
+	decisions map[string][]*Decision
+	watchers  map[chan WatchEvent]string
+
+	// Fewest replicas an override can hold a service at, the min_replicas of the config
+	minReplicas int
+
+	reconcile chan struct{}
+}
+
+func NewController(services ...string) *Controller {
+	c := &Controller{
+		services:  map[string]*ServiceStatus{},
+		decisions: map[string][]*Decision{},
+		watchers:  map[chan WatchEvent]string{},
+		reconcile: make(chan struct{}, 1),
+
+		minReplicas: utils.DEFAULT_MIN_REPLICAS,
+	}
+
+	for _, service := range services {
+		c.services[service] = &ServiceStatus{Service: service, LastStats: []*Stats{}}
+	}
+
+	return c
+}
+
+// Returns the status of every service, sorted by name
+func (c *Controller) Statuses() []ServiceStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	statuses := make([]ServiceStatus, 0, len(c.services))
+	for _, status := range c.services {
+		statuses = append(statuses, *status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Service < statuses[j].Service })
+
+	return statuses
+}
+
+func (c *Controller) Status(service string) (ServiceStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status, ok := c.services[service]
+	if !ok {
+		return ServiceStatus{}, ErrUnknownService
+	}
+
+	return *status, nil
+}
+
+// Stops the scaler from starting or stopping replicas of service until Resume is called
+func (c *Controller) Pause(service string) error {
+	return c.update(service, func(status *ServiceStatus) {
+		status.Paused = true
+	})
+}
+
+// Hands service back to the autoscaler: the pause and the override, if any, are cleared
+func (c *Controller) Resume(service string) error {
+	return c.update(service, func(status *ServiceStatus) {
+		status.Paused = false
+		status.Override = nil
+	})
+}
+
+// Sets the fewest replicas Scale accepts, called again when min_replicas is reloaded
+func (c *Controller) SetMinReplicas(replicas int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.minReplicas = replicas
+}
+
+// Holds service at replicas, between min_replicas and MAX_MANUAL_REPLICAS. With a ttl above zero, autoscaling resumes after it; otherwise
+// the override lasts until Resume is called. Clears the pause and triggers a reconcile
+func (c *Controller) Scale(service string, replicas int, ttl time.Duration) error {
+	c.mu.Lock()
+	minReplicas := c.minReplicas
+	c.mu.Unlock()
+
+	if replicas < minReplicas {
+		return errors.New(fmt.Sprintf("In Controller.Scale: At least %d replicas must run (min_replicas), got %d", minReplicas, replicas))
+	}
+
+	if replicas > utils.MAX_MANUAL_REPLICAS {
+		return errors.New(fmt.Sprintf("In Controller.Scale: At most %d replicas can be requested, got %d", utils.MAX_MANUAL_REPLICAS, replicas))
+	}
+
+	override := &Override{Replicas: replicas}
+	if ttl > 0 {
+		expires := time.Now().Add(ttl)
+		override.Expires = &expires
+	}
+
+	err := c.update(service, func(status *ServiceStatus) {
+		status.Paused = false
+		status.Override = override
+	})
+	if err != nil {
+		return err
+	}
+
+	c.Reconcile()
+
+	return nil
+}
+
+// Returns whether service is paused and the override it is held at, if any. An expired override is cleared
+func (c *Controller) Mode(service string, now time.Time) (bool, *Override) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status, ok := c.services[service]
+	if !ok {
+		return false, nil
+	}
+
+	if status.Override != nil && status.Override.Expires != nil && !now.Before(*status.Override.Expires) {
+		slog.Info("Manual override expired, autoscaling resumes", "component", "control", "service", service, "replicas", status.Override.Replicas)
+		status.Override = nil
+	}
+
+	return status.Paused, status.Override
+}
+
+// Records the stats collected from service and the decision taken, which is nil if the scaler didn't run,
+// and sends them to the watchers of service
+func (c *Controller) Record(service string, stats []*Stats, decision *Decision, timestamp time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status, ok := c.services[service]
+	if !ok {
+		return
+	}
+
+	status.Replicas = len(stats)
+	status.LastStats = stats
+	status.LastUpdate = timestamp
+
+	c.publish(WatchEvent{Service: service, Timestamp: timestamp, Stats: stats})
+
+	if decision != nil {
+		status.LastDecision = decision
+
+		// Oldest first, the history is reversed when listed
+		decisions := append(c.decisions[service], decision)
+		if len(decisions) > utils.CONTROL_DECISION_HISTORY_SIZE {
+			decisions = decisions[len(decisions)-utils.CONTROL_DECISION_HISTORY_SIZE:]
+		}
+		c.decisions[service] = decisions
+
+		c.publish(WatchEvent{Service: service, Timestamp: timestamp, Decision: decision})
+	}
+}
+
+// Returns the last decisions taken for service, newest first. All the decisions kept are returned if limit is 0
+func (c *Controller) Decisions(service string, limit int) ([]*Decision, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.services[service]; !ok {
+		return nil, ErrUnknownService
+	}
+
+	history := c.decisions[service]
+	if limit <= 0 || limit > len(history) {
+		limit = len(history)
+	}
+
+	decisions := make([]*Decision, 0, limit)
+	for i := len(history) - 1; len(decisions) < limit; i-- {
+		decisions = append(decisions, history[i])
+	}
+
+	return decisions, nil
+}
+
+// Returns a channel receiving the events recorded for service from now on, or for every service if
+// service is empty, and a function to stop watching. A watcher that falls behind is dropped: its
+// channel is closed
+func (c *Controller) Watch(service string) (<-chan WatchEvent, func(), error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.services[service]; service != "" && !ok {
+		return nil, nil, ErrUnknownService
+	}
+
+	events := make(chan WatchEvent, watchBuffer)
+	c.watchers[events] = service
+
+	stop := func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if _, ok := c.watchers[events]; ok {
+			delete(c.watchers, events)
+			close(events)
+		}
+	}
+
+	return events, stop, nil
+}
+
+// Sends event to its watchers without blocking. Must be called with c.mu held
+func (c *Controller) publish(event WatchEvent) {
+	for events, service := range c.watchers {
+		if service != "" && service != event.Service {
+			continue
+		}
+
+		select {
+		case events <- event:
+		default:
+			slog.Warn("Dropping a watcher that fell behind", "component", "control", "service", event.Service)
+			delete(c.watchers, events)
+			close(events)
+		}
+	}
+}
+
+// Asks the control loop to run an iteration right away. Requests made while one is pending are merged
+func (c *Controller) Reconcile() {
+	select {
+	case c.reconcile <- struct{}{}:
+	default:
+	}
+}
+
+// Receives a value when a reconcile was requested
+func (c *Controller) Reconciles() <-chan struct{} {
+	return c.reconcile
+}
+
+func (c *Controller) update(service string, change func(status *ServiceStatus)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status, ok := c.services[service]
+	if !ok {
+		return ErrUnknownService
+	}
+
+	change(status)
+
+	return nil
+}
@@ -0,0 +1,325 @@
+package control
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	. "grs/common/types"
+	utils "grs/common/utils"
+)
+
+func TestControllerScale(t *testing.T) {
+	tests := []struct {
+		name        string
+		service     string
+		replicas    int
+		ttl         time.Duration
+		minReplicas int
+		err         bool
+		expires     bool
+	}{
+		{name: "until resumed", service: "web", replicas: 3},
+		{name: "with a ttl", service: "web", replicas: 3, ttl: time.Minute, expires: true},
+		{name: "at min_replicas", service: "web", replicas: 2, minReplicas: 2},
+		{name: "below min_replicas", service: "web", replicas: 1, minReplicas: 2, err: true},
+		{name: "at the most", service: "web", replicas: utils.MAX_MANUAL_REPLICAS},
+		{name: "above the most", service: "web", replicas: utils.MAX_MANUAL_REPLICAS + 1, err: true},
+		{name: "unknown service", service: "api", replicas: 3, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := NewController("web")
+			if test.minReplicas > 0 {
+				c.SetMinReplicas(test.minReplicas)
+			}
+
+			c.Pause("web")
+
+			err := c.Scale(test.service, test.replicas, test.ttl)
+			if (err != nil) != test.err {
+				t.Fatalf("Scale() error = %v, want an error: %v", err, test.err)
+			}
+
+			paused, override := c.Mode("web", time.Now())
+
+			if test.err {
+				if !paused || override != nil {
+					t.Errorf("Mode() = %v, %+v after a rejected Scale, want the service still paused without override", paused, override)
+				}
+				return
+			}
+
+			if paused || override == nil || override.Replicas != test.replicas {
+				t.Fatalf("Mode() = %v, %+v, want not paused and held at %d", paused, override, test.replicas)
+			}
+
+			if (override.Expires != nil) != test.expires {
+				t.Errorf("override expires = %v, want an expiry: %v", override.Expires, test.expires)
+			}
+
+			select {
+			case <-c.Reconciles():
+			default:
+				t.Error("Scale() didn't request a reconcile")
+			}
+		})
+	}
+}
+
+func TestControllerMode(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		at   time.Time
+		held bool
+	}{
+		{name: "until resumed", at: now.Add(24 * time.Hour), held: true},
+		{name: "before the expiry", ttl: time.Hour, at: now.Add(time.Minute), held: true},
+		{name: "after the expiry", ttl: time.Hour, at: now.Add(2 * time.Hour)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := NewController("web")
+			if err := c.Scale("web", 3, test.ttl); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, override := c.Mode("web", test.at); (override != nil) != test.held {
+				t.Fatalf("Mode() override = %+v, want held: %v", override, test.held)
+			}
+
+			// An expired override is cleared, not only hidden
+			if _, override := c.Mode("web", now); (override != nil) != test.held {
+				t.Errorf("Mode() override = %+v after the expiry, want held: %v", override, test.held)
+			}
+		})
+	}
+
+	c := NewController("web")
+	c.Scale("web", 3, 0)
+	c.Pause("web")
+
+	if err := c.Resume("web"); err != nil {
+		t.Fatal(err)
+	}
+
+	if paused, override := c.Mode("web", now); paused || override != nil {
+		t.Errorf("Mode() = %v, %+v after Resume, want neither paused nor held", paused, override)
+	}
+}
+
+func TestControllerDecisions(t *testing.T) {
+	tests := []struct {
+		name     string
+		recorded int
+		limit    int
+		// IDs of the decisions returned
+		ids []int
+	}{
+		{name: "none", recorded: 0, limit: 10, ids: []int{}},
+		{name: "newest first", recorded: 3, limit: 0, ids: []int{2, 1, 0}},
+		{name: "limited", recorded: 5, limit: 2, ids: []int{4, 3}},
+		{name: "limit above the history", recorded: 2, limit: 10, ids: []int{1, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := NewController("web")
+			for i := 0; i < test.recorded; i++ {
+				c.Record("web", nil, &Decision{ID: strconv.Itoa(i)}, time.Now())
+			}
+
+			decisions, err := c.Decisions("web", test.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(decisions) != len(test.ids) {
+				t.Fatalf("Decisions() returned %d decisions, want %d", len(decisions), len(test.ids))
+			}
+
+			for i, id := range test.ids {
+				if decisions[i].ID != strconv.Itoa(id) {
+					t.Errorf("decision %d = %s, want %d", i, decisions[i].ID, id)
+				}
+			}
+		})
+	}
+
+	c := NewController("web")
+	for i := 0; i < utils.CONTROL_DECISION_HISTORY_SIZE+10; i++ {
+		c.Record("web", nil, &Decision{}, time.Now())
+	}
+
+	if decisions, _ := c.Decisions("web", 0); len(decisions) != utils.CONTROL_DECISION_HISTORY_SIZE {
+		t.Errorf("%d decisions kept, want %d", len(decisions), utils.CONTROL_DECISION_HISTORY_SIZE)
+	}
+
+	if _, err := c.Decisions("api", 0); !errors.Is(err, ErrUnknownService) {
+		t.Errorf("Decisions() of an unknown service error = %v, want ErrUnknownService", err)
+	}
+}
+
+// Returns the events in events without waiting
+func drain(events <-chan WatchEvent) []WatchEvent {
+	var received []WatchEvent
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return received
+			}
+			received = append(received, event)
+		default:
+			return received
+		}
+	}
+}
+
+func TestControllerWatch(t *testing.T) {
+	type record struct {
+		service  string
+		decision bool
+	}
+
+	tests := []struct {
+		name    string
+		watch   string
+		records []record
+		// Services of the events received, a decision marked with a "!"
+		events []string
+	}{
+		{
+			name:    "one service",
+			watch:   "web",
+			records: []record{{"web", false}, {"api", true}, {"web", true}},
+			events:  []string{"web", "web", "web!"},
+		},
+		{
+			name:    "every service",
+			watch:   "",
+			records: []record{{"web", false}, {"api", true}, {"web", true}},
+			events:  []string{"web", "api", "api!", "web", "web!"},
+		},
+		{
+			name:    "unknown service recorded",
+			watch:   "",
+			records: []record{{"db", true}},
+			events:  nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := NewController("web", "api")
+
+			// Two watchers of the same service get the same events
+			first, stopFirst, err := c.Watch(test.watch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stopFirst()
+
+			second, stopSecond, err := c.Watch(test.watch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stopSecond()
+
+			for _, r := range test.records {
+				var decision *Decision
+				if r.decision {
+					decision = &Decision{}
+				}
+				c.Record(r.service, []*Stats{}, decision, time.Now())
+			}
+
+			for _, events := range []<-chan WatchEvent{first, second} {
+				var got []string
+				for _, event := range drain(events) {
+					if (event.Decision != nil) == (event.Stats != nil) {
+						t.Errorf("event %+v must have either stats or a decision", event)
+					}
+
+					name := event.Service
+					if event.Decision != nil {
+						name += "!"
+					}
+					got = append(got, name)
+				}
+
+				if len(got) != len(test.events) {
+					t.Fatalf("events = %v, want %v", got, test.events)
+				}
+
+				for i := range got {
+					if got[i] != test.events[i] {
+						t.Errorf("events = %v, want %v", got, test.events)
+						break
+					}
+				}
+			}
+		})
+	}
+
+	if _, _, err := NewController("web").Watch("api"); !errors.Is(err, ErrUnknownService) {
+		t.Errorf("Watch() of an unknown service error = %v, want ErrUnknownService", err)
+	}
+}
+
+func TestControllerWatchSlow(t *testing.T) {
+	c := NewController("web")
+
+	slow, stopSlow, _ := c.Watch("web")
+	fast, stopFast, _ := c.Watch("web")
+	defer stopFast()
+
+	received := 0
+	for i := 0; i < watchBuffer+1; i++ {
+		c.Record("web", []*Stats{}, nil, time.Now())
+		received += len(drain(fast))
+	}
+
+	if received != watchBuffer+1 {
+		t.Errorf("the watcher that keeps up received %d events, want %d", received, watchBuffer+1)
+	}
+
+	// The slow watcher gets what fitted in its buffer, then its channel is closed
+	events := 0
+	for range slow {
+		events++
+	}
+
+	if events != watchBuffer {
+		t.Errorf("the slow watcher received %d events, want %d", events, watchBuffer)
+	}
+
+	// Stopping a dropped watcher is harmless, and so is stopping twice
+	stopSlow()
+	stopSlow()
+
+	c.Record("web", []*Stats{}, nil, time.Now())
+	if len(drain(fast)) != 1 {
+		t.Error("the watcher that keeps up stopped receiving events")
+	}
+}
+
+func TestControllerWatchStop(t *testing.T) {
+	c := NewController("web")
+
+	events, stop, _ := c.Watch("web")
+	stop()
+
+	if _, ok := <-events; ok {
+		t.Fatal("the channel of a stopped watcher is still open")
+	}
+
+	// Publishing to no watcher, or after one stopped, must not panic
+	c.Record("web", []*Stats{}, &Decision{}, time.Now())
+}
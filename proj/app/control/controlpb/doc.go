@@ -0,0 +1,5 @@
+// Generated Go client and server stubs of the gRPC control plane, see control.proto.
+// Other programs can embed control of the autoscaler with NewControlClient
+package controlpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative control.proto
@@ -0,0 +1,371 @@
+package control
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	. "grs/common/types"
+	pb "grs/control/controlpb"
+)
+
+// Methods that only need the read scope, the others need the write scope
+var readMethods = []string{
+	pb.Control_GetStatus_FullMethodName,
+	pb.Control_ListDecisions_FullMethodName,
+	pb.Control_Watch_FullMethodName,
+}
+
+// Serves the gRPC control plane described in controlpb/control.proto. It is protected like the
+// HTTP control API: same TLS config, tokens (sent as "authorization: Bearer <token>" metadata),
+// scopes and audit log
+type GRPCServer struct {
+	pb.UnimplementedControlServer
+
+	controller *Controller
+	server     *grpc.Server
+
+	// Closed by Close, to end the Watch streams
+	closing chan struct{}
+}
+
+// Starts serving the gRPC control plane on config.Control.GRPCAddress, over TLS if a certificate is configured
+func NewGRPCServer(config *Config, controller *Controller) (*GRPCServer, error) {
+	g := &GRPCServer{controller: controller, closing: make(chan struct{})}
+
+	auth, err := newAuthenticator(config)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []grpc.ServerOption{
+		grpc.UnaryInterceptor(auth.unaryInterceptor),
+		grpc.StreamInterceptor(auth.streamInterceptor),
+	}
+
+	if tlsConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	// Listen right away so a port already in use is reported at startup
+	listener, err := net.Listen("tcp", config.Control.GRPCAddress)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("In control.NewGRPCServer: Failed to listen on %s -> %s", config.Control.GRPCAddress, err))
+	}
+
+	g.server = grpc.NewServer(options...)
+	pb.RegisterControlServer(g.server, g)
+
+	go func() {
+		if err := g.server.Serve(listener); err != nil {
+			slog.Error("Stopped serving the gRPC control plane", "component", "control", "error", err)
+		}
+	}()
+
+	return g, nil
+}
+
+// Stops serving, waiting for the calls in progress until ctx is done. Watch streams never end on
+// their own, so they are ended first
+func (g *GRPCServer) Close(ctx context.Context) error {
+	close(g.closing)
+
+	stopped := make(chan struct{})
+
+	go func() {
+		g.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		g.server.Stop()
+		return nil
+	}
+}
+
+func (g *GRPCServer) GetStatus(ctx context.Context, request *pb.GetStatusRequest) (*pb.GetStatusResponse, error) {
+	if request.Service == "" {
+		response := &pb.GetStatusResponse{}
+		for _, serviceStatus := range g.controller.Statuses() {
+			response.Services = append(response.Services, toProtoStatus(serviceStatus))
+		}
+
+		return response, nil
+	}
+
+	serviceStatus, err := g.controller.Status(request.Service)
+	if err != nil {
+		return nil, toGRPCError(err)
+	}
+
+	return &pb.GetStatusResponse{Services: []*pb.ServiceStatus{toProtoStatus(serviceStatus)}}, nil
+}
+
+func (g *GRPCServer) Scale(ctx context.Context, request *pb.ScaleRequest) (*pb.ServiceStatus, error) {
+	ttl := request.Ttl.AsDuration()
+	if request.Ttl != nil && (request.Ttl.CheckValid() != nil || ttl <= 0) {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid ttl %s", ttl))
+	}
+
+	if err := g.controller.Scale(request.Service, int(request.Replicas), ttl); err != nil {
+		return nil, toGRPCError(err)
+	}
+
+	return g.status(request.Service)
+}
+
+func (g *GRPCServer) Pause(ctx context.Context, request *pb.PauseRequest) (*pb.ServiceStatus, error) {
+	if err := g.controller.Pause(request.Service); err != nil {
+		return nil, toGRPCError(err)
+	}
+
+	return g.status(request.Service)
+}
+
+func (g *GRPCServer) Resume(ctx context.Context, request *pb.ResumeRequest) (*pb.ServiceStatus, error) {
+	if err := g.controller.Resume(request.Service); err != nil {
+		return nil, toGRPCError(err)
+	}
+
+	g.controller.Reconcile()
+
+	return g.status(request.Service)
+}
+
+func (g *GRPCServer) ListDecisions(ctx context.Context, request *pb.ListDecisionsRequest) (*pb.ListDecisionsResponse, error) {
+	decisions, err := g.controller.Decisions(request.Service, int(request.Limit))
+	if err != nil {
+		return nil, toGRPCError(err)
+	}
+
+	response := &pb.ListDecisionsResponse{Decisions: make([]*pb.Decision, 0, len(decisions))}
+	for _, decision := range decisions {
+		response.Decisions = append(response.Decisions, toProtoDecision(decision))
+	}
+
+	return response, nil
+}
+
+func (g *GRPCServer) Watch(request *pb.WatchRequest, stream pb.Control_WatchServer) error {
+	events, stop, err := g.controller.Watch(request.Service)
+	if err != nil {
+		return toGRPCError(err)
+	}
+	defer stop()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-g.closing:
+			return status.Error(codes.Unavailable, "the server is shutting down")
+		case event, ok := <-events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "the watcher fell behind, watch again")
+			}
+
+			if err := stream.Send(toProtoEvent(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (g *GRPCServer) status(service string) (*pb.ServiceStatus, error) {
+	serviceStatus, err := g.controller.Status(service)
+	if err != nil {
+		return nil, toGRPCError(err)
+	}
+
+	return toProtoStatus(serviceStatus), nil
+}
+
+func toGRPCError(err error) error {
+	if errors.Is(err, ErrUnknownService) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+
+	return status.Error(codes.InvalidArgument, err.Error())
+}
+
+// Authenticates the caller of a call from its metadata and its peer, and checks it has the scope fullMethod needs
+func (a *authenticator) authorize(ctx context.Context, fullMethod string) (*Caller, string, error) {
+	var state *tls.ConnectionState
+	remote := ""
+
+	if p, ok := peer.FromContext(ctx); ok {
+		remote = p.Addr.String()
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+	}
+
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+
+	caller, err := a.authenticate(state, authorization)
+	if err != nil {
+		slog.Warn("Rejected control request", "component", "control", "rpc", fullMethod, "remote", remote, "error", err)
+		return nil, remote, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	scope := SCOPE_WRITE
+	if slices.Contains(readMethods, fullMethod) {
+		scope = SCOPE_READ
+	}
+
+	if !slices.Contains(caller.Scopes, scope) {
+		slog.Warn("Rejected control request", "component", "control", "rpc", fullMethod, "remote", remote,
+			"caller", caller.Name, "error", "missing scope "+scope)
+		return nil, remote, status.Error(codes.PermissionDenied, fmt.Sprintf("the %s scope is required", scope))
+	}
+
+	return caller, remote, nil
+}
+
+// Lets the call through if its caller has the scope it needs, and audit-logs the mutating ones
+func (a *authenticator) unaryInterceptor(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	caller, remote, err := a.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := handler(ctx, request)
+
+	if !slices.Contains(readMethods, info.FullMethod) {
+		slog.Info("Audit", "component", "control", "audit", true, "caller", caller.Name, "remote", remote,
+			"rpc", info.FullMethod, "request", fmt.Sprint(request), "status", status.Code(err).String())
+	}
+
+	return response, err
+}
+
+// Lets the stream through if its caller has the scope it needs
+func (a *authenticator) streamInterceptor(server any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if _, _, err := a.authorize(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+
+	return handler(server, stream)
+}
+
+func toProtoStatus(status ServiceStatus) *pb.ServiceStatus {
+	s := &pb.ServiceStatus{
+		Service:   status.Service,
+		Paused:    status.Paused,
+		Replicas:  int32(status.Replicas),
+		LastStats: toProtoSample(status.LastStats),
+	}
+
+	if !status.LastUpdate.IsZero() {
+		s.LastUpdate = timestamppb.New(status.LastUpdate)
+	}
+
+	if status.Override != nil {
+		s.Override = &pb.Override{Replicas: int32(status.Override.Replicas)}
+		if status.Override.Expires != nil {
+			s.Override.Expires = timestamppb.New(*status.Override.Expires)
+		}
+	}
+
+	if status.LastDecision != nil {
+		s.LastDecision = toProtoDecision(status.LastDecision)
+	}
+
+	return s
+}
+
+func toProtoEvent(event WatchEvent) *pb.WatchEvent {
+	e := &pb.WatchEvent{Service: event.Service, Timestamp: timestamppb.New(event.Timestamp)}
+
+	if event.Decision != nil {
+		e.Event = &pb.WatchEvent_Decision{Decision: toProtoDecision(event.Decision)}
+	} else {
+		e.Event = &pb.WatchEvent_Stats{Stats: toProtoSample(event.Stats)}
+	}
+
+	return e
+}
+
+func toProtoSample(stats []*Stats) *pb.StatsSample {
+	sample := &pb.StatsSample{Replicas: make([]*pb.Stats, 0, len(stats))}
+
+	for _, stat := range stats {
+		replica := &pb.Stats{
+			UsedMemory:      stat.UsedMemory,
+			AvailableMemory: stat.AvailableMemory,
+			MemoryUsage:     stat.MemoryUsage,
+			NumberOfCpus:    int32(stat.NumberOfCPUs),
+			CpuUsage:        stat.CPUUsage,
+			Timestamp:       timestamppb.New(stat.Timestamp),
+			ContainerId:     stat.ContainerID,
+			ContainerName:   stat.ContainerName,
+			Image:           stat.Image,
+			Labels:          stat.Labels,
+		}
+
+		if stat.IntervalMs > 0 {
+			replica.Interval = durationpb.New(time.Duration(stat.IntervalMs * float64(time.Millisecond)))
+		}
+
+		sample.Replicas = append(sample.Replicas, replica)
+	}
+
+	return sample
+}
+
+func toProtoDecision(decision *Decision) *pb.Decision {
+	return &pb.Decision{
+		Id:        decision.ID,
+		Timestamp: timestamppb.New(decision.Timestamp),
+		Policy:    decision.Policy,
+		Inputs: &pb.DecisionInputs{
+			Samples:            int32(decision.Inputs.Samples),
+			AvgCpuUsage:        decision.Inputs.AvgCPUUsage,
+			MaxCpuUsage:        decision.Inputs.MaxCPUUsage,
+			AvgMemoryUsage:     decision.Inputs.AvgMemoryUsage,
+			MaxMemoryUsage:     decision.Inputs.MaxMemoryUsage,
+			CpuThreshold:       decision.Inputs.CPUThreshold,
+			MemoryThreshold:    decision.Inputs.MemoryThreshold,
+			MinReplicas:        int32(decision.Inputs.MinReplicas),
+			TriggerCpuUsage:    decision.Inputs.TriggerCPUUsage,
+			TriggerMemoryUsage: decision.Inputs.TriggerMemoryUsage,
+		},
+		CurrentReplicas: int32(decision.CurrentReplicas),
+		DesiredReplicas: int32(decision.DesiredReplicas),
+		Action:          decision.Action,
+		Reason:          decision.Reason,
+		Containers:      decision.Containers,
+		Outcome:         decision.Outcome,
+		Error:           decision.Error,
+		DurationMs:      decision.DurationMs,
+		DryRun:          decision.DryRun,
+		Upstream:        decision.Upstream,
+	}
+}
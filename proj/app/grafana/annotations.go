@@ -0,0 +1,156 @@
+package grafana
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	events "grs/common/events"
+	. "grs/common/types"
+	utils "grs/common/utils"
+)
+
+// Action tags of the annotations that are not a decision action
+const ACTION_ROLLBACK string = "rollback"
+const ACTION_RELOAD_FAILED string = "reload_failed"
+
+// Size of the queue of annotations waiting to be posted. When it is full new annotations are dropped
+const ANNOTATION_QUEUE_SIZE int = 100
+
+type annotation struct {
+	Time int64    `json:"time"`
+	Tags []string `json:"tags"`
+	Text string   `json:"text"`
+}
+
+// Posts an annotation to Grafana after every scale up, scale down, rollback and failed Nginx reload.
+// Annotations are tagged with service:<name> and action:<action>. They are posted in the background,
+// so a slow Grafana doesn't hold back the scaler
+type Annotator struct {
+	client  *Client
+	service string
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan annotation
+	done   chan struct{}
+}
+
+func NewAnnotator(client *Client, service string) *Annotator {
+	a := &Annotator{
+		client:  client,
+		service: service,
+		queue:   make(chan annotation, ANNOTATION_QUEUE_SIZE),
+		done:    make(chan struct{}),
+	}
+
+	go a.post()
+
+	events.Subscribe(a.handleEvent)
+
+	return a
+}
+
+func (a *Annotator) Name() string {
+	return "grafana"
+}
+
+// Stats are not annotated
+func (a *Annotator) WriteStats(ctx context.Context, stats []*Stats, timestamp time.Time) error {
+	return nil
+}
+
+// Only decisions that changed or tried to change the replicas are annotated: not the noop ones, like a scale
+// down when min_replicas are running, nor the planned ones of a dry run
+func (a *Annotator) WriteDecision(ctx context.Context, decision *Decision) error {
+	if decision.Action == utils.ACTION_NONE || decision.Outcome == utils.OUTCOME_NOOP || decision.DryRun {
+		return nil
+	}
+
+	action := decision.Action
+	if decision.Outcome == utils.OUTCOME_ROLLED_BACK {
+		action = ACTION_ROLLBACK
+	}
+
+	text := fmt.Sprintf("%s %s: %d -> %d replicas (%s)<br>%s", a.service, strings.ReplaceAll(action, "_", " "),
+		decision.CurrentReplicas, decision.DesiredReplicas, decision.Outcome, decision.Reason)
+
+	if decision.Error != "" {
+		text = fmt.Sprintf("%s<br>error: %s", text, decision.Error)
+	}
+
+	a.enqueue(annotation{
+		Time: decision.Timestamp.UnixMilli(),
+		Tags: a.tags(action, fmt.Sprintf("outcome:%s", decision.Outcome)),
+		Text: text,
+	})
+
+	return nil
+}
+
+// Posts the annotations still in the queue, until ctx is done
+func (a *Annotator) Close(ctx context.Context) error {
+	a.mu.Lock()
+	a.closed = true
+	close(a.queue)
+	a.mu.Unlock()
+
+	select {
+	case <-a.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Rollbacks are annotated through their decision, only failed reloads come from events
+func (a *Annotator) handleEvent(e Event) {
+	if e.Type != events.NGINX_RELOAD_FAILED {
+		return
+	}
+
+	a.enqueue(annotation{
+		Time: e.Timestamp.UnixMilli(),
+		Tags: a.tags(ACTION_RELOAD_FAILED),
+		Text: fmt.Sprintf("%s: %s<br>%s", a.service, e.Message, e.Error),
+	})
+}
+
+func (a *Annotator) tags(action string, extra ...string) []string {
+	return append([]string{fmt.Sprintf("service:%s", a.service), fmt.Sprintf("action:%s", action)}, extra...)
+}
+
+func (a *Annotator) enqueue(an annotation) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Events may still be published after Close
+	if a.closed {
+		return
+	}
+
+	select {
+	case a.queue <- an:
+	default:
+		slog.Warn("Queue is full, dropping annotation", "component", "grafana_annotator")
+	}
+}
+
+func (a *Annotator) post() {
+	defer close(a.done)
+
+	for an := range a.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+
+		if err := a.client.Do(ctx, http.MethodPost, "/api/annotations", an, nil); err != nil {
+			// Not published as an event, a failed reload annotation would end up here again
+			slog.Error("Failed to post annotation", "component", "grafana_annotator", "error", err)
+		}
+
+		cancel()
+	}
+}
@@ -0,0 +1,127 @@
+// Talks to the Grafana HTTP API
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	clients "grs/common/clients"
+	"grs/common/resilience"
+	. "grs/common/types"
+)
+
+// Grafana HTTP API client. Authenticates with the token if there is one, with user and password otherwise
+type Client struct {
+	url      string
+	user     string
+	password string
+	token    string
+
+	http   *http.Client
+	policy *resilience.Policy
+}
+
+// Error returned when Grafana answers with an error status
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Grafana answered %d -> %s", e.StatusCode, e.Body)
+}
+
+func NewClient(config *Config) *Client {
+	return &Client{
+		url:      strings.TrimSuffix(config.Grafana.URL, "/"),
+		user:     config.Grafana.User,
+		password: config.Grafana.Password,
+		token:    config.Grafana.Token,
+		http:     &http.Client{Timeout: 10 * time.Second},
+		policy: &resilience.Policy{
+			Service:  "grafana",
+			Backoff:  clients.DEFAULT_BACKOFF,
+			Breaker:  resilience.NewCircuitBreaker(clients.BREAKER_THRESHOLD, clients.BREAKER_COOLDOWN),
+			Classify: classifyGrafanaError,
+		},
+	}
+}
+
+func classifyGrafanaError(err error) resilience.Kind {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode != 429 && statusErr.StatusCode < 500 {
+		return resilience.Permanent
+	}
+
+	return resilience.Transient
+}
+
+// Sends a request to the API. body is marshalled to JSON if not nil and the response is
+// unmarshalled into out if not nil. Only GET, PUT and DELETE requests are retried
+func (c *Client) Do(ctx context.Context, method string, path string, body any, out any) error {
+	var payload []byte
+
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return errors.New(fmt.Sprintf("In grafana.Client.Do: Failed to marshal body -> %s", err))
+		}
+	}
+
+	idempotent := method != http.MethodPost
+
+	var data []byte
+
+	err := c.policy.Do(ctx, fmt.Sprintf("%s %s", method, path), idempotent, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, method, c.url+path, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+
+		if c.token != "" {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token))
+		} else {
+			req.SetBasicAuth(c.user, c.password)
+		}
+
+		res, err := c.http.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		data, err = io.ReadAll(res.Body)
+		if err != nil {
+			return err
+		}
+
+		if res.StatusCode >= 300 {
+			return &StatusError{StatusCode: res.StatusCode, Body: string(data)}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return errors.New(fmt.Sprintf("In grafana.Client.Do: Failed to parse response of %s %s -> %s", method, path, err))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package grafana
+
+import (
+	"fmt"
+
+	. "grs/common/types"
+)
+
+// Returns the built-in dashboards: replica CPU and memory, replica count, scaling events and load balancer traffic
+func Dashboards(config *Config) []map[string]any {
+	return []map[string]any{
+		replicasDashboard(config),
+		replicaCountDashboard(config),
+		scalingEventsDashboard(config),
+		loadBalancerDashboard(config),
+	}
+}
+
+func replicasDashboard(config *Config) map[string]any {
+	return dashboard("grs-replicas", "GRS - Replica CPU and memory", config, []map[string]any{
+		timeseriesPanel(1, "CPU usage", "percent", gridPos(0, 0, 24, 9),
+			esTarget("A", STATS_DATASOURCE_UID, "Average", "", esMetric("1", "avg", "cpu_usage")),
+			esTarget("B", STATS_DATASOURCE_UID, "Max", "", esMetric("1", "max", "cpu_usage")),
+		),
+		timeseriesPanel(2, "Memory usage", "percent", gridPos(0, 9, 24, 9),
+			esTarget("A", STATS_DATASOURCE_UID, "Average", "", esMetric("1", "avg", "memory_usage")),
+			esTarget("B", STATS_DATASOURCE_UID, "Max", "", esMetric("1", "max", "memory_usage")),
+		),
+		timeseriesPanel(3, "Used memory", "bytes", gridPos(0, 18, 24, 9),
+			esTarget("A", STATS_DATASOURCE_UID, "Total", "", esMetric("1", "sum", "used_memory")),
+		),
+	})
+}
+
+func replicaCountDashboard(config *Config) map[string]any {
+	return dashboard("grs-replica-count", "GRS - Replica count", config, []map[string]any{
+		timeseriesPanel(1, "Replicas", "short", gridPos(0, 0, 24, 12),
+			esTarget("A", DECISIONS_DATASOURCE_UID, "Running", "", esMetric("1", "max", "current_replicas")),
+			esTarget("B", DECISIONS_DATASOURCE_UID, "Desired", "", esMetric("1", "max", "desired_replicas")),
+		),
+	})
+}
+
+func scalingEventsDashboard(config *Config) map[string]any {
+	return dashboard("grs-scaling-events", "GRS - Scaling events", config, []map[string]any{
+		timeseriesPanel(1, "Scale actions", "short", gridPos(0, 0, 24, 8),
+			esTarget("A", DECISIONS_DATASOURCE_UID, "Scale up", "action:scale_up", esMetric("1", "count", "")),
+			esTarget("B", DECISIONS_DATASOURCE_UID, "Scale down", "action:scale_down", esMetric("1", "count", "")),
+			esTarget("C", DECISIONS_DATASOURCE_UID, "Failed", "outcome:failed OR outcome:rolled_back", esMetric("1", "count", "")),
+		),
+		{
+			"id":         2,
+			"type":       "table",
+			"title":      "Decisions",
+			"gridPos":    gridPos(0, 8, 24, 14),
+			"datasource": datasourceRef(DECISIONS_DATASOURCE_UID),
+			"targets": []any{
+				map[string]any{
+					"refId":      "A",
+					"datasource": datasourceRef(DECISIONS_DATASOURCE_UID),
+					"query":      "NOT action:none",
+					"timeField":  "@timestamp",
+					"metrics":    []any{map[string]any{"id": "1", "type": "raw_data", "settings": map[string]any{"size": "500"}}},
+					"bucketAggs": []any{},
+				},
+			},
+		},
+	})
+}
+
+func loadBalancerDashboard(config *Config) map[string]any {
+	return dashboard("grs-load-balancer", "GRS - Load balancer traffic", config, []map[string]any{
+		timeseriesPanel(1, "Traffic", "Bps", gridPos(0, 0, 24, 10),
+			esTarget("A", LOAD_BALANCER_DATASOURCE_UID, "Received", "", esMetric("1", "max", "rx_bytes", true), esDerivative("2", "1")),
+			esTarget("B", LOAD_BALANCER_DATASOURCE_UID, "Sent", "", esMetric("1", "max", "tx_bytes", true), esDerivative("2", "1")),
+		),
+		timeseriesPanel(2, "Packets", "pps", gridPos(0, 10, 24, 10),
+			esTarget("A", LOAD_BALANCER_DATASOURCE_UID, "Received", "", esMetric("1", "max", "rx_packets", true), esDerivative("2", "1")),
+			esTarget("B", LOAD_BALANCER_DATASOURCE_UID, "Sent", "", esMetric("1", "max", "tx_packets", true), esDerivative("2", "1")),
+		),
+	})
+}
+
+// Every dashboard shows the annotations posted for the scale events of the service
+func dashboard(uid string, title string, config *Config, panels []map[string]any) map[string]any {
+	return map[string]any{
+		"uid":           uid,
+		"title":         title,
+		"tags":          []string{"grs", config.Service},
+		"timezone":      "browser",
+		"schemaVersion": 39,
+		"refresh":       "10s",
+		"time":          map[string]any{"from": "now-1h", "to": "now"},
+		"panels":        panels,
+		"annotations": map[string]any{
+			"list": []any{
+				map[string]any{
+					"name":       "Scale events",
+					"enable":     true,
+					"iconColor":  "orange",
+					"datasource": map[string]any{"type": "grafana", "uid": "-- Grafana --"},
+					"target": map[string]any{
+						"type":     "tags",
+						"tags":     []string{fmt.Sprintf("service:%s", config.Service)},
+						"matchAny": true,
+						"limit":    100,
+					},
+				},
+			},
+		},
+	}
+}
+
+func timeseriesPanel(id int, title string, unit string, pos map[string]any, targets ...map[string]any) map[string]any {
+	return map[string]any{
+		"id":         id,
+		"type":       "timeseries",
+		"title":      title,
+		"gridPos":    pos,
+		"datasource": targets[0]["datasource"],
+		"targets":    targets,
+		"fieldConfig": map[string]any{
+			"defaults":  map[string]any{"unit": unit},
+			"overrides": []any{},
+		},
+	}
+}
+
+func gridPos(x int, y int, w int, h int) map[string]any {
+	return map[string]any{"x": x, "y": y, "w": w, "h": h}
+}
+
+func datasourceRef(uid string) map[string]any {
+	return map[string]any{"type": "elasticsearch", "uid": uid}
+}
+
+// Elasticsearch query bucketed by a date histogram on @timestamp
+func esTarget(refID string, datasourceUID string, alias string, query string, metrics ...map[string]any) map[string]any {
+	return map[string]any{
+		"refId":      refID,
+		"datasource": datasourceRef(datasourceUID),
+		"alias":      alias,
+		"query":      query,
+		"timeField":  "@timestamp",
+		"metrics":    metrics,
+		"bucketAggs": []any{
+			map[string]any{"id": "10", "type": "date_histogram", "field": "@timestamp", "settings": map[string]any{"interval": "auto"}},
+		},
+	}
+}
+
+func esMetric(id string, aggregation string, field string, hide ...bool) map[string]any {
+	metric := map[string]any{"id": id, "type": aggregation}
+
+	if field != "" {
+		metric["field"] = field
+	}
+
+	if len(hide) > 0 {
+		metric["hide"] = hide[0]
+	}
+
+	return metric
+}
+
+// Per second rate of the metric with ID of, used on counters that only grow
+func esDerivative(id string, of string) map[string]any {
+	return map[string]any{"id": id, "type": "derivative", "field": of, "settings": map[string]any{"unit": "1s"}}
+}
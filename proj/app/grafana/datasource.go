@@ -0,0 +1,505 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	. "grs/common/types"
+	utils "grs/common/utils"
+)
+
+// Targets served by the JSON datasource
+const TARGET_CURRENT_REPLICAS string = "current_replicas"
+const TARGET_DESIRED_REPLICAS string = "desired_replicas"
+const TARGET_REPLICA_CPU_USAGE string = "replica_cpu_usage"
+const TARGET_REPLICA_MEMORY_USAGE string = "replica_memory_usage"
+const TARGET_POLICY_AVG_CPU_USAGE string = "policy_avg_cpu_usage"
+const TARGET_POLICY_MAX_CPU_USAGE string = "policy_max_cpu_usage"
+const TARGET_POLICY_AVG_MEMORY_USAGE string = "policy_avg_memory_usage"
+const TARGET_POLICY_MAX_MEMORY_USAGE string = "policy_max_memory_usage"
+const TARGET_POLICY_CPU_THRESHOLD string = "policy_cpu_threshold"
+const TARGET_POLICY_MEMORY_THRESHOLD string = "policy_memory_threshold"
+const TARGET_DECISIONS string = "decisions"
+
+// Series that are read from the decisions
+var decisionSeries = map[string]func(d *Decision) float64{
+	TARGET_CURRENT_REPLICAS:        func(d *Decision) float64 { return float64(d.CurrentReplicas) },
+	TARGET_DESIRED_REPLICAS:        func(d *Decision) float64 { return float64(d.DesiredReplicas) },
+	TARGET_POLICY_AVG_CPU_USAGE:    func(d *Decision) float64 { return d.Inputs.AvgCPUUsage },
+	TARGET_POLICY_MAX_CPU_USAGE:    func(d *Decision) float64 { return d.Inputs.MaxCPUUsage },
+	TARGET_POLICY_AVG_MEMORY_USAGE: func(d *Decision) float64 { return d.Inputs.AvgMemoryUsage },
+	TARGET_POLICY_MAX_MEMORY_USAGE: func(d *Decision) float64 { return d.Inputs.MaxMemoryUsage },
+	TARGET_POLICY_CPU_THRESHOLD:    func(d *Decision) float64 { return d.Inputs.CPUThreshold },
+	TARGET_POLICY_MEMORY_THRESHOLD: func(d *Decision) float64 { return d.Inputs.MemoryThreshold },
+}
+
+// Keys that can be used to filter the decisions table and the annotations
+var tagKeys = []string{"action", "outcome"}
+
+// Usage of every replica at one collection, by container name
+type sample struct {
+	timestamp time.Time
+	cpu       map[string]float64
+	memory    map[string]float64
+}
+
+type timeRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type filter struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+type queryRequest struct {
+	Range   timeRange `json:"range"`
+	Targets []struct {
+		Target string `json:"target"`
+		RefID  string `json:"refId"`
+	} `json:"targets"`
+	MaxDataPoints int      `json:"maxDataPoints"`
+	AdhocFilters  []filter `json:"adhocFilters"`
+}
+
+type timeSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+type column struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type table struct {
+	Type    string   `json:"type"`
+	Columns []column `json:"columns"`
+	Rows    [][]any  `json:"rows"`
+}
+
+type annotationRequest struct {
+	Range      timeRange      `json:"range"`
+	Annotation map[string]any `json:"annotation"`
+}
+
+type datasourceAnnotation struct {
+	Annotation map[string]any `json:"annotation"`
+	Time       int64          `json:"time"`
+	Title      string         `json:"title"`
+	Text       string         `json:"text"`
+	Tags       []string       `json:"tags"`
+}
+
+type tag struct {
+	Type string `json:"type,omitempty"`
+	Text string `json:"text"`
+}
+
+// Serves the live state of the scaler with the HTTP contract of the simpod-json-datasource
+// Grafana plugin (/search, /query, /annotations, /tag-keys and /tag-values). It keeps the
+// last DATASOURCE_HISTORY_SIZE iterations in memory, so it doesn't need Elasticsearch
+type Datasource struct {
+	service string
+	server  *http.Server
+
+	mu        sync.RWMutex
+	samples   []sample
+	decisions []*Decision
+}
+
+// Starts serving on address, each request going through auth first
+func NewDatasource(address string, service string, auth func(http.Handler) http.Handler) (*Datasource, error) {
+	d := &Datasource{service: service}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", d.handleHealth)
+	mux.HandleFunc("/search", d.handleSearch)
+	mux.HandleFunc("/query", d.handleQuery)
+	mux.HandleFunc("/annotations", d.handleAnnotations)
+	mux.HandleFunc("/tag-keys", d.handleTagKeys)
+	mux.HandleFunc("/tag-values", d.handleTagValues)
+
+	// Listen right away so a port already in use is reported when the sink is created
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("In grafana.NewDatasource: Failed to listen on %s -> %s", address, err))
+	}
+
+	d.server = &http.Server{Handler: auth(mux)}
+
+	go func() {
+		if err := d.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Stopped serving", "component", "grafana_datasource", "error", err)
+		}
+	}()
+
+	return d, nil
+}
+
+func (d *Datasource) Name() string {
+	return utils.SINK_GRAFANA_JSON
+}
+
+func (d *Datasource) WriteStats(ctx context.Context, stats []*Stats, timestamp time.Time) error {
+	s := sample{timestamp: timestamp, cpu: map[string]float64{}, memory: map[string]float64{}}
+
+	for _, stat := range stats {
+		s.cpu[stat.ContainerName] = stat.CPUUsage
+		s.memory[stat.ContainerName] = stat.MemoryUsage
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.samples = append(d.samples, s)
+	if len(d.samples) > utils.DATASOURCE_HISTORY_SIZE {
+		d.samples = d.samples[len(d.samples)-utils.DATASOURCE_HISTORY_SIZE:]
+	}
+
+	return nil
+}
+
+func (d *Datasource) WriteDecision(ctx context.Context, decision *Decision) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.decisions = append(d.decisions, decision)
+	if len(d.decisions) > utils.DATASOURCE_HISTORY_SIZE {
+		d.decisions = d.decisions[len(d.decisions)-utils.DATASOURCE_HISTORY_SIZE:]
+	}
+
+	return nil
+}
+
+func (d *Datasource) Close(ctx context.Context) error {
+	return d.server.Shutdown(ctx)
+}
+
+// Grafana tests the connection with a GET on the root
+func (d *Datasource) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Lists the targets, filtered by the text typed in the query editor
+func (d *Datasource) handleSearch(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Target string `json:"target"`
+	}
+
+	if !decodeRequest(w, r, &request) {
+		return
+	}
+
+	targets := []string{
+		TARGET_CURRENT_REPLICAS,
+		TARGET_DESIRED_REPLICAS,
+		TARGET_REPLICA_CPU_USAGE,
+		TARGET_REPLICA_MEMORY_USAGE,
+		TARGET_POLICY_AVG_CPU_USAGE,
+		TARGET_POLICY_MAX_CPU_USAGE,
+		TARGET_POLICY_AVG_MEMORY_USAGE,
+		TARGET_POLICY_MAX_MEMORY_USAGE,
+		TARGET_POLICY_CPU_THRESHOLD,
+		TARGET_POLICY_MEMORY_THRESHOLD,
+		TARGET_DECISIONS,
+	}
+
+	matches := []string{}
+	for _, target := range targets {
+		if strings.Contains(target, request.Target) {
+			matches = append(matches, target)
+		}
+	}
+
+	writeResponse(w, matches)
+}
+
+// Answers every target of the query with time series, or with a table for the decisions
+func (d *Datasource) handleQuery(w http.ResponseWriter, r *http.Request) {
+	var request queryRequest
+
+	if !decodeRequest(w, r, &request) {
+		return
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	response := []any{}
+
+	for _, target := range request.Targets {
+		switch target.Target {
+		case TARGET_DECISIONS:
+			response = append(response, d.decisionsTable(request.Range, request.AdhocFilters))
+		case TARGET_REPLICA_CPU_USAGE:
+			response = append(response, d.replicaSeries(request.Range, func(s sample) map[string]float64 { return s.cpu })...)
+		case TARGET_REPLICA_MEMORY_USAGE:
+			response = append(response, d.replicaSeries(request.Range, func(s sample) map[string]float64 { return s.memory })...)
+		default:
+			value, ok := decisionSeries[target.Target]
+			if !ok {
+				http.Error(w, fmt.Sprintf("unknown target %s", target.Target), http.StatusBadRequest)
+				return
+			}
+
+			series := timeSeries{Target: target.Target, Datapoints: [][2]float64{}}
+			for _, decision := range d.decisionsIn(request.Range) {
+				series.Datapoints = append(series.Datapoints, [2]float64{value(decision), float64(decision.Timestamp.UnixMilli())})
+			}
+
+			response = append(response, series)
+		}
+	}
+
+	writeResponse(w, response)
+}
+
+// Annotates the decisions that scaled the service. The query of the annotation, if any,
+// only keeps the decisions with that action or outcome
+func (d *Datasource) handleAnnotations(w http.ResponseWriter, r *http.Request) {
+	var request annotationRequest
+
+	if !decodeRequest(w, r, &request) {
+		return
+	}
+
+	query, _ := request.Annotation["query"].(string)
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	annotations := []datasourceAnnotation{}
+
+	for _, decision := range d.decisionsIn(request.Range) {
+		if decision.Action == utils.ACTION_NONE {
+			continue
+		}
+
+		if query != "" && query != decision.Action && query != decision.Outcome {
+			continue
+		}
+
+		title := fmt.Sprintf("%s %s: %d -> %d replicas", d.service, strings.ReplaceAll(decision.Action, "_", " "), decision.CurrentReplicas, decision.DesiredReplicas)
+
+		// The replicas didn't change, which the title must not suggest
+		switch {
+		case decision.DryRun:
+			title += " (dry run)"
+		case decision.Outcome == utils.OUTCOME_NOOP:
+			title += " (nothing to do)"
+		}
+
+		annotations = append(annotations, datasourceAnnotation{
+			Annotation: request.Annotation,
+			Time:       decision.Timestamp.UnixMilli(),
+			Title:      title,
+			Text:       decision.Reason,
+			Tags:       []string{"service:" + d.service, "action:" + decision.Action, "outcome:" + decision.Outcome},
+		})
+	}
+
+	writeResponse(w, annotations)
+}
+
+// Lists the keys of the ad hoc filters
+func (d *Datasource) handleTagKeys(w http.ResponseWriter, r *http.Request) {
+	keys := []tag{}
+	for _, key := range tagKeys {
+		keys = append(keys, tag{Type: "string", Text: key})
+	}
+
+	writeResponse(w, keys)
+}
+
+// Lists the values of an ad hoc filter key seen in the decisions kept in memory
+func (d *Datasource) handleTagValues(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Key string `json:"key"`
+	}
+
+	if !decodeRequest(w, r, &request) {
+		return
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	seen := map[string]bool{}
+	values := []tag{}
+
+	for _, decision := range d.decisions {
+		value, ok := decisionTag(decision, request.Key)
+		if !ok || seen[value] {
+			continue
+		}
+
+		seen[value] = true
+		values = append(values, tag{Text: value})
+	}
+
+	writeResponse(w, values)
+}
+
+// One series per replica, named after its container. Series come in the order their replica was first seen,
+// and the replicas of a sample by name, so the order is the same on every query
+func (d *Datasource) replicaSeries(tr timeRange, values func(s sample) map[string]float64) []any {
+	var series []*timeSeries
+	byName := map[string]*timeSeries{}
+
+	for _, s := range d.samples {
+		if s.timestamp.Before(tr.From) || s.timestamp.After(tr.To) {
+			continue
+		}
+
+		sampleValues := values(s)
+
+		names := make([]string, 0, len(sampleValues))
+		for name := range sampleValues {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			ts, ok := byName[name]
+			if !ok {
+				ts = &timeSeries{Target: name, Datapoints: [][2]float64{}}
+				byName[name] = ts
+				series = append(series, ts)
+			}
+
+			ts.Datapoints = append(ts.Datapoints, [2]float64{sampleValues[name], float64(s.timestamp.UnixMilli())})
+		}
+	}
+
+	response := make([]any, 0, len(series))
+	for _, s := range series {
+		response = append(response, s)
+	}
+
+	return response
+}
+
+func (d *Datasource) decisionsTable(tr timeRange, filters []filter) table {
+	t := table{
+		Type: "table",
+		Columns: []column{
+			{Text: "Time", Type: "time"},
+			{Text: "Action", Type: "string"},
+			{Text: "Outcome", Type: "string"},
+			{Text: "Current replicas", Type: "number"},
+			{Text: "Desired replicas", Type: "number"},
+			{Text: "Reason", Type: "string"},
+			{Text: "Error", Type: "string"},
+			{Text: "Duration (ms)", Type: "number"},
+		},
+		Rows: [][]any{},
+	}
+
+	for _, decision := range d.decisionsIn(tr) {
+		if !matchesFilters(decision, filters) {
+			continue
+		}
+
+		t.Rows = append(t.Rows, []any{
+			decision.Timestamp.UnixMilli(),
+			decision.Action,
+			decision.Outcome,
+			decision.CurrentReplicas,
+			decision.DesiredReplicas,
+			decision.Reason,
+			decision.Error,
+			decision.DurationMs,
+		})
+	}
+
+	return t
+}
+
+// Returns the decisions taken in the time range, oldest first
+func (d *Datasource) decisionsIn(tr timeRange) []*Decision {
+	var decisions []*Decision
+
+	for _, decision := range d.decisions {
+		if decision.Timestamp.Before(tr.From) || decision.Timestamp.After(tr.To) {
+			continue
+		}
+
+		decisions = append(decisions, decision)
+	}
+
+	return decisions
+}
+
+// Only the = and != operators are supported, filters on unknown keys don't match anything
+func matchesFilters(decision *Decision, filters []filter) bool {
+	for _, f := range filters {
+		value, ok := decisionTag(decision, f.Key)
+		if !ok {
+			return false
+		}
+
+		switch f.Operator {
+		case "=":
+			if value != f.Value {
+				return false
+			}
+		case "!=":
+			if value == f.Value {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func decisionTag(decision *Decision, key string) (string, bool) {
+	switch key {
+	case "action":
+		return decision.Action, true
+	case "outcome":
+		return decision.Outcome, true
+	}
+
+	return "", false
+}
+
+// Decodes the JSON body of a POST request. Answers with an error and returns false if it can't
+func decodeRequest(w http.ResponseWriter, r *http.Request, request any) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request -> %s", err), http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
+
+func writeResponse(w http.ResponseWriter, response any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Warn("Failed to write response", "component", "grafana_datasource", "error", err)
+	}
+}
@@ -0,0 +1,3 @@
+module grs/grafana
+
+go 1.22.2
@@ -0,0 +1,130 @@
+package grafana
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	. "grs/common/types"
+	utils "grs/common/utils"
+)
+
+// UIDs of what the application provisions, fixed so provisioning again updates instead of duplicating
+const STATS_DATASOURCE_UID string = "grs-stats"
+const DECISIONS_DATASOURCE_UID string = "grs-decisions"
+const LOAD_BALANCER_DATASOURCE_UID string = "grs-load-balancer"
+const LIVE_DATASOURCE_UID string = "grs-live"
+const FOLDER_UID string = "grs"
+const FOLDER_TITLE string = "GRS"
+
+// Creates or updates the Elasticsearch datasources, the JSON datasource if its sink is enabled and the built-in dashboards.
+// Running it again with the same config leaves Grafana as it was
+func Provision(ctx context.Context, client *Client, config *Config) error {
+	datasources := []map[string]any{
+		elasticsearchDatasource(STATS_DATASOURCE_UID, "GRS Stats", config.Grafana.ElasticsearchURL, config.Elasticsearch.Index),
+		elasticsearchDatasource(DECISIONS_DATASOURCE_UID, "GRS Scaling Decisions", config.Grafana.ElasticsearchURL, config.Elasticsearch.DecisionIndex),
+		elasticsearchDatasource(LOAD_BALANCER_DATASOURCE_UID, "GRS Load Balancer", config.Grafana.ElasticsearchURL, config.Elasticsearch.LoadBalancerIndex),
+	}
+
+	if slices.Contains(config.Sinks, utils.SINK_GRAFANA_JSON) {
+		live := map[string]any{
+			"uid":    LIVE_DATASOURCE_UID,
+			"name":   "GRS Live",
+			"type":   "simpod-json-datasource",
+			"access": "proxy",
+			"url":    config.Grafana.DatasourceURL,
+		}
+
+		// Grafana sends the token with every request, like a control API client
+		if config.Grafana.DatasourceToken != "" {
+			live["jsonData"] = map[string]any{"httpHeaderName1": "Authorization"}
+			live["secureJsonData"] = map[string]any{"httpHeaderValue1": "Bearer " + config.Grafana.DatasourceToken}
+		}
+
+		datasources = append(datasources, live)
+	}
+
+	for _, ds := range datasources {
+		if err := upsertDatasource(ctx, client, ds); err != nil {
+			return err
+		}
+	}
+
+	if err := ensureFolder(ctx, client); err != nil {
+		return err
+	}
+
+	for _, dashboard := range Dashboards(config) {
+		body := map[string]any{
+			"dashboard": dashboard,
+			"folderUid": FOLDER_UID,
+			"overwrite": true,
+			"message":   "Provisioned by the GRS autoscaler",
+		}
+
+		if err := client.Do(ctx, http.MethodPost, "/api/dashboards/db", body, nil); err != nil {
+			return errors.New(fmt.Sprintf("In grafana.Provision: Failed to import dashboard %s -> %s", dashboard["title"], err))
+		}
+
+		slog.Info("Dashboard provisioned", "component", "grafana", "dashboard", dashboard["title"])
+	}
+
+	return nil
+}
+
+func elasticsearchDatasource(uid string, name string, url string, index string) map[string]any {
+	return map[string]any{
+		"uid":      uid,
+		"name":     name,
+		"type":     "elasticsearch",
+		"access":   "proxy",
+		"url":      url,
+		"database": index,
+		"jsonData": map[string]any{
+			"index":                      index,
+			"timeField":                  "@timestamp",
+			"maxConcurrentShardRequests": 5,
+		},
+	}
+}
+
+// Updates the datasource if one with the same UID exists, creates it otherwise
+func upsertDatasource(ctx context.Context, client *Client, ds map[string]any) error {
+	path := fmt.Sprintf("/api/datasources/uid/%s", ds["uid"])
+
+	err := client.Do(ctx, http.MethodGet, path, nil, nil)
+
+	var statusErr *StatusError
+	switch {
+	case err == nil:
+		err = client.Do(ctx, http.MethodPut, path, ds, nil)
+	case errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound:
+		err = client.Do(ctx, http.MethodPost, "/api/datasources", ds, nil)
+	}
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("In grafana.upsertDatasource: Failed to provision datasource %s -> %s", ds["name"], err))
+	}
+
+	slog.Info("Datasource provisioned", "component", "grafana", "datasource", ds["name"])
+
+	return nil
+}
+
+func ensureFolder(ctx context.Context, client *Client) error {
+	err := client.Do(ctx, http.MethodGet, fmt.Sprintf("/api/folders/%s", FOLDER_UID), nil, nil)
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		err = client.Do(ctx, http.MethodPost, "/api/folders", map[string]any{"uid": FOLDER_UID, "title": FOLDER_TITLE}, nil)
+	}
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("In grafana.ensureFolder: Failed to provision folder %s -> %s", FOLDER_TITLE, err))
+	}
+
+	return nil
+}
@@ -2,121 +2,442 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
-	"strconv"
-	"strings"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+
+	clients "grs/common/clients"
+	events "grs/common/events"
+	logging "grs/common/logging"
+	telemetry "grs/common/telemetry"
 	. "grs/common/types"
 	. "grs/common/utils"
+	control "grs/control"
+	grafana "grs/grafana"
 	metric_collector "grs/metric-collector"
 	scaler "grs/scaler"
-
-	"github.com/elastic/go-elasticsearch/v8/esapi"
-	"github.com/elastic/go-elasticsearch/v8"
+	sinks "grs/sinks"
 )
 
 const CONFIG_FILE string = "config.yaml"
 
-// Runs the application. One Go routine runs the metric collector and other runs the auto scaler 
+// Environment variable with the path of the config file, overridden by --config
+const CONFIG_FILE_ENV string = "GRS_CONFIG"
+
+const USAGE string = `Usage: grs [--config config.yaml] <command> [arguments]
+
+Commands:
+  run                                     runs the autoscaler (the default)
+  status [-json]                          prints the status of a running instance
+  scale [-ttl 15m] <service> <replicas>   holds a service of a running instance at a number of replicas
+  validate                                checks the config file and exits with 1 if it is invalid
+  schema                                  prints the JSON Schema of the config file, for editors
+  config                                  prints the effective config and where each value comes from
+  plan                                    collects the stats once and prints what the scaler would do
+  why [-since 24h] [-limit 20] [id]       prints the decisions that started or stopped containers
+
+Run grs <command> -h for the flags of a command.
+
+Every field of the config file can be overridden by an environment variable, like GRS_METRICS_CPU_THRESHOLD=50,
+and by a flag given before the command, like --metrics.cpu.threshold=50. Lists are comma separated. A flag
+overrides an environment variable, which overrides the config file, which overrides the defaults.
+
+Flags:
+`
+
 func main() {
-	file, err := os.ReadFile(CONFIG_FILE)
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), USAGE)
+		flag.PrintDefaults()
+	}
+
+	defaultConfigFile := CONFIG_FILE
+	if path := os.Getenv(CONFIG_FILE_ENV); path != "" {
+		defaultConfigFile = path
+	}
+
+	configFile := flag.String("config", defaultConfigFile, "path of the config file, or $"+CONFIG_FILE_ENV)
+	overrideFlags(flag.CommandLine)
+	flag.Parse()
+
+	configOverrides = append(EnvOverrides(), flagOverrides...)
+
+	command, args := "run", flag.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "validate":
+		os.Exit(validate(*configFile))
+	case "schema":
+		os.Exit(schema())
+	case "config":
+		os.Exit(printConfig(*configFile, args))
+	}
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		logging.Fatal("Failed to load the config file", err)
+	}
+
+	if err := logging.Setup(config); err != nil {
+		logging.Fatal("Failed to set up logging", err)
+	}
+
+	switch command {
+	case "run":
+		run(config, *configFile)
+	case "status":
+		status(config, args)
+	case "scale":
+		scale(config, args)
+	case "plan":
+		plan(config, args)
+	case "why":
+		why(config, args)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %s\n\n", command)
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// Reads and parses the config file at path, with the environment variables and flags applied over it
+func loadConfig(path string) (*Config, error) {
+	config, _, err := loadLayeredConfig(path)
+
+	return config, err
+}
+
+// Like loadConfig, also returns where the value of every field comes from
+func loadLayeredConfig(path string) (*Config, map[string]ConfigSource, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("In loadConfig: Failed to read %s -> %s", path, err))
+	}
+
+	err, config, sources := LayeredConfigParser(file, configOverrides)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return config, sources, nil
+}
+
+// Runs the application until SIGINT or SIGTERM. One Go routine runs the metric collector and other runs the auto scaler
+func run(config *Config, configFile string) {
+	slog.Debug("Config loaded", "component", "main", "config", redactedConfig(config))
+
+	if config.DryRun {
+		slog.Warn("Dry run: decisions are taken and recorded, but no replica is started or stopped and Nginx is not reconfigured", "component", "main")
+	}
+
+	// The root context is cancelled on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
+	events.Subscribe(func(e Event) {
+		slog.Warn(e.Message, "component", e.Source, "event", e.Type, "error", e.Error)
+	})
+
+	shutdownOpenTelemetry, err := telemetry.SetupOpenTelemetry(ctx, config)
 	if err != nil {
-		log.Fatalln("Main: Failed to read config file")
+		logging.Fatal("Failed to start", err)
 	}
 
-	err, config := ConfigParser(file)
+	telemetryServer, err := telemetry.Serve(config.Telemetry.Address)
+	if err != nil {
+		logging.Fatal("Failed to start", err)
+	}
 
+	apiClient, err := clients.NewDocker()
 	if err != nil {
-		log.Fatalln("Main: Failed to parse config file")
+		logging.Fatal("Failed to start", err)
 	}
+	defer apiClient.Close()
 
-	YAMLPrettyPrint(config)
+	// A probe that can't run would fail every replica, so it is refused before anything starts
+	if err := scaler.CheckProbes(config, apiClient, &ctx); err != nil {
+		logging.Fatal("Failed to start", err)
+	}
 
-	periodStr := config.Period[:len(config.Period)-1]
-	
-	period, err := strconv.Atoi(periodStr)
+	es, err := clients.NewElastic()
+	if err != nil {
+		logging.Fatal("Failed to start", err)
+	}
 
+	metricSinks, err := sinks.NewSinks(config, es)
 	if err != nil {
-		log.Fatalln("Error converting period string to integer")
-		return
+		logging.Fatal("Failed to start", err)
+	}
+
+	controller := control.NewController(config.Service)
+	controller.SetMinReplicas(config.MinReplicas)
+
+	controlAPI, err := control.NewAPI(config, controller)
+	if err != nil {
+		logging.Fatal("Failed to start", err)
 	}
 
-	interval := time.Duration(period) * time.Second
+	controlGRPC, err := control.NewGRPCServer(config, controller)
+	if err != nil {
+		logging.Fatal("Failed to start", err)
+	}
 
-	for {
-		var s sync.WaitGroup
-		s.Add(2)
+	if config.Grafana.Provision {
+		// Grafana may take longer to start than the application, the scaler doesn't wait for it
+		go provisionGrafana(config, &ctx)
+	}
 
-		c := make(chan []*Stats)
-		ctx := context.Background()
+	// Reads the liveness section at startup only, the replacements use the current config
+	liveness := scaler.NewLivenessMonitor(config, apiClient)
+	go liveness.Run(ctx)
 
-		go metric_collector.Run(&s, c, &ctx)
+	reloads := watchConfig(ctx, configFile)
 
-		stats := <-c
-		close(c)
+	// The config last read from the file, which the reloads are compared with
+	loaded := config
 
-		go scaler.Run(&s, config, stats, &ctx)
+	for ctx.Err() == nil {
+		start := time.Now()
+		runIteration(config, apiClient, metricSinks, controller, &ctx)
+		telemetry.ObserveIteration(time.Since(start))
 
-		s.Wait()
+		// A new config is swapped in between two iterations, the next wait uses its period
+		timer := time.NewTimer(config.Period)
 
-		for _, stat := range stats {
-			log.Println(stat)
-			// Convert stat to JSON
-			output, errParse := json.Marshal(stat)
-			if errParse != nil {
-				log.Fatalln("Failed to marshal data:", errParse)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				break wait
+			case <-timer.C:
+				break wait
+			case <-controller.Reconciles():
+				break wait
+			case <-reloads:
+				config, loaded = reloadConfig(config, loaded, configFile, apiClient, &ctx)
+				controller.SetMinReplicas(config.MinReplicas)
+			case failure := <-liveness.Failures():
+				// Like a scale action, a replacement that already started is not interrupted by a signal.
+				// A failed replacement was already reported as an event
+				replaceCtx := context.WithoutCancel(ctx)
+				scaler.Replace(config, failure, apiClient, &replaceCtx)
 			}
+		}
 
-			// Unmarshal JSON to map
-			var data map[string]interface{}
-			if err := json.Unmarshal(output, &data); err != nil {
-				log.Fatalln("Failed to unmarshal JSON:", err)
-			}
+		timer.Stop()
+	}
 
-			// Add timestamp
-			data["timestamp"] = time.Now().Format(time.RFC3339)
-			data["CPUUsage"], _ = strconv.ParseFloat(stat.CPUUsage[:len(stat.CPUUsage) - 1], 32)
-			data["MemoryUsage"], _ = strconv.ParseFloat(stat.MemoryUsage[:len(stat.MemoryUsage) - 1], 32)
+	// Restore the default behavior, so a second signal kills the application right away
+	stop()
 
-			// Marshal back to JSON
-			updatedOutput, err := json.MarshalIndent(data, "", "  ")
-			
-			if err != nil {
-				log.Fatalln("Failed to marshal updated data:", err)
-			}
+	shutdown(config, apiClient, metricSinks, controlAPI, controlGRPC, telemetryServer, shutdownOpenTelemetry)
+}
 
-			es, err := elasticsearch.NewDefaultClient()
-			if err != nil {
-				log.Fatalf("Error creating the Elastic client: %s", err)
-			}
+// Collects metrics, scales and indexes the stats. Failures are reported and the next iteration tries again
+func runIteration(config *Config, apiClient *clients.Docker, metricSinks []sinks.MetricSink, controller *control.Controller, ct *context.Context) {
+	iterationID := RandomID()
 
+	// Every iteration is one trace, and its logs carry the iteration ID
+	iterationCtx, span := telemetry.StartSpan(*ct, "iteration", attribute.String("service", config.Service), attribute.String("iteration.id", iterationID))
+	defer span.End()
 
-			req := esapi.IndexRequest{
-				Index:   "containers",
-				Body:    strings.NewReader(string(updatedOutput)),
-				Refresh: "true",
-			}
-		
-			res, err := req.Do(context.Background(), es)
-			if err != nil {
-				log.Fatalf("Error getting response: %s", err)
-			}
-		
-			if res.IsError() {
-				log.Printf("[%s] Error indexing document", res.Status())
-			} else {
-				log.Printf("[%s] Document indexed.", res.Status())
-			}
+	iterationCtx = logging.WithLogger(iterationCtx, logging.FromContext(iterationCtx).With("service", config.Service, "iteration", iterationID))
+
+	ctx := &iterationCtx
 
-			res.Body.Close()
+	var s sync.WaitGroup
+	s.Add(1)
 
+	c := make(chan []*Stats, 1)
+	dc := make(chan *Decision, 1)
+	errc := make(chan error, 1)
+
+	go metric_collector.Run(&s, c, errc, apiClient, ctx)
+
+	var stats []*Stats
+
+	select {
+	case stats = <-c:
+		telemetry.SetLastCollection(time.Now())
+	case err := <-errc:
+		events.Failure("main", events.COLLECTION_FAILED, "Skipping this iteration", err)
+		telemetry.EndSpan(span, err)
+		return
+	case <-(*ctx).Done():
+		return
+	}
+
+	lbStats, err := metric_collector.CollectLoadBalancer(apiClient, ctx)
+	if err != nil {
+		events.Failure("main", events.COLLECTION_FAILED, "Skipping the load balancer traffic", err)
+	}
+
+	// A scale action that already started is not interrupted by a signal, so it can finish or roll back
+	actionCtx := context.WithoutCancel(*ctx)
+
+	paused, override := controller.Mode(config.Service, time.Now())
+
+	switch {
+	case paused:
+		logging.Component(*ctx, "main").Info("Autoscaling is paused, not scaling")
+	case override != nil:
+		s.Add(1)
+		go scaler.RunManual(&s, dc, errc, config, stats, override.Replicas, overrideReason(override), apiClient, &actionCtx)
+	default:
+		s.Add(1)
+		go scaler.Run(&s, dc, errc, config, stats, apiClient, &actionCtx)
+	}
+
+	// Every failure sent on errc by the scaler was already reported as an event
+	s.Wait()
+
+	var decision *Decision
+
+	select {
+	case decision = <-dc:
+		telemetry.SetReplicas(config.Service, decision.CurrentReplicas, decision.DesiredReplicas)
+	default:
+	}
+
+	controller.Record(config.Service, stats, decision, time.Now())
+
+	writeToSinks(metricSinks, stats, lbStats, decision, &actionCtx)
+}
+
+// Explains a decision taken because of a manual override
+func overrideReason(override *control.Override) string {
+	if override.Expires == nil {
+		return fmt.Sprintf("manual override to %d replicas, until autoscaling is resumed", override.Replicas)
+	}
+
+	return fmt.Sprintf("manual override to %d replicas, until %s", override.Replicas, override.Expires.Format(time.RFC3339))
+}
+
+// Sends the stats and the decision of one iteration to every sink. A failing sink doesn't stop the others
+func writeToSinks(metricSinks []sinks.MetricSink, stats []*Stats, lbStats *LoadBalancerStats, decision *Decision, ct *context.Context) {
+	now := time.Now()
+
+	indexCtx, span := telemetry.StartSpan(*ct, "index", attribute.Int("stats.samples", len(stats)))
+	defer span.End()
+
+	for _, sink := range metricSinks {
+		sinkCtx, sinkSpan := telemetry.StartSpan(indexCtx, "index."+sink.Name())
+		errs := writeToSink(sinkCtx, sink, stats, lbStats, decision, now)
+		telemetry.EndSpan(sinkSpan, errs)
+	}
+}
+
+// Reports every failed write as an event and returns them joined
+func writeToSink(ctx context.Context, sink sinks.MetricSink, stats []*Stats, lbStats *LoadBalancerStats, decision *Decision, now time.Time) error {
+	var errs []error
+
+	if err := sink.WriteStats(ctx, stats, now); err != nil {
+		events.Failure(sink.Name(), events.INDEX_FAILED, fmt.Sprintf("Failed to write %d stats", len(stats)), err)
+		errs = append(errs, err)
+	}
+
+	if lbWriter, ok := sink.(sinks.LoadBalancerWriter); ok && lbStats != nil {
+		if err := lbWriter.WriteLoadBalancer(ctx, lbStats, now); err != nil {
+			events.Failure(sink.Name(), events.INDEX_FAILED, "Failed to write the load balancer traffic", err)
+			errs = append(errs, err)
+		}
+	}
+
+	if decision != nil {
+		if err := sink.WriteDecision(ctx, decision); err != nil {
+			events.Failure(sink.Name(), events.INDEX_FAILED, "Failed to write decision", err)
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Creates or updates the Grafana datasources and dashboards
+func provisionGrafana(config *Config, ctx *context.Context) {
+	client := grafana.NewClient(config)
+
+	for attempt := 1; attempt <= GRAFANA_PROVISION_ATTEMPTS; attempt++ {
+		err := grafana.Provision(*ctx, client, config)
+		if err == nil {
+			return
 		}
 
-		time.Sleep(interval)
+		events.Failure("grafana", events.PROVISION_FAILED, fmt.Sprintf("Failed to provision Grafana (attempt %d of %d)", attempt, GRAFANA_PROVISION_ATTEMPTS), err)
+
+		select {
+		case <-(*ctx).Done():
+			return
+		case <-time.After(GRAFANA_PROVISION_RETRY):
+		}
 	}
 }
+
+// Flushes the sinks and runs the configured on_shutdown behavior
+func shutdown(config *Config, apiClient *clients.Docker, metricSinks []sinks.MetricSink, controlAPI *control.API, controlGRPC *control.GRPCServer, telemetryServer *http.Server, shutdownOpenTelemetry func(context.Context) error) {
+	logger := slog.Default().With("component", "main")
+	logger.Info("Shutting down")
+
+	// Every step has a timeout of its own, so a slow one doesn't leave the next ones without time
+	step := func(timeout time.Duration, run func(ctx context.Context)) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		run(ctx)
+	}
+
+	step(CONTROL_SHUTDOWN_TIMEOUT, func(ctx context.Context) {
+		if err := controlAPI.Close(ctx); err != nil {
+			logger.Error("Failed to stop the control API", "error", err)
+		}
+	})
+
+	step(CONTROL_SHUTDOWN_TIMEOUT, func(ctx context.Context) {
+		if err := controlGRPC.Close(ctx); err != nil {
+			logger.Error("Failed to stop the gRPC control plane", "error", err)
+		}
+	})
+
+	for _, sink := range metricSinks {
+		step(SINK_SHUTDOWN_TIMEOUT, func(ctx context.Context) {
+			if err := sink.Close(ctx); err != nil {
+				logger.Error("Failed to close sink", "sink", sink.Name(), "error", err)
+			}
+		})
+	}
+
+	if config.OnShutdown == ON_SHUTDOWN_SCALE_TO_MIN && config.DryRun {
+		logger.Info("Dry run, not scaling to min_replicas on shutdown", "min_replicas", config.MinReplicas)
+	} else if config.OnShutdown == ON_SHUTDOWN_SCALE_TO_MIN {
+		step(SHUTDOWN_TIMEOUT, func(ctx context.Context) {
+			if err := scaler.ScaleToMin(config, apiClient, &ctx); err != nil {
+				events.Failure("main", events.SHUTDOWN_FAILED, fmt.Sprintf("Failed to scale to %d replicas on shutdown", config.MinReplicas), err)
+			}
+		})
+	}
+
+	// Stopped last, so the metrics can be scraped until the application is done
+	step(TELEMETRY_SHUTDOWN_TIMEOUT, func(ctx context.Context) {
+		if err := telemetryServer.Shutdown(ctx); err != nil {
+			logger.Error("Failed to stop the telemetry server", "error", err)
+		}
+	})
+
+	step(TELEMETRY_SHUTDOWN_TIMEOUT, func(ctx context.Context) {
+		if err := shutdownOpenTelemetry(ctx); err != nil {
+			logger.Error("Failed to flush the traces and metrics", "error", err)
+		}
+	})
+}
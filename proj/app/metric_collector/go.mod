@@ -2,7 +2,10 @@ module grs/metric-collector
 
 go 1.22.2
 
-require github.com/docker/docker v26.0.1+incompatible
+require (
+	github.com/docker/docker v26.0.1+incompatible
+	go.opentelemetry.io/otel v1.25.0
+)
 
 require (
 	github.com/Microsoft/go-winio v0.4.14 // indirect
@@ -21,7 +24,6 @@ require (
 	github.com/opencontainers/image-spec v1.1.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.50.0 // indirect
-	go.opentelemetry.io/otel v1.25.0 // indirect
 	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.25.0 // indirect
 	go.opentelemetry.io/otel/metric v1.25.0 // indirect
 	go.opentelemetry.io/otel/sdk v1.25.0 // indirect
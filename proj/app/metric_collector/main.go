@@ -8,48 +8,79 @@ import (
 	"strings"
 	"sync"
 
-	"github.com/docker/docker/client"
+	"go.opentelemetry.io/otel/attribute"
 
+	clients "grs/common/clients"
+	events "grs/common/events"
+	logging "grs/common/logging"
+	telemetry "grs/common/telemetry"
 	. "grs/common/types"
 	utils "grs/common/utils"
 )
 
-// Collects metrics from running containers and sends them to the Scaler through a channel
-func Run(s *sync.WaitGroup, c chan []*Stats, ct *context.Context) error {
-	// TODO: wrap this client
-	apiClient, err := client.NewClientWithOpts(client.WithAPIVersionNegotiation())
-	if err != nil {
-		return errors.New(fmt.Sprintf("In metric_collector.Run: Failed to create client -> %s", err))
-	}
+// Collects metrics from running containers and sends them to the Scaler through a channel.
+// If the containers can't be listed, the error is sent through errc instead
+func Run(s *sync.WaitGroup, c chan []*Stats, errc chan error, apiClient *clients.Docker, ct *context.Context) {
+	defer s.Done()
 
-	defer apiClient.Close()
+	ctx, span := telemetry.StartSpan(*ct, "collect")
+	var err error
+	defer func() { telemetry.EndSpan(span, err) }()
 
-	ctx, cancel := context.WithCancel(*ct)
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	containers, err := utils.GetContainersOnNetwork(utils.GRS_NETWORK, apiClient, &ctx)
 	if err != nil {
-		cancel()
-		return errors.New(fmt.Sprintf("In metric_collector.Run: Failed to get containers -> %s", err))
+		err = errors.New(fmt.Sprintf("In metric_collector.Run: Failed to get containers -> %s", err))
+		errc <- err
+		return
 	}
 
 	var allMetrics []*Stats
+	var names []string
 
 	for _, ctr := range *containers {
-		cStats, _ := utils.GetContainerStats(ctr.Name, apiClient, &ctx)
-
 		if strings.Compare(ctr.Name, utils.GRS_LOAD_BALANCER) == 0 {
 			continue
 		}
-		
-		fmt.Printf("Container %s\n", ctr.Name)
-		utils.PrettyPrint(cStats)
+
+		ctrCtx, ctrSpan := telemetry.StartSpan(ctx, "collect.container", attribute.String("container.name", ctr.Name))
+		cStats, err := utils.GetContainerStats(ctr.Name, apiClient, &ctrCtx)
+		telemetry.EndSpan(ctrSpan, err)
+
+		if err != nil {
+			// One replica we can't read shouldn't prevent scaling on the others
+			events.Failure("metric_collector", events.COLLECTION_FAILED, fmt.Sprintf("Skipping container %s", ctr.Name), err)
+			continue
+		}
+
+		logging.Component(ctx, "metric_collector").Debug("Collected stats", "container", ctr.Name,
+			"container_id", cStats.ContainerID, "image", cStats.Image, "cpu_usage", cStats.CPUUsage, "memory_usage", cStats.MemoryUsage,
+			"used_memory", cStats.UsedMemory, "interval_ms", cStats.IntervalMs)
 		allMetrics = append(allMetrics, cStats)
+		names = append(names, ctr.Name)
 	}
 
+	span.SetAttributes(attribute.StringSlice("container.names", names))
+
 	c <- allMetrics
+}
 
-	s.Done()
+// Collects the network traffic of the load balancer
+func CollectLoadBalancer(apiClient *clients.Docker, ct *context.Context) (*LoadBalancerStats, error) {
+	ctx, span := telemetry.StartSpan(*ct, "collect.load_balancer", attribute.String("container.name", utils.GRS_LOAD_BALANCER))
+	var err error
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stats, err := utils.GetLoadBalancerStats(apiClient, &ctx)
+	if err != nil {
+		err = errors.New(fmt.Sprintf("In metric_collector.CollectLoadBalancer: Failed to get load balancer stats -> %s", err))
+		return nil, err
+	}
 
-	return nil
+	return stats, nil
 }
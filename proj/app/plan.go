@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	clients "grs/common/clients"
+	logging "grs/common/logging"
+	. "grs/common/types"
+	. "grs/common/utils"
+	metric_collector "grs/metric-collector"
+	scaler "grs/scaler"
+)
+
+// Collects the stats once and prints the decision the scaler would take and the Nginx config diff
+// it would apply, without starting or stopping anything.
+// Usage: go run . plan
+func plan(config *Config, args []string) {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	flags.Parse(args)
+
+	apiClient, err := clients.NewDocker()
+	if err != nil {
+		logging.Fatal("Failed to create Docker client", err)
+	}
+	defer apiClient.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var s sync.WaitGroup
+	s.Add(1)
+
+	c := make(chan []*Stats, 1)
+	errc := make(chan error, 1)
+
+	go metric_collector.Run(&s, c, errc, apiClient, &ctx)
+
+	var stats []*Stats
+
+	select {
+	case stats = <-c:
+	case err := <-errc:
+		logging.Fatal("Failed to collect the stats", err)
+	}
+
+	p, err := scaler.PlanScale(config, stats, apiClient, &ctx)
+	if err != nil {
+		logging.Fatal("Failed to plan", err)
+	}
+
+	for i, stat := range stats {
+		fmt.Printf("replica %d (%s): CPU %.3f%%, memory %.3f%%\n", i+1, stat.ContainerName, stat.CPUUsage, stat.MemoryUsage)
+	}
+
+	d := p.Decision
+	fmt.Printf("\n%s: %d -> %d replicas, policy %s\n", d.Action, d.CurrentReplicas, d.DesiredReplicas, d.Policy)
+	fmt.Printf("    because %s\n", d.Reason)
+
+	switch {
+	case d.Action == ACTION_SCALE_UP && p.Nginx != nil:
+		fmt.Printf("    would start a replica from image %s\n", GRS_IMAGE)
+	case d.Action == ACTION_SCALE_DOWN && len(d.Containers) > 0:
+		fmt.Printf("    would stop %s\n", strings.Join(d.Containers, ", "))
+	}
+
+	if p.Nginx == nil {
+		fmt.Println("\nThe Nginx config would not change")
+		return
+	}
+
+	fmt.Println("\nNginx config diff:")
+	fmt.Print(lineDiff(p.Nginx.Old, p.Nginx.New))
+}
+
+// Returns the lines of before and after, the removed ones prefixed with "-", the added ones with "+"
+// and the others with a space
+func lineDiff(before string, after string) string {
+	a := strings.Split(strings.TrimSpace(before), "\n")
+	b := strings.Split(strings.TrimSpace(after), "\n")
+
+	// Longest common subsequence of the trimmed lines, so a change of indentation is not a change
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if strings.TrimSpace(a[i]) == strings.TrimSpace(b[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var diff strings.Builder
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && strings.TrimSpace(a[i]) == strings.TrimSpace(b[j]):
+			fmt.Fprintf(&diff, "  %s\n", b[j])
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			fmt.Fprintf(&diff, "+ %s\n", b[j])
+			j++
+		default:
+			fmt.Fprintf(&diff, "- %s\n", a[i])
+			i++
+		}
+	}
+
+	return diff.String()
+}
@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestLineDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		diff   string
+	}{
+		{
+			name:   "unchanged",
+			before: "a\nb\n",
+			after:  "a\nb\n",
+			diff:   "  a\n  b\n",
+		},
+		{
+			name:   "line added",
+			before: "upstream {\n    server a:80;\n}",
+			after:  "upstream {\n    server a:80;\n    server b:80;\n}",
+			diff:   "  upstream {\n      server a:80;\n+     server b:80;\n  }\n",
+		},
+		{
+			name:   "line removed",
+			before: "upstream {\n    server a:80;\n    server b:80;\n}",
+			after:  "upstream {\n    server b:80;\n}",
+			diff:   "  upstream {\n-     server a:80;\n      server b:80;\n  }\n",
+		},
+		{
+			name:   "line replaced",
+			before: "a\nb\nc",
+			after:  "a\nx\nc",
+			diff:   "  a\n+ x\n- b\n  c\n",
+		},
+		{
+			name:   "indentation is not a change",
+			before: "upstream {\nserver a:80;\n}",
+			after:  "upstream {\n    server a:80;\n}",
+			diff:   "  upstream {\n      server a:80;\n  }\n",
+		},
+		{
+			name:   "surrounding blank lines ignored",
+			before: "\n\na\n",
+			after:  "a\n\n",
+			diff:   "  a\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := lineDiff(test.before, test.after); got != test.diff {
+				t.Errorf("lineDiff() =\n%s\nwant\n%s", got, test.diff)
+			}
+		})
+	}
+}
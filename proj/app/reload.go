@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+
+	clients "grs/common/clients"
+	events "grs/common/events"
+	logging "grs/common/logging"
+	. "grs/common/types"
+	. "grs/common/utils"
+	scaler "grs/scaler"
+)
+
+// Settings applied by a reload, see reloadConfig. The others are only read at startup, so a change is logged but needs a restart
+var HOT_RELOAD_FIELDS = []string{"period", "metrics", "min_replicas", "dry_run", "on_shutdown", "victim_selection", "drain", "readiness", "logging.level"}
+
+// Settings whose values are not logged
+var SECRET_FIELDS = []string{"password", "token"}
+
+// Sends a value on the returned channel when the config file at path changes or the process receives
+// SIGHUP, until ctx is done. Changes are found by polling the content of the file, which also works
+// when an editor replaces it or a ConfigMap is updated through a symlink
+func watchConfig(ctx context.Context, path string) <-chan struct{} {
+	reloads := make(chan struct{}, 1)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	notify := func() {
+		select {
+		case reloads <- struct{}{}:
+		default:
+		}
+	}
+
+	go func() {
+		defer signal.Stop(hup)
+
+		last, _ := os.ReadFile(path)
+
+		ticker := time.NewTicker(CONFIG_WATCH_INTERVAL)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				slog.Info("Received SIGHUP, reloading the config", "component", "main")
+				notify()
+			case <-ticker.C:
+				current, err := os.ReadFile(path)
+				if err != nil || bytes.Equal(current, last) {
+					continue
+				}
+
+				last = current
+				slog.Info("Config file changed, reloading it", "component", "main", "path", path)
+				notify()
+			}
+		}
+	}()
+
+	return reloads
+}
+
+// Reads and checks the config file at path, and logs what changed since loaded, the config last read
+// from it. Returns the current config with the settings of HOT_RELOAD_FIELDS taken from the file and
+// the config read, or current and loaded if the file is invalid: the error is then reported and nothing
+// changes. The same goes for a readiness check that can't run with the grs image. A change that needs a
+// restart is compared with loaded, so it is only logged once
+func reloadConfig(current *Config, loaded *Config, path string, apiClient *clients.Docker, ctx *context.Context) (*Config, *Config) {
+	config, err := loadConfig(path)
+	if err == nil {
+		err = errors.Join(configProblems(config)...)
+	}
+
+	if err != nil {
+		events.Failure("main", events.CONFIG_RELOAD_FAILED, "Keeping the current config, the new one is invalid", err)
+		return current, loaded
+	}
+
+	changes := configDiff(loaded, config)
+	if len(changes) == 0 {
+		slog.Info("Config reloaded, nothing changed", "component", "main")
+		return current, config
+	}
+
+	// The fields that need a restart keep their current value, so the config matches what is running
+	applied := *current
+	applied.Period = config.Period
+	applied.Metrics = config.Metrics
+	applied.MinReplicas = config.MinReplicas
+	applied.DryRun = config.DryRun
+	applied.OnShutdown = config.OnShutdown
+	applied.VictimSelection = config.VictimSelection
+	applied.Drain = config.Drain
+	applied.Readiness = config.Readiness
+	applied.Logging.Level = config.Logging.Level
+
+	// Like at startup, a probe that can't run would fail or skip the check of every new replica
+	if err := scaler.CheckProbes(&applied, apiClient, ctx); err != nil {
+		events.Failure("main", events.CONFIG_RELOAD_FAILED, "Keeping the current config, its probes can't run", err)
+		return current, loaded
+	}
+
+	logger := slog.Default().With("component", "main")
+
+	for _, change := range changes {
+		if isHotReloadable(change.field) {
+			logger.Info("Config changed", "field", change.field, "old", change.old, "new", change.new)
+		} else {
+			logger.Warn("Config changed, restart to apply it", "field", change.field, "old", change.old, "new", change.new)
+		}
+	}
+
+	if config.Logging.Level != current.Logging.Level {
+		if err := logging.SetLevel(config.Logging.Level); err != nil {
+			logger.Error("Failed to change the log level", "error", err)
+		}
+	}
+
+	return &applied, config
+}
+
+// A field that differs between two configs, by its YAML path
+type configChange struct {
+	field string
+	old   string
+	new   string
+}
+
+// Returns the fields that differ between before and after
+func configDiff(before *Config, after *Config) []configChange {
+	var changes []configChange
+	diffValues("", reflect.ValueOf(*before), reflect.ValueOf(*after), &changes)
+
+	return changes
+}
+
+func diffValues(path string, before reflect.Value, after reflect.Value, changes *[]configChange) {
+	if before.Kind() == reflect.Struct {
+		for i := 0; i < before.NumField(); i++ {
+			name := strings.Split(before.Type().Field(i).Tag.Get("yaml"), ",")[0]
+			if path != "" {
+				name = path + "." + name
+			}
+
+			diffValues(name, before.Field(i), after.Field(i), changes)
+		}
+
+		return
+	}
+
+	if reflect.DeepEqual(before.Interface(), after.Interface()) {
+		return
+	}
+
+	change := configChange{field: path, old: fmt.Sprint(before.Interface()), new: fmt.Sprint(after.Interface())}
+
+	if isSecret(path) {
+		change.old, change.new = "(hidden)", "(hidden)"
+	}
+
+	*changes = append(*changes, change)
+}
+
+func isHotReloadable(field string) bool {
+	for _, hot := range HOT_RELOAD_FIELDS {
+		if field == hot || strings.HasPrefix(field, hot+".") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	. "grs/common/types"
+	. "grs/common/utils"
+)
+
+func parseConfig(t *testing.T, yaml string) *Config {
+	t.Helper()
+
+	err, config := ConfigParser([]byte(yaml))
+	if err != nil {
+		t.Fatalf("ConfigParser() error = %v", err)
+	}
+
+	return config
+}
+
+func TestConfigDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		before  string
+		after   string
+		changes []configChange
+	}{
+		{
+			name:   "nothing changed",
+			before: "period: 5s\n",
+			after:  "period: 5s\n",
+		},
+		{
+			name:    "nested field",
+			before:  "metrics:\n  cpu:\n    threshold: 50\n",
+			after:   "metrics:\n  cpu:\n    threshold: 70\n",
+			changes: []configChange{{field: "metrics.cpu.threshold", old: "50", new: "70"}},
+		},
+		{
+			name:    "default made explicit with another value",
+			before:  "",
+			after:   "period: 10s\n",
+			changes: []configChange{{field: "period", old: "5s", new: "10s"}},
+		},
+		{
+			name:   "several fields, in the order of Config",
+			before: "min_replicas: 1\nperiod: 5s\n",
+			after:  "min_replicas: 2\nperiod: 6s\n",
+			changes: []configChange{
+				{field: "period", old: "5s", new: "6s"},
+				{field: "min_replicas", old: "1", new: "2"},
+			},
+		},
+		{
+			name:    "secret hidden",
+			before:  "grafana:\n  password: old\n",
+			after:   "grafana:\n  password: new\n",
+			changes: []configChange{{field: "grafana.password", old: "(hidden)", new: "(hidden)"}},
+		},
+		{
+			name:    "token hidden",
+			before:  "influxdb:\n  url: http://influxdb:8086\n  token: a\n",
+			after:   "influxdb:\n  url: http://influxdb:8086\n  token: b\n",
+			changes: []configChange{{field: "influxdb.token", old: "(hidden)", new: "(hidden)"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes := configDiff(parseConfig(t, test.before), parseConfig(t, test.after))
+
+			if !reflect.DeepEqual(changes, test.changes) {
+				t.Errorf("configDiff() = %+v, want %+v", changes, test.changes)
+			}
+		})
+	}
+}
+
+func TestIsHotReloadable(t *testing.T) {
+	tests := []struct {
+		field string
+		hot   bool
+	}{
+		{"period", true},
+		{"metrics.cpu.threshold", true},
+		{"victim_selection.weights.age", true},
+		{"readiness.check", true},
+		{"logging.level", true},
+		{"logging.format", false},
+		{"liveness.check", false},
+		{"service", false},
+		{"sinks", false},
+		// A prefix of a hot field name, not a section of it
+		{"periodic", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.field, func(t *testing.T) {
+			if got := isHotReloadable(test.field); got != test.hot {
+				t.Errorf("isHotReloadable(%q) = %v, want %v", test.field, got, test.hot)
+			}
+		})
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	const initial = "period: 5s\nservice: web\nmetrics:\n  cpu:\n    threshold: 50\n"
+
+	tests := []struct {
+		name string
+		file string
+		// Whether the config in use and the one last loaded are replaced
+		applied bool
+		loaded  bool
+		period  time.Duration
+		service string
+	}{
+		{
+			name:    "hot field applied",
+			file:    "period: 10s\nservice: web\nmetrics:\n  cpu:\n    threshold: 50\n",
+			applied: true,
+			loaded:  true,
+			period:  10 * time.Second,
+			service: "web",
+		},
+		{
+			name:    "restart field only recorded",
+			file:    "period: 10s\nservice: api\nmetrics:\n  cpu:\n    threshold: 50\n",
+			applied: true,
+			loaded:  true,
+			period:  10 * time.Second,
+			service: "web",
+		},
+		{
+			name:    "nothing changed",
+			file:    initial,
+			loaded:  true,
+			period:  5 * time.Second,
+			service: "web",
+		},
+		{
+			name:    "invalid file kept out",
+			file:    "period: 10s\nmetrics:\n  cpu:\n    threshold: -1\n",
+			period:  5 * time.Second,
+			service: "web",
+		},
+		{
+			name:    "unknown field kept out",
+			file:    "period: 10s\nperiods: 1m\n",
+			period:  5 * time.Second,
+			service: "web",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(test.file), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			current := parseConfig(t, initial)
+			loaded := parseConfig(t, initial)
+			ctx := context.Background()
+
+			// No probe is docker, so the Docker client is never used
+			config, newLoaded := reloadConfig(current, loaded, path, nil, &ctx)
+
+			if (config != current) != test.applied {
+				t.Errorf("config replaced = %v, want %v", config != current, test.applied)
+			}
+
+			if (newLoaded != loaded) != test.loaded {
+				t.Errorf("loaded replaced = %v, want %v", newLoaded != loaded, test.loaded)
+			}
+
+			if config.Period != test.period {
+				t.Errorf("period = %s, want %s", config.Period, test.period)
+			}
+
+			if config.Service != test.service {
+				t.Errorf("service = %q, want %q", config.Service, test.service)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package scaler
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	events "grs/common/events"
+	. "grs/common/types"
+	utils "grs/common/utils"
+)
+
+// Returned by startContainer when the new container was removed because it couldn't be added to the load balancer
+var ErrRolledBack = errors.New("container rolled back")
+
+// Records on decision that the replicas couldn't be listed, so nothing was done, and reports it as an event
+func listFailed(decision *Decision, err error) {
+	decision.Action = utils.ACTION_NONE
+	decision.Reason = fmt.Sprintf("%s, but the replicas couldn't be listed", decision.Reason)
+	setOutcome(decision, "", err)
+
+	events.Failure("scaler", events.COLLECTION_FAILED, "Failed to list the replicas, not scaling", err)
+}
+
+// Creates the decision of one evaluation with its inputs filled in. Until the scaler finds a
+// replica over or under the thresholds, the decision is to do nothing
+func newDecision(config *Config, stats []*Stats, runningReplicas int, timestamp time.Time) *Decision {
+	decision := &Decision{
+		ID:              utils.RandomID(),
+		Timestamp:       timestamp,
+		Policy:          utils.POLICY_PROPORTIONAL,
+		Action:          utils.ACTION_NONE,
+		Outcome:         utils.OUTCOME_NOOP,
+		CurrentReplicas: runningReplicas,
+		DesiredReplicas: runningReplicas,
+		Reason:          fmt.Sprintf("all %d replicas are within the thresholds", len(stats)),
+		Inputs: DecisionInputs{
+			MinReplicas: config.MinReplicas,
+		},
+	}
+
+	decision.Inputs.MemoryThreshold = config.Metrics.Memory.Threshold
+	decision.Inputs.CPUThreshold = config.Metrics.CPU.Threshold
+
+	var cpuSum, memSum float64
+
+	for _, stat := range stats {
+		cpuUsage := stat.CPUUsage
+		memUsage := stat.MemoryUsage
+
+		decision.Inputs.Samples++
+		cpuSum += cpuUsage
+		memSum += memUsage
+		decision.Inputs.MaxCPUUsage = max(decision.Inputs.MaxCPUUsage, cpuUsage)
+		decision.Inputs.MaxMemoryUsage = max(decision.Inputs.MaxMemoryUsage, memUsage)
+	}
+
+	if decision.Inputs.Samples > 0 {
+		decision.Inputs.AvgCPUUsage = cpuSum / float64(decision.Inputs.Samples)
+		decision.Inputs.AvgMemoryUsage = memSum / float64(decision.Inputs.Samples)
+	}
+
+	return decision
+}
+
+// Records the result of the scale action on the decision
+func setOutcome(decision *Decision, containerID string, err error) {
+	if containerID != "" {
+		decision.Containers = append(decision.Containers, containerID)
+	}
+
+	switch {
+	case errors.Is(err, ErrRolledBack):
+		decision.Outcome = utils.OUTCOME_ROLLED_BACK
+	case err != nil:
+		decision.Outcome = utils.OUTCOME_FAILED
+	case containerID == "":
+		// Nothing had to be done, like a scale down when only min_replicas are running
+		decision.Outcome = utils.OUTCOME_NOOP
+	default:
+		decision.Outcome = utils.OUTCOME_SUCCESS
+	}
+
+	if err != nil {
+		decision.Error = err.Error()
+	}
+}
@@ -0,0 +1,107 @@
+package scaler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"go.opentelemetry.io/otel/attribute"
+
+	clients "grs/common/clients"
+	logging "grs/common/logging"
+	telemetry "grs/common/telemetry"
+	. "grs/common/types"
+	utils "grs/common/utils"
+)
+
+// Takes the replica with name and containerID out of the load balancer, waits until the requests it is serving
+// are done or config.Drain.Timeout passes, then stops it with SIGTERM and config.Drain.GracePeriod and removes it.
+// The replica is stopped and removed even if ctx is done during the wait, the error is returned then.
+// address is the IP address of the replica on the GRS network, the requests are counted by it
+func drainContainer(config *Config, name string, containerID string, address string, cl *clients.Docker, ctx *context.Context) error {
+	logger := logging.Component(*ctx, "scaler")
+
+	removeErr := utils.RemoveServer(name, cl, ctx)
+	if removeErr != nil {
+		return errors.New(fmt.Sprintf("In drainContainer: Failed to remove server -> %s", removeErr.Error()))
+	}
+
+	started := time.Now()
+
+	drainCtx, span := telemetry.StartSpan(*ctx, "act.drain", attribute.String("container.name", name), attribute.String("container.address", address))
+	left, err := waitForDrain(address, config.Drain.Timeout, cl, &drainCtx)
+	span.SetAttributes(attribute.Int("drain.connections_left", left))
+	telemetry.EndSpan(span, err)
+
+	switch {
+	case err != nil:
+		logger.Warn("Drain interrupted, stopping the replica anyway", "container", name, "connections", left, "error", err)
+	case left == 0:
+		logger.Info("Replica drained", "container", name, "duration", time.Since(started).String())
+	default:
+		// -1 when the connections couldn't be read
+		logger.Warn("Drain timeout reached, stopping the replica anyway", "container", name, "connections", left, "timeout", config.Drain.Timeout.String())
+	}
+
+	// Docker takes whole seconds, a part of a second is rounded up so the replica never gets less than the grace period
+	gracePeriod := int(math.Ceil(config.Drain.GracePeriod.Seconds()))
+
+	// The replica is out of the load balancer already, so it is stopped even if ctx is done, not left running
+	stopCtx := context.WithoutCancel(*ctx)
+
+	if stopErr := removeContainer(containerID, container.StopOptions{Signal: utils.DRAIN_STOP_SIGNAL, Timeout: &gracePeriod}, cl, &stopCtx); stopErr != nil {
+		return errors.New(fmt.Sprintf("In drainContainer: Failed to stop replica %s -> %s", name, stopErr))
+	}
+
+	return err
+}
+
+// Waits until the load balancer has no connection open to address, or timeout passes. Returns the connections
+// left, -1 if they couldn't be read. Reading them can fail while Nginx reloads, so a failure is retried
+func waitForDrain(address string, timeout time.Duration, cl *clients.Docker, ctx *context.Context) (int, error) {
+	logger := logging.Component(*ctx, "scaler")
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	left := -1
+
+	for {
+		connections, err := utils.GetActiveConnections(cl, ctx)
+		if err != nil {
+			logger.Warn("Failed to read the connections of a draining replica", "address", address, "error", err)
+			left = -1
+		} else {
+			left = connections[address]
+		}
+
+		if left == 0 {
+			return 0, nil
+		}
+
+		logger.Debug("Waiting for the replica to drain", "address", address, "connections", left)
+
+		select {
+		case <-deadline.C:
+			return left, nil
+		case <-(*ctx).Done():
+			return left, errors.New(fmt.Sprintf("In waitForDrain: Gave up draining %s -> %s", address, (*ctx).Err()))
+		case <-time.After(utils.DRAIN_POLL_INTERVAL):
+		}
+	}
+}
+
+// Returns the IP address of the container with name among grsContainers, or an empty string if it isn't there
+func victimAddress(grsContainers *map[string]types.EndpointResource, name string) string {
+	for _, ctr := range *grsContainers {
+		if ctr.Name == name {
+			return utils.EndpointIP(ctr)
+		}
+	}
+
+	return ""
+}
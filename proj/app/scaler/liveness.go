@@ -0,0 +1,281 @@
+package scaler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	dockerevents "github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/errdefs"
+	"go.opentelemetry.io/otel/attribute"
+
+	clients "grs/common/clients"
+	events "grs/common/events"
+	logging "grs/common/logging"
+	telemetry "grs/common/telemetry"
+	. "grs/common/types"
+	utils "grs/common/utils"
+)
+
+// Failures waiting to be replaced. The monitor blocks when it is full
+const FAILURES_BUFFER int = 16
+
+// A replica the liveness monitor found failing, to be replaced
+type ReplicaFailure struct {
+	ContainerID string
+	Name        string
+	// utils.REPLACE_LIVENESS, utils.REPLACE_DIE or utils.REPLACE_OOM
+	Reason string
+	Error  string
+}
+
+// Checks the replicas in the load balancer with the liveness probe of the config and watches the Docker events
+// for the ones that exit or run out of memory. The failing replicas are sent through Failures, to be replaced
+// with Replace between two iterations, so a replacement never runs along with a scale action
+type LivenessMonitor struct {
+	config   *Config
+	cl       *clients.Docker
+	failures chan *ReplicaFailure
+}
+
+func NewLivenessMonitor(config *Config, cl *clients.Docker) *LivenessMonitor {
+	return &LivenessMonitor{config: config, cl: cl, failures: make(chan *ReplicaFailure, FAILURES_BUFFER)}
+}
+
+func (m *LivenessMonitor) Failures() <-chan *ReplicaFailure {
+	return m.failures
+}
+
+// Probes the replicas and watches the Docker events until ctx is done
+func (m *LivenessMonitor) Run(ctx context.Context) {
+	go m.watchEvents(ctx)
+
+	if m.config.Liveness.Check == utils.PROBE_NONE {
+		<-ctx.Done()
+		return
+	}
+
+	p := livenessProbe(m.config)
+
+	// Checks failed in a row, by container ID
+	failed := map[string]int{}
+
+	ticker := time.NewTicker(m.config.Liveness.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		m.probeReplicas(ctx, p, failed)
+	}
+}
+
+// Checks every replica in the load balancer once with p, and reports the ones that failed
+// liveness.failure_threshold checks in a row
+func (m *LivenessMonitor) probeReplicas(ctx context.Context, p *probe, failed map[string]int) {
+	logger := logging.Component(ctx, "liveness")
+
+	containers, err := utils.GetContainersOnNetwork(utils.GRS_NETWORK, m.cl, &ctx)
+	if err != nil {
+		logger.Warn("Failed to list the replicas to check", "error", err)
+		return
+	}
+
+	// The replicas that aren't in the load balancer, like one that is still starting or draining, aren't checked
+	upstream, err := utils.GetUpstreamServers()
+	if err != nil {
+		logger.Warn("Failed to read the replicas in the load balancer", "error", err)
+		return
+	}
+
+	checked := map[string]bool{}
+
+	for containerID, ctr := range *containers {
+		if !contains(upstream, ctr.Name) {
+			continue
+		}
+
+		checked[containerID] = true
+
+		err := p.run(containerID, utils.EndpointIP(ctr), m.cl, &ctx)
+		if err == nil {
+			failed[containerID] = 0
+			continue
+		}
+
+		// The config is wrong, not the replica, so replacing it wouldn't help
+		if errors.Is(err, errProbeUnusable) {
+			failed[containerID] = 0
+			logger.Warn("The liveness check can't run, the replica isn't checked", "container", ctr.Name, "error", err)
+			continue
+		}
+
+		failed[containerID]++
+		logger.Debug("Replica failed its liveness check", "container", ctr.Name, "failures", failed[containerID], "error", err)
+
+		if failed[containerID] >= m.config.Liveness.FailureThreshold {
+			failed[containerID] = 0
+			m.report(ctx, &ReplicaFailure{
+				ContainerID: containerID,
+				Name:        ctr.Name,
+				Reason:      utils.REPLACE_LIVENESS,
+				Error:       fmt.Sprintf("failed %d liveness checks in a row, last: %s", m.config.Liveness.FailureThreshold, err),
+			})
+		}
+	}
+
+	// Forget the replicas that are gone
+	for containerID := range failed {
+		if !checked[containerID] {
+			delete(failed, containerID)
+		}
+	}
+}
+
+// Reports the containers that exit or run out of memory. Those stopped by the scaler are out of the
+// load balancer by then, Replace ignores them
+func (m *LivenessMonitor) watchEvents(ctx context.Context) {
+	logger := logging.Component(ctx, "liveness")
+
+	options := dockertypes.EventsOptions{Filters: filters.NewArgs(
+		filters.Arg("type", string(dockerevents.ContainerEventType)),
+		filters.Arg("event", string(dockerevents.ActionDie)),
+		filters.Arg("event", string(dockerevents.ActionOOM)),
+	)}
+
+	for ctx.Err() == nil {
+		messages, errs := m.cl.Events(ctx, options)
+
+	stream:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-errs:
+				if ctx.Err() != nil {
+					return
+				}
+
+				logger.Warn("Stopped receiving Docker events, watching them again", "error", err, "retry_in", utils.DOCKER_EVENTS_RETRY.String())
+				break stream
+			case message := <-messages:
+				reason := utils.REPLACE_DIE
+				detail := fmt.Sprintf("exited with code %s", message.Actor.Attributes["exitCode"])
+				if message.Action == dockerevents.ActionOOM {
+					reason = utils.REPLACE_OOM
+					detail = "ran out of memory"
+				}
+
+				m.report(ctx, &ReplicaFailure{
+					ContainerID: message.Actor.ID,
+					Name:        message.Actor.Attributes["name"],
+					Reason:      reason,
+					Error:       detail,
+				})
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(utils.DOCKER_EVENTS_RETRY):
+		}
+	}
+}
+
+func (m *LivenessMonitor) report(ctx context.Context, failure *ReplicaFailure) {
+	select {
+	case m.failures <- failure:
+	case <-ctx.Done():
+	}
+}
+
+// Ejects the replica of failure from the load balancer, removes it and starts a new one in its place. Nothing is
+// done if the replica isn't in the load balancer anymore, like one the scaler stopped or one already replaced
+func Replace(config *Config, failure *ReplicaFailure, cl *clients.Docker, ct *context.Context) error {
+	ctx, span := telemetry.StartSpan(*ct, "replace", attribute.String("service", config.Service),
+		attribute.String("container.name", failure.Name), attribute.String("replace.reason", failure.Reason))
+	var err error
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	logger := logging.Component(ctx, "liveness")
+
+	upstream, err := utils.GetUpstreamServers()
+	if err != nil {
+		err = errors.New(fmt.Sprintf("In scaler.Replace: Failed to read the replicas in the load balancer -> %s", err))
+		return err
+	}
+
+	if !contains(upstream, failure.Name) {
+		logger.Debug("Not replacing the replica, it isn't in the load balancer", "container", failure.Name, "reason", failure.Reason)
+		return nil
+	}
+
+	if config.DryRun {
+		logger.Info("Dry run, not replacing the replica", "container", failure.Name, "reason", failure.Reason, "error", failure.Error)
+		return nil
+	}
+
+	logger.Warn("Replacing replica", "container", failure.Name, "reason", failure.Reason, "error", failure.Error)
+
+	err = replaceReplica(config, failure, cl, &ctx)
+	telemetry.ObserveReplacement(config.Service, failure.Reason, err)
+
+	if err != nil {
+		events.Failure("liveness", events.REPLACEMENT_FAILED, fmt.Sprintf("Failed to replace replica %s (%s)", failure.Name, failure.Reason), err)
+		return err
+	}
+
+	events.Publish(Event{
+		Source:  "liveness",
+		Type:    events.REPLICA_REPLACED,
+		Message: fmt.Sprintf("Replaced replica %s (%s)", failure.Name, failure.Reason),
+		Error:   failure.Error,
+	})
+
+	return nil
+}
+
+// Takes the replica out of the load balancer right away, without draining it: it isn't serving anyway.
+// The new replica is added once it is ready, like on a scale up
+func replaceReplica(config *Config, failure *ReplicaFailure, cl *clients.Docker, ctx *context.Context) error {
+	if err := utils.RemoveServer(failure.Name, cl, ctx); err != nil {
+		return errors.New(fmt.Sprintf("In replaceReplica: Failed to eject %s -> %s", failure.Name, err))
+	}
+
+	// A replica that exited may also have been removed already
+	gracePeriod := int(math.Ceil(config.Drain.GracePeriod.Seconds()))
+
+	if err := cl.ContainerStop(*ctx, failure.ContainerID, container.StopOptions{Timeout: &gracePeriod}); err != nil && !errdefs.IsNotFound(err) {
+		return errors.New(fmt.Sprintf("In replaceReplica: Failed to stop %s -> %s", failure.Name, err))
+	}
+
+	if err := cl.ContainerRemove(*ctx, failure.ContainerID, container.RemoveOptions{}); err != nil && !errdefs.IsNotFound(err) {
+		return errors.New(fmt.Sprintf("In replaceReplica: Failed to remove %s -> %s", failure.Name, err))
+	}
+
+	if _, err := startContainer(config, utils.GRS_IMAGE, cl, ctx); err != nil {
+		return errors.New(fmt.Sprintf("In replaceReplica: Failed to start a replica in place of %s -> %s", failure.Name, err))
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
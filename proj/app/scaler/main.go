@@ -5,84 +5,161 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"math"
-	"strconv"
-
-	"log"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/network"
-	"github.com/docker/docker/client"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	clients "grs/common/clients"
+	events "grs/common/events"
+	logging "grs/common/logging"
+	telemetry "grs/common/telemetry"
 	. "grs/common/types"
 	utils "grs/common/utils"
 )
 
-func Run(s *sync.WaitGroup, config *Config, stats []*Stats, ct *context.Context) {
-	apiClient, err := client.NewClientWithOpts(client.WithAPIVersionNegotiation())
+// Scales up or down based on the stats received from the Metric Collector.
+// The decision taken is sent through dc and, if the scale action fails, the error through errc
+func Run(s *sync.WaitGroup, dc chan *Decision, errc chan error, config *Config, stats []*Stats, apiClient *clients.Docker, ct *context.Context) {
+	defer s.Done()
 
-	if err != nil {
-		log.Fatalln("In scaler.Run: Failed to create Docker API Client")
-	}
-	defer apiClient.Close()
+	started := time.Now()
 
-	ctx, cancel := context.WithCancel(*ct)
+	ctx, span := telemetry.StartSpan(*ct, "scale", attribute.String("service", config.Service))
+	defer span.End()
+
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	_, decideSpan := telemetry.StartSpan(ctx, "decide")
+	defer decideSpan.End()
+
+	logger := logging.Component(ctx, "scaler")
+
+	// Until the replicas are listed, the ones the stats come from are the best guess of the running ones
+	decision := newDecision(config, stats, len(stats), started)
+
+	defer func() {
+		decision.DurationMs = float64(time.Since(started).Microseconds()) / 1000
+		logger.Info("Decision taken", "decision", decision.ID, "action", decision.Action, "outcome", decision.Outcome,
+			"current_replicas", decision.CurrentReplicas, "desired_replicas", decision.DesiredReplicas, "reason", decision.Reason)
+		telemetry.RecordDecision(ctx, span, config.Service, decision)
+		if decision.Error != "" {
+			telemetry.EndSpan(span, errors.New(decision.Error))
+		}
+		dc <- decision
+	}()
+
 	runningContainers, err := utils.GetContainersOnNetwork(utils.GRS_NETWORK, apiClient, &ctx)
 	if err != nil {
-		log.Fatalln("In scaler.Run: Failed to get containers on GRS network")
+		err = errors.New(fmt.Sprintf("In scaler.Run: Failed to get containers on GRS network -> %s", err))
+		listFailed(decision, err)
+		errc <- err
+		return
 	}
 
 	runningReplicas := len(*runningContainers) - 1 // remove load balancer
 
-	for _, stat := range stats {
-		fmt.Println("Printing stats received from Metric Collector")
-		utils.PrettyPrint(stat)
-		memUsage, convErr := strconv.ParseFloat(strings.Split(stat.MemoryUsage, "%")[0], 32)
-		
-		if convErr != nil {
-			fmt.Println("Error converting string to float, skipping...")
-			continue
+	decision.CurrentReplicas = runningReplicas
+	decision.DesiredReplicas = runningReplicas
+
+	decide(config, stats, runningReplicas, decision, logger)
+
+	decideSpan.End()
+
+	if config.DryRun {
+		target := stepTarget(config, decision, runningReplicas)
+		if err := dryRun(config, decision, runningContainers, runningReplicas, target, apiClient, &ctx); err != nil {
+			events.Failure("scaler", failedEvent(decision.Action), fmt.Sprintf("Failed to plan the dry run from %d replicas", runningReplicas), err)
+			errc <- err
 		}
+		return
+	}
 
-		memThreshold, convErr := strconv.ParseFloat(config.Metrics.Memory.Threshold, 32)
-		if convErr != nil {
-			fmt.Println("Error converting memory threshold to float, skipping...")
-			continue
+	switch decision.Action {
+	case utils.ACTION_SCALE_UP:
+		actCtx, actSpan := telemetry.StartSpan(ctx, "act.scale_up", attribute.String("container.image", utils.GRS_IMAGE))
+		containerID, err := startContainer(config, utils.GRS_IMAGE, apiClient, &actCtx)
+		actSpan.SetAttributes(attribute.String("container.id", containerID))
+		telemetry.EndSpan(actSpan, err)
+		setOutcome(decision, containerID, err)
+
+		if err != nil {
+			events.Failure("scaler", events.SCALE_UP_FAILED, fmt.Sprintf("Failed to scale up from %d replicas", runningReplicas), err)
+			errc <- err
+		}
+	case utils.ACTION_SCALE_DOWN:
+		actCtx, actSpan := telemetry.StartSpan(ctx, "act.scale_down")
+		containerID, err := stopContainer(config, config.MinReplicas, apiClient, &actCtx)
+		actSpan.SetAttributes(attribute.String("container.id", containerID))
+		telemetry.EndSpan(actSpan, err)
+		setOutcome(decision, containerID, err)
+
+		if err == nil && containerID == "" {
+			decision.Reason = fmt.Sprintf("%s, but only %d replicas are running and min_replicas is %d", decision.Reason, runningReplicas, config.MinReplicas)
+		}
+
+		if err != nil {
+			events.Failure("scaler", events.SCALE_DOWN_FAILED, fmt.Sprintf("Failed to scale down from %d replicas", runningReplicas), err)
+			errc <- err
 		}
+	}
+}
 
-		cpuUsage, convErr := strconv.ParseFloat(strings.Split(stat.CPUUsage, "%")[0], 32)
+// Returns the type of the event published when the scale action fails
+func failedEvent(action string) string {
+	if action == utils.ACTION_SCALE_DOWN {
+		return events.SCALE_DOWN_FAILED
+	}
 
-		cpuThreshold, convErr := strconv.ParseFloat(config.Metrics.CPU.Threshold, 32)
+	return events.SCALE_UP_FAILED
+}
+
+// Sets the desired replicas, the action and the reason of decision from the first replica that is
+// over or under the thresholds. The action stays none if every replica is within them
+func decide(config *Config, stats []*Stats, runningReplicas int, decision *Decision, logger *slog.Logger) {
+	memThreshold := decision.Inputs.MemoryThreshold
+	cpuThreshold := decision.Inputs.CPUThreshold
+
+	for i, stat := range stats {
+		memUsage := stat.MemoryUsage
+		cpuUsage := stat.CPUUsage
 
 		desiredReplicas := max(math.Ceil(float64(runningReplicas) * (memUsage / memThreshold)), math.Ceil(float64(runningReplicas) * (cpuUsage / cpuThreshold)))
 
-		fmt.Println(desiredReplicas, runningReplicas, memUsage, memThreshold)
+		if desiredReplicas == float64(runningReplicas) {
+			continue
+		}
+
+		decision.DesiredReplicas = int(desiredReplicas)
+		decision.Inputs.TriggerCPUUsage = cpuUsage
+		decision.Inputs.TriggerMemoryUsage = memUsage
+		decision.Reason = fmt.Sprintf("replica %d of %d (%s) has CPU usage %.3f%% (threshold %.3f%%) and memory usage %.3f%% (threshold %.3f%%): desired replicas = ceil(%d * max(%.3f/%.3f, %.3f/%.3f)) = %d",
+			i + 1, len(stats), stat.ContainerName, cpuUsage, cpuThreshold, memUsage, memThreshold, runningReplicas, cpuUsage, cpuThreshold, memUsage, memThreshold, decision.DesiredReplicas)
 
 		if desiredReplicas > float64(runningReplicas) {
-			startContainer(utils.GRS_IMAGE, apiClient, &ctx)
-			break
+			decision.Action = utils.ACTION_SCALE_UP
+		} else {
+			decision.Action = utils.ACTION_SCALE_DOWN
 		}
 
-		if desiredReplicas < float64(runningReplicas) {
-			stopContainer(apiClient, &ctx)
-			break
-		}
+		return
 	}
-	
-	s.Done()
 }
 
-// Starts a container provided an image name
-func startContainer(imageName string, cl *client.Client, ctx *context.Context) error {
+// Starts a container provided an image name and adds it to the load balancer once it passes the readiness
+// checks of config. A container that doesn't is removed. Returns the ID of the container
+func startContainer(config *Config, imageName string, cl *clients.Docker, ctx *context.Context) (string, error) {
 
 	networkID, err := utils.GetNetworkID(utils.GRS_NETWORK, cl, ctx)
 
 	if err != nil {
-		return errors.New(fmt.Sprintf("In startContainer: Failed to get ID of network %s", utils.GRS_NETWORK))
+		return "", errors.New(fmt.Sprintf("In startContainer: Failed to get ID of network %s", utils.GRS_NETWORK))
 	}
 
 	netconf := make(map[string]*network.EndpointSettings)
@@ -104,96 +181,160 @@ func startContainer(imageName string, cl *client.Client, ctx *context.Context) e
 	)
 
 	if createErr != nil {
-		return errors.New(fmt.Sprintf("In startContainer: Failed to create container -> %s", createErr.Error()))
+		return "", errors.New(fmt.Sprintf("In startContainer: Failed to create container -> %s", createErr.Error()))
 	}
 	
 	startErr := cl.ContainerStart(*ctx, response.ID, container.StartOptions{})
 	
 	if startErr != nil {
-		return errors.New(fmt.Sprintf("In startContainer: Failed to start container with ID %s -> %s", response.ID, startErr.Error()))
+		return response.ID, errors.New(fmt.Sprintf("In startContainer: Failed to start container with ID %s -> %s", response.ID, startErr.Error()))
 	}
 
 	containerName, err := utils.GetContainerName(response.ID, cl, ctx)
 	if err != nil {
-		return errors.New(fmt.Sprintf("In startContainer: Failed to get container name -> %s", err.Error()))
+		return response.ID, errors.New(fmt.Sprintf("In startContainer: Failed to get container name -> %s", err.Error()))
+	}
+
+	trace.SpanFromContext(*ctx).SetAttributes(attribute.String("container.name", *containerName))
+
+	readyCtx, readySpan := telemetry.StartSpan(*ctx, "act.readiness", attribute.String("container.name", *containerName), attribute.String("readiness.check", config.Readiness.Check))
+	readyErr := waitUntilReady(config, response.ID, cl, &readyCtx)
+	telemetry.EndSpan(readySpan, readyErr)
+
+	if readyErr != nil {
+		// Never route traffic to a replica that isn't ready
+		rollbackErr := removeContainer(response.ID, container.StopOptions{}, cl, ctx)
+		if rollbackErr != nil {
+			return response.ID, errors.New(fmt.Sprintf("In startContainer: Replica isn't ready (%s) and failed to roll back container with ID %s -> %s", readyErr.Error(), response.ID, rollbackErr.Error()))
+		}
+
+		events.Publish(Event{
+			Source:  "scaler",
+			Type:    events.ROLLBACK,
+			Message: fmt.Sprintf("Removed container %s, it didn't become ready", *containerName),
+			Error:   readyErr.Error(),
+		})
+
+		return response.ID, fmt.Errorf("In startContainer: Replica isn't ready -> %s: %w", readyErr.Error(), ErrRolledBack)
+	}
+
+	addErr := utils.AddNewServer(*containerName, cl, ctx)
+	if addErr != nil {
+		// Don't leave a running container that the load balancer doesn't know about
+		rollbackErr := removeContainer(response.ID, container.StopOptions{}, cl, ctx)
+		if rollbackErr != nil {
+			return response.ID, errors.New(fmt.Sprintf("In startContainer: Failed to add server (%s) and to roll back container with ID %s -> %s", addErr.Error(), response.ID, rollbackErr.Error()))
+		}
+
+		events.Publish(Event{
+			Source:  "scaler",
+			Type:    events.ROLLBACK,
+			Message: fmt.Sprintf("Removed container %s, it couldn't be added to the load balancer", *containerName),
+			Error:   addErr.Error(),
+		})
+
+		return response.ID, fmt.Errorf("In startContainer: Failed to add server -> %s: %w", addErr.Error(), ErrRolledBack)
 	}
 
-	utils.AddNewServer(*containerName, cl, ctx)
+	return response.ID, nil
+}
+
+// Stops the container with ID containerID with options and removes it
+func removeContainer(containerID string, options container.StopOptions, cl *clients.Docker, ctx *context.Context) error {
+	stopErr := cl.ContainerStop(*ctx, containerID, options)
+	if stopErr != nil {
+		return errors.New(fmt.Sprintf("In removeContainer: Failed to stop container -> %s", stopErr.Error()))
+	}
+
+	removeErr := cl.ContainerRemove(*ctx, containerID, container.RemoveOptions{})
+	if removeErr != nil {
+		return errors.New(fmt.Sprintf("In removeContainer: Failed to remove container -> %s", removeErr.Error()))
+	}
 
 	return nil
 }
 
-// Stops the container with less usage
-func stopContainer(cl *client.Client, ctx *context.Context) error {
+// Drains and removes the replica picked by the victim selector of config, as long as more than minReplicas
+// are running. Returns the ID of the removed container, or an empty string if none was removed
+func stopContainer(config *Config, minReplicas int, cl *clients.Docker, ctx *context.Context) (string, error) {
 
 	grsContainers, err := utils.GetContainersOnNetwork(utils.GRS_NETWORK, cl, ctx)
 
-	if len(*grsContainers) <= 1 { // we need to have at least one container running
-		return nil
+	if err != nil {
+		return "", err
+	}
+
+	if len(*grsContainers) - 1 <= minReplicas { // we need to have at least minReplicas containers running, the load balancer doesn't count
+		return "", nil
 	}
 
+	selector, err := NewVictimSelector(config)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	allStats := map[string]Stats{}
+	sorted, err := selectVictims(selector, grsContainers, cl, ctx)
+	if err != nil {
+		return "", err
+	}
 
-	
-	for _, ctr := range *grsContainers {
-		if strings.Compare(ctr.Name, utils.GRS_LOAD_BALANCER) == 0 { // Don't add the Load Balancer to the map, since we will never want to stop it
-			continue
-		}
+	victim := sorted[0]
 
-		stats, err := utils.GetContainerStats(ctr.Name, cl, ctx)
+	trace.SpanFromContext(*ctx).SetAttributes(attribute.String("container.name", victim))
 
-		if err != nil {
-			return err
-		}
-		
-		allStats[ctr.Name] = *stats
+	containerID, err := utils.GetContainerID(victim, cl, ctx)
+
+	if err != nil {
+		return "", err
 	}
 
-	/*
-	allStats["Less CPU Usage"] = Stats{
-		UsedMemory: 1000,
-		AvailableMemory: 2000,
-		MemoryUsage: "50%",
-		NumberOfCPUs: 16,
-		CPUUsage: "10%",
+	if err := drainContainer(config, victim, *containerID, victimAddress(grsContainers, victim), cl, ctx); err != nil {
+		return *containerID, err
 	}
 
-	allStats["Most CPU Usage"] = Stats{
-		UsedMemory: 1000,
-		AvailableMemory: 2000,
-		MemoryUsage: "30%",
-		NumberOfCPUs: 16,
-		CPUUsage: "70%",
-	}*/
+	return *containerID, nil
+}
 
-	sorted := utils.SortContainersByUsage(allStats, false)
+// Stops replicas until only config.MinReplicas are left running. Used when the application shuts down
+func ScaleToMin(config *Config, apiClient *clients.Docker, ct *context.Context) error {
+	ctx, cancel := context.WithCancel(*ct)
+	defer cancel()
 
-	for i, v := range(sorted) {
-		fmt.Printf("%d: %s\n", i, v)
-	}
+	for ctx.Err() == nil {
+		runningContainers, err := utils.GetContainersOnNetwork(utils.GRS_NETWORK, apiClient, &ctx)
+		if err != nil {
+			return errors.New(fmt.Sprintf("In scaler.ScaleToMin: Failed to get containers on GRS network -> %s", err.Error()))
+		}
 
-	leastUsedContainer := sorted[0]
+		runningReplicas := len(*runningContainers) - 1 // remove load balancer
 
-	containerID, err := utils.GetContainerID(leastUsedContainer, cl, ctx)
+		if runningReplicas <= config.MinReplicas {
+			return nil
+		}
 
-	if err != nil {
-		return err
-	}
+		logging.Component(ctx, "scaler").Info("Scaling down on shutdown", "service", config.Service, "current_replicas", runningReplicas, "min_replicas", config.MinReplicas)
 
-	removeErr := utils.RemoveServer(leastUsedContainer, cl, ctx)
-	if removeErr != nil {
-		return errors.New(fmt.Sprintf("In stopContainer: Failed to remove server -> %s", removeErr.Error()))
+		if _, err := stopContainer(shutdownDrain(config, runningReplicas-config.MinReplicas, &ctx), config.MinReplicas, apiClient, &ctx); err != nil {
+			return err
+		}
 	}
 
-	stopErr := cl.ContainerStop(*ctx, *containerID, container.StopOptions{})
+	return errors.New(fmt.Sprintf("In scaler.ScaleToMin: Gave up before reaching %d replicas -> %s", config.MinReplicas, ctx.Err()))
+}
 
-	if stopErr != nil {
-		return errors.New(fmt.Sprintf("In stopContainer: Failed to stop container -> %s", stopErr.Error()))
+// Returns config with a drain that fits the time left to ctx: each of the replicas left to stop gets an equal share,
+// half of it to drain and a quarter as the grace period, the rest for the Docker and Nginx calls
+func shutdownDrain(config *Config, replicas int, ctx *context.Context) *Config {
+	deadline, ok := (*ctx).Deadline()
+	if !ok || replicas <= 0 {
+		return config
 	}
 
-	return nil
+	share := time.Until(deadline) / time.Duration(replicas)
+
+	drainConfig := *config
+	drainConfig.Drain.Timeout = min(config.Drain.Timeout, share/2)
+	drainConfig.Drain.GracePeriod = min(config.Drain.GracePeriod, share/4)
+
+	return &drainConfig
 }
@@ -0,0 +1,120 @@
+package scaler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	clients "grs/common/clients"
+	events "grs/common/events"
+	logging "grs/common/logging"
+	telemetry "grs/common/telemetry"
+	. "grs/common/types"
+	utils "grs/common/utils"
+)
+
+// Starts or stops replicas until target are running, instead of following the thresholds.
+// Used while a manual override is active. The decision is sent through dc and, if a scale action fails, the error through errc
+func RunManual(s *sync.WaitGroup, dc chan *Decision, errc chan error, config *Config, stats []*Stats, target int, reason string, apiClient *clients.Docker, ct *context.Context) {
+	defer s.Done()
+
+	started := time.Now()
+
+	ctx, span := telemetry.StartSpan(*ct, "scale", attribute.String("service", config.Service), attribute.Int("override.replicas", target))
+	defer span.End()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	logger := logging.Component(ctx, "scaler")
+
+	// The inputs are still recorded, so the decision shows what the thresholds would have done. Until the
+	// replicas are listed, the ones the stats come from are the best guess of the running ones
+	decision := newDecision(config, stats, len(stats), started)
+	decision.Policy = utils.POLICY_MANUAL
+	decision.DesiredReplicas = target
+	decision.Reason = reason
+
+	defer func() {
+		decision.DurationMs = float64(time.Since(started).Microseconds()) / 1000
+		logger.Info("Decision taken", "decision", decision.ID, "action", decision.Action, "outcome", decision.Outcome,
+			"current_replicas", decision.CurrentReplicas, "desired_replicas", decision.DesiredReplicas, "reason", decision.Reason)
+		telemetry.RecordDecision(ctx, span, config.Service, decision)
+		if decision.Error != "" {
+			telemetry.EndSpan(span, errors.New(decision.Error))
+		}
+		dc <- decision
+	}()
+
+	runningContainers, err := utils.GetContainersOnNetwork(utils.GRS_NETWORK, apiClient, &ctx)
+	if err != nil {
+		err = errors.New(fmt.Sprintf("In scaler.RunManual: Failed to get containers on GRS network -> %s", err))
+		listFailed(decision, err)
+		errc <- err
+		return
+	}
+
+	runningReplicas := len(*runningContainers) - 1 // remove load balancer
+
+	decision.CurrentReplicas = runningReplicas
+
+	switch {
+	case target > runningReplicas:
+		decision.Action = utils.ACTION_SCALE_UP
+	case target < runningReplicas:
+		decision.Action = utils.ACTION_SCALE_DOWN
+	default:
+		return
+	}
+
+	if config.DryRun {
+		if err := dryRun(config, decision, runningContainers, runningReplicas, target, apiClient, &ctx); err != nil {
+			events.Failure("scaler", failedEvent(decision.Action), fmt.Sprintf("Failed to plan the dry run from %d to %d replicas", runningReplicas, target), err)
+			errc <- err
+		}
+		return
+	}
+
+	actCtx, actSpan := telemetry.StartSpan(ctx, "act."+decision.Action)
+	err = scaleTo(config, target, runningReplicas, decision, apiClient, &actCtx)
+	telemetry.EndSpan(actSpan, err)
+
+	if err != nil {
+		events.Failure("scaler", failedEvent(decision.Action), fmt.Sprintf("Failed to scale from %d to %d replicas", runningReplicas, target), err)
+		errc <- err
+	}
+}
+
+// Starts or stops one replica at a time until target are running. The containers started or
+// stopped and the outcome are recorded on decision. Stops at the first failure
+func scaleTo(config *Config, target int, running int, decision *Decision, cl *clients.Docker, ctx *context.Context) error {
+	for running != target {
+		var containerID string
+		var err error
+
+		if running < target {
+			containerID, err = startContainer(config, utils.GRS_IMAGE, cl, ctx)
+			running++
+		} else {
+			containerID, err = stopContainer(config, target, cl, ctx)
+			running--
+		}
+
+		if err == nil && containerID == "" {
+			// stopContainer found no more than target replicas running
+			return nil
+		}
+
+		setOutcome(decision, containerID, err)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
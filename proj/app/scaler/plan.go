@@ -0,0 +1,142 @@
+package scaler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	clients "grs/common/clients"
+	logging "grs/common/logging"
+	. "grs/common/types"
+	utils "grs/common/utils"
+)
+
+// Name given in the planned upstream to a replica a scale up would start, which only gets its real name from Docker
+const PLANNED_REPLICA string = "<new replica>"
+
+// What the scaler would do with stats, without starting or stopping anything
+type Plan struct {
+	Decision *Decision
+	// Nil if the upstream servers of the load balancer wouldn't change
+	Nginx *utils.NginxChange
+}
+
+// Takes the decision Run would take with stats and returns it with the Nginx config it would write.
+// The containers of the decision are the replicas that would be stopped, if any
+func PlanScale(config *Config, stats []*Stats, apiClient *clients.Docker, ct *context.Context) (*Plan, error) {
+	ctx, cancel := context.WithCancel(*ct)
+	defer cancel()
+
+	started := time.Now()
+
+	runningContainers, err := utils.GetContainersOnNetwork(utils.GRS_NETWORK, apiClient, &ctx)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("In scaler.PlanScale: Failed to get containers on GRS network -> %s", err))
+	}
+
+	runningReplicas := len(*runningContainers) - 1 // remove load balancer
+
+	decision := newDecision(config, stats, runningReplicas, started)
+
+	decide(config, stats, runningReplicas, decision, logging.Component(ctx, "scaler"))
+
+	target := stepTarget(config, decision, runningReplicas)
+
+	change, err := planReplicas(config, decision, runningContainers, runningReplicas, target, apiClient, &ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	decision.DurationMs = float64(time.Since(started).Microseconds()) / 1000
+
+	return &Plan{Decision: decision, Nginx: change}, nil
+}
+
+// Returns the replicas Run scales to for the action of decision: one more or one less, but never
+// below config.MinReplicas. The reason says so when min_replicas prevents a scale down
+func stepTarget(config *Config, decision *Decision, runningReplicas int) int {
+	switch decision.Action {
+	case utils.ACTION_SCALE_UP:
+		return runningReplicas + 1
+	case utils.ACTION_SCALE_DOWN:
+		if runningReplicas <= config.MinReplicas {
+			decision.Reason = fmt.Sprintf("%s, but only %d replicas are running and min_replicas is %d", decision.Reason, runningReplicas, config.MinReplicas)
+			return runningReplicas
+		}
+
+		return runningReplicas - 1
+	}
+
+	return runningReplicas
+}
+
+// Records on decision what scaling from running to target replicas would do: the replicas that would
+// be stopped and the upstream servers the load balancer would get, with the ones started as PLANNED_REPLICA. Returns the Nginx
+// config that would be written, or nil if nothing would change
+func planReplicas(config *Config, decision *Decision, runningContainers *map[string]types.EndpointResource, running int, target int, apiClient *clients.Docker, ctx *context.Context) (*utils.NginxChange, error) {
+	var add, remove []string
+
+	switch {
+	case target > running:
+		for i := 1; i <= target-running; i++ {
+			if target-running == 1 {
+				add = append(add, PLANNED_REPLICA)
+			} else {
+				add = append(add, fmt.Sprintf("<new replica %d>", i))
+			}
+		}
+	case target < running:
+		selector, err := NewVictimSelector(config)
+		if err != nil {
+			return nil, err
+		}
+
+		sorted, err := selectVictims(selector, runningContainers, apiClient, ctx)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("In scaler.planReplicas: Failed to find the replicas to stop -> %s", err))
+		}
+
+		remove = sorted[:min(running-target, len(sorted))]
+	default:
+		return nil, nil
+	}
+
+	change, err := utils.PlanUpstreamChange(add, remove)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("In scaler.planReplicas: Failed to plan the Nginx config -> %s", err))
+	}
+
+	// The replicas that would be started have no container yet, they are only in the upstream
+	decision.Containers = append(decision.Containers, remove...)
+	decision.Upstream = change.Upstream
+	decision.Outcome = utils.OUTCOME_PLANNED
+
+	return change, nil
+}
+
+// Records and logs what scaling to target replicas would do instead of doing it. Nothing is started
+// or stopped and the Nginx config is neither written nor reloaded
+func dryRun(config *Config, decision *Decision, runningContainers *map[string]types.EndpointResource, running int, target int, apiClient *clients.Docker, ctx *context.Context) error {
+	decision.DryRun = true
+
+	logger := logging.Component(*ctx, "scaler")
+
+	change, err := planReplicas(config, decision, runningContainers, running, target, apiClient, ctx)
+	if err != nil {
+		setOutcome(decision, "", err)
+		return err
+	}
+
+	if change == nil {
+		return nil
+	}
+
+	logger.Info("Dry run, not scaling", "action", decision.Action, "current_replicas", running, "target_replicas", target,
+		"image", utils.GRS_IMAGE, "containers", decision.Containers, "upstream", decision.Upstream)
+	logger.Debug("Dry run, not writing the Nginx config", "config", change.New)
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package scaler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	clients "grs/common/clients"
+	. "grs/common/types"
+	utils "grs/common/utils"
+)
+
+// A probe fails with this error when the replica can't ever pass it, like when it exited
+var errProbeFatal = errors.New("replica can't pass the check")
+
+// A probe fails with this error when it can't check the replica at all, like the docker check of an image
+// without a HEALTHCHECK. The config is wrong, not the replica
+var errProbeUnusable = errors.New("the check can't run on the replica")
+
+// Checks a replica once: connects to it from the load balancer, or reads the status of its Docker HEALTHCHECK
+type probe struct {
+	// utils.PROBE_HTTP, utils.PROBE_TCP or utils.PROBE_DOCKER
+	check          string
+	port           int
+	path           string
+	expectedStatus int
+	// Longest a check can take
+	timeout time.Duration
+}
+
+// Returns the probe of the readiness section of config
+func readinessProbe(config *Config) *probe {
+	return &probe{
+		check:          config.Readiness.Check,
+		port:           config.Readiness.Port,
+		path:           config.Readiness.Path,
+		expectedStatus: config.Readiness.ExpectedStatus,
+		timeout:        config.Readiness.Interval,
+	}
+}
+
+// Returns the probe of the liveness section of config
+func livenessProbe(config *Config) *probe {
+	return &probe{
+		check:          config.Liveness.Check,
+		port:           config.Liveness.Port,
+		path:           config.Liveness.Path,
+		expectedStatus: config.Liveness.ExpectedStatus,
+		timeout:        config.Liveness.Interval,
+	}
+}
+
+// Checks the replica with containerID, at address on the GRS network. Returns nil if it passed
+func (p *probe) run(containerID string, address string, cl *clients.Docker, ctx *context.Context) error {
+	checkCtx, cancel := context.WithTimeout(*ctx, p.timeout)
+	defer cancel()
+
+	if p.check == utils.PROBE_DOCKER {
+		return checkHealth(containerID, cl, &checkCtx)
+	}
+
+	if address == "" {
+		return fmt.Errorf("In probe.run: Replica has no address on network %s: %w", utils.GRS_NETWORK, errProbeFatal)
+	}
+
+	// The replica is reached from the load balancer, like the requests are: the GRS network isn't
+	// reachable from the host everywhere, like with Docker Desktop
+	switch p.check {
+	case utils.PROBE_HTTP:
+		status, err := utils.HTTPStatusFromLoadBalancer(address, p.port, p.path, p.timeout, cl, &checkCtx)
+		if err != nil {
+			return errors.New(fmt.Sprintf("In probe.run: GET %s failed -> %s", p.path, err))
+		}
+
+		if status != p.expectedStatus {
+			return errors.New(fmt.Sprintf("In probe.run: GET %s answered %d, expected %d", p.path, status, p.expectedStatus))
+		}
+	case utils.PROBE_TCP:
+		if err := utils.DialFromLoadBalancer(address, p.port, p.timeout, cl, &checkCtx); err != nil {
+			return errors.New(fmt.Sprintf("In probe.run: Failed to connect to port %d -> %s", p.port, err))
+		}
+	}
+
+	return nil
+}
+
+// Passes when the Docker HEALTHCHECK of the container with containerID reports it healthy
+func checkHealth(containerID string, cl *clients.Docker, ctx *context.Context) error {
+	info, err := cl.ContainerInspect(*ctx, containerID)
+	if err != nil {
+		return errors.New(fmt.Sprintf("In checkHealth: Failed to inspect container -> %s", err))
+	}
+
+	if info.State == nil || !info.State.Running {
+		return fmt.Errorf("In checkHealth: Container isn't running: %w", errProbeFatal)
+	}
+
+	if info.State.Health == nil {
+		return fmt.Errorf("In checkHealth: Image %s has no HEALTHCHECK: %w", info.Config.Image, errProbeUnusable)
+	}
+
+	if info.State.Health.Status != types.Healthy {
+		return errors.New(fmt.Sprintf("In checkHealth: Container is %s", info.State.Health.Status))
+	}
+
+	return nil
+}
+
+// Returns the IP address of the container with containerID on the GRS network, or an empty string if it isn't on it
+func containerAddress(containerID string, cl *clients.Docker, ctx *context.Context) (string, error) {
+	info, err := cl.ContainerInspect(*ctx, containerID)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("In containerAddress: Failed to inspect container -> %s", err))
+	}
+
+	if info.NetworkSettings == nil || info.NetworkSettings.Networks[utils.GRS_NETWORK] == nil {
+		return "", nil
+	}
+
+	return info.NetworkSettings.Networks[utils.GRS_NETWORK].IPAddress, nil
+}
+
+// Checks that the probes of config can run on the replicas: the docker check needs a HEALTHCHECK in their image
+func CheckProbes(config *Config, cl *clients.Docker, ctx *context.Context) error {
+	var checks []string
+	if config.Readiness.Check == utils.PROBE_DOCKER {
+		checks = append(checks, "readiness.check")
+	}
+
+	if config.Liveness.Check == utils.PROBE_DOCKER {
+		checks = append(checks, "liveness.check")
+	}
+
+	if len(checks) == 0 {
+		return nil
+	}
+
+	hasHealthcheck, err := imageHasHealthcheck(utils.GRS_IMAGE, cl, ctx)
+	if err != nil {
+		return err
+	}
+
+	if !hasHealthcheck {
+		return errors.New(fmt.Sprintf("In scaler.CheckProbes: %s is docker but image %s has no HEALTHCHECK", strings.Join(checks, " and "), utils.GRS_IMAGE))
+	}
+
+	return nil
+}
+
+// Returns whether image defines a HEALTHCHECK that isn't disabled
+func imageHasHealthcheck(image string, cl *clients.Docker, ctx *context.Context) (bool, error) {
+	info, _, err := cl.ImageInspectWithRaw(*ctx, image)
+	if err != nil {
+		return false, errors.New(fmt.Sprintf("In imageHasHealthcheck: Failed to inspect image %s -> %s", image, err))
+	}
+
+	if info.Config == nil || info.Config.Healthcheck == nil {
+		return false, nil
+	}
+
+	test := info.Config.Healthcheck.Test
+
+	return len(test) > 0 && test[0] != "NONE", nil
+}
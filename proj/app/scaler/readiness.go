@@ -0,0 +1,76 @@
+package scaler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	clients "grs/common/clients"
+	logging "grs/common/logging"
+	. "grs/common/types"
+	utils "grs/common/utils"
+)
+
+// Checks the new replica with containerID every readiness.interval of config until it passes readiness.success_threshold
+// checks in a row. Returns an error if it doesn't within readiness.timeout, or can't ever pass. With the none check,
+// the replica is ready right away
+func waitUntilReady(config *Config, containerID string, cl *clients.Docker, ctx *context.Context) error {
+	if config.Readiness.Check == utils.PROBE_NONE {
+		return nil
+	}
+
+	logger := logging.Component(*ctx, "scaler")
+
+	address, err := containerAddress(containerID, cl, ctx)
+	if err != nil {
+		return err
+	}
+
+	p := readinessProbe(config)
+
+	started := time.Now()
+
+	deadline := time.NewTimer(config.Readiness.Timeout)
+	defer deadline.Stop()
+
+	checks, passed := 0, 0
+	var last error
+
+	for {
+		err := p.run(containerID, address, cl, ctx)
+		checks++
+
+		if err == nil {
+			passed++
+			if passed >= config.Readiness.SuccessThreshold {
+				logger.Info("Replica is ready", "container_id", containerID, "check", config.Readiness.Check, "checks", checks, "duration", time.Since(started).String())
+				return nil
+			}
+		} else {
+			passed = 0
+			last = err
+			logger.Debug("Replica isn't ready yet", "container_id", containerID, "check", config.Readiness.Check, "error", err)
+
+			// Like when the grs image was rebuilt without a HEALTHCHECK since the probes were checked. Rolling
+			// back would fail every scale up the same way
+			if errors.Is(err, errProbeUnusable) {
+				logger.Warn("The readiness check can't run, the replica is added without it", "container_id", containerID, "check", config.Readiness.Check, "error", err)
+				return nil
+			}
+
+			if errors.Is(err, errProbeFatal) {
+				return errors.New(fmt.Sprintf("In waitUntilReady: Replica can't become ready -> %s", err))
+			}
+		}
+
+		select {
+		case <-deadline.C:
+			return errors.New(fmt.Sprintf("In waitUntilReady: Replica wasn't ready after %s, %d of %d checks passed in a row -> last failure: %v",
+				config.Readiness.Timeout, passed, config.Readiness.SuccessThreshold, last))
+		case <-(*ctx).Done():
+			return errors.New(fmt.Sprintf("In waitUntilReady: Gave up waiting for the replica -> %s", (*ctx).Err()))
+		case <-time.After(config.Readiness.Interval):
+		}
+	}
+}
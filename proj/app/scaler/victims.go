@@ -0,0 +1,194 @@
+package scaler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+
+	clients "grs/common/clients"
+	logging "grs/common/logging"
+	. "grs/common/types"
+	utils "grs/common/utils"
+)
+
+// A replica a scale down could stop, with what the strategies look at
+type Replica struct {
+	Name    string
+	ID      string
+	Address string
+	Stats   *Stats
+	Created time.Time
+	// Requests the load balancer is proxying to the replica
+	Connections int
+}
+
+// Picks the replicas a scale down stops
+type VictimSelector interface {
+	// Sorts replicas in place, the one to stop first comes first
+	Sort(replicas []*Replica)
+	// Whether Sort looks at Replica.Connections, which are read from the load balancer
+	NeedsConnections() bool
+}
+
+// Returns the selector of config.Service: its strategy in victim_selection.services, or victim_selection.strategy
+func NewVictimSelector(config *Config) (VictimSelector, error) {
+	strategy := config.VictimSelection.Strategy
+	if s, ok := config.VictimSelection.Services[config.Service]; ok {
+		strategy = s
+	}
+
+	switch strategy {
+	case utils.VICTIM_LEAST_CPU:
+		return byKey(func(r *Replica) float64 { return r.Stats.CPUUsage }), nil
+	case utils.VICTIM_LEAST_MEMORY:
+		return byKey(func(r *Replica) float64 { return r.Stats.MemoryUsage }), nil
+	case utils.VICTIM_FEWEST_CONNECTIONS:
+		return &keySelector{key: func(r *Replica) float64 { return float64(r.Connections) }, connections: true}, nil
+	case utils.VICTIM_NEWEST:
+		return byKey(func(r *Replica) float64 { return -float64(r.Created.UnixNano()) }), nil
+	case utils.VICTIM_OLDEST:
+		return byKey(func(r *Replica) float64 { return float64(r.Created.UnixNano()) }), nil
+	case utils.VICTIM_WEIGHTED:
+		weights := config.VictimSelection.Weights
+		return &weightedSelector{cpu: weights.CPU, memory: weights.Memory, connections: weights.Connections, age: weights.Age}, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("In NewVictimSelector: Unknown strategy %s", strategy))
+}
+
+// Stops the replicas with the lowest key first. Ties are broken by name, so the order is the same every time
+type keySelector struct {
+	key         func(r *Replica) float64
+	connections bool
+}
+
+func byKey(key func(r *Replica) float64) *keySelector {
+	return &keySelector{key: key}
+}
+
+func (s *keySelector) Sort(replicas []*Replica) {
+	sort.SliceStable(replicas, func(i, j int) bool {
+		a, b := s.key(replicas[i]), s.key(replicas[j])
+		if a != b {
+			return a < b
+		}
+
+		return replicas[i].Name < replicas[j].Name
+	})
+}
+
+func (s *keySelector) NeedsConnections() bool {
+	return s.connections
+}
+
+// Stops the replicas with the lowest score first. The score adds the CPU usage, memory usage and
+// connections of a replica, each divided by the highest among the replicas, and its age, divided by the
+// age of the oldest, times their weights. With a weight on age, the replicas started last are stopped first
+type weightedSelector struct {
+	cpu         float64
+	memory      float64
+	connections float64
+	age         float64
+}
+
+func (s *weightedSelector) Sort(replicas []*Replica) {
+	var maxCPU, maxMemory, maxConnections, maxAge float64
+
+	now := time.Now()
+	age := func(r *Replica) float64 { return now.Sub(r.Created).Seconds() }
+
+	for _, r := range replicas {
+		maxCPU = max(maxCPU, r.Stats.CPUUsage)
+		maxMemory = max(maxMemory, r.Stats.MemoryUsage)
+		maxConnections = max(maxConnections, float64(r.Connections))
+		maxAge = max(maxAge, age(r))
+	}
+
+	scores := map[*Replica]float64{}
+	for _, r := range replicas {
+		scores[r] = s.cpu*ratio(r.Stats.CPUUsage, maxCPU) + s.memory*ratio(r.Stats.MemoryUsage, maxMemory) +
+			s.connections*ratio(float64(r.Connections), maxConnections) + s.age*ratio(age(r), maxAge)
+	}
+
+	byKey(func(r *Replica) float64 { return scores[r] }).Sort(replicas)
+}
+
+func (s *weightedSelector) NeedsConnections() bool {
+	return s.connections > 0
+}
+
+func ratio(value float64, highest float64) float64 {
+	if highest <= 0 {
+		return 0
+	}
+
+	return value / highest
+}
+
+// Returns the names of the replicas in the order selector stops them: a scale down stops the first one
+func selectVictims(selector VictimSelector, grsContainers *map[string]types.EndpointResource, cl *clients.Docker, ctx *context.Context) ([]string, error) {
+	containers, err := cl.ContainerList(*ctx, container.ListOptions{})
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("In selectVictims: Failed to list containers -> %s", err))
+	}
+
+	created := map[string]time.Time{}
+	for _, c := range containers {
+		if len(c.Names) > 0 {
+			created[strings.TrimPrefix(c.Names[0], "/")] = time.Unix(c.Created, 0)
+		}
+	}
+
+	var connections map[string]int
+	if selector.NeedsConnections() {
+		connections, err = utils.GetActiveConnections(cl, ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var replicas []*Replica
+
+	for _, ctr := range *grsContainers {
+		if strings.Compare(ctr.Name, utils.GRS_LOAD_BALANCER) == 0 { // Never stop the Load Balancer
+			continue
+		}
+
+		stats, err := utils.GetContainerStats(ctr.Name, cl, ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		address := utils.EndpointIP(ctr)
+
+		replicas = append(replicas, &Replica{
+			Name:        ctr.Name,
+			ID:          stats.ContainerID,
+			Address:     address,
+			Stats:       stats,
+			Created:     created[ctr.Name],
+			Connections: connections[address],
+		})
+	}
+
+	if len(replicas) == 0 {
+		return nil, errors.New("In selectVictims: No replica is running")
+	}
+
+	selector.Sort(replicas)
+
+	names := make([]string, 0, len(replicas))
+	for _, r := range replicas {
+		names = append(names, r.Name)
+	}
+
+	logging.Component(*ctx, "scaler").Debug("Replicas in the order a scale down stops them", "containers", names)
+
+	return names, nil
+}
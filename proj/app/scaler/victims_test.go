@@ -0,0 +1,160 @@
+package scaler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	. "grs/common/types"
+	utils "grs/common/utils"
+)
+
+// Replicas a to d: a is the oldest and the busiest on CPU, d the newest with the most connections
+func testReplicas() []*Replica {
+	start := time.Now().Add(-time.Hour)
+
+	replica := func(name string, cpu float64, memory float64, connections int, created time.Duration) *Replica {
+		return &Replica{
+			Name:        name,
+			Stats:       &Stats{CPUUsage: cpu, MemoryUsage: memory},
+			Created:     start.Add(created),
+			Connections: connections,
+		}
+	}
+
+	return []*Replica{
+		replica("c", 20, 30, 5, 20*time.Minute),
+		replica("a", 90, 10, 1, 0),
+		replica("d", 40, 30, 9, 30*time.Minute),
+		replica("b", 20, 60, 3, 10*time.Minute),
+	}
+}
+
+func names(replicas []*Replica) []string {
+	var n []string
+	for _, r := range replicas {
+		n = append(n, r.Name)
+	}
+
+	return n
+}
+
+func TestVictimSelectors(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		// CPU, memory, connections and age
+		weights  [4]float64
+		services map[string]string
+		order    []string
+		// Whether the connections must be read from the load balancer
+		connections bool
+	}{
+		// b and c have the same CPU usage, the tie is broken by name
+		{name: "least cpu", strategy: utils.VICTIM_LEAST_CPU, order: []string{"b", "c", "d", "a"}},
+		{name: "least memory", strategy: utils.VICTIM_LEAST_MEMORY, order: []string{"a", "c", "d", "b"}},
+		{name: "fewest connections", strategy: utils.VICTIM_FEWEST_CONNECTIONS, order: []string{"a", "b", "c", "d"}, connections: true},
+		{name: "newest", strategy: utils.VICTIM_NEWEST, order: []string{"d", "c", "b", "a"}},
+		{name: "oldest", strategy: utils.VICTIM_OLDEST, order: []string{"a", "b", "c", "d"}},
+		{
+			name:     "strategy of the service",
+			strategy: utils.VICTIM_LEAST_CPU,
+			services: map[string]string{"web": utils.VICTIM_OLDEST},
+			order:    []string{"a", "b", "c", "d"},
+		},
+		{
+			name:     "strategy of another service ignored",
+			strategy: utils.VICTIM_NEWEST,
+			services: map[string]string{"api": utils.VICTIM_OLDEST},
+			order:    []string{"d", "c", "b", "a"},
+		},
+		{
+			name:     "weighted on CPU only",
+			strategy: utils.VICTIM_WEIGHTED,
+			weights:  [4]float64{1, 0, 0, 0},
+			order:    []string{"b", "c", "d", "a"},
+		},
+		{
+			// Scores: a 90/90+10/60 = 1.17, b 20/90+60/60 = 1.22, c 20/90+30/60 = 0.72, d 40/90+30/60 = 0.94
+			name:     "weighted on CPU and memory",
+			strategy: utils.VICTIM_WEIGHTED,
+			weights:  [4]float64{1, 1, 0, 0},
+			order:    []string{"c", "d", "a", "b"},
+		},
+		{
+			name:        "weighted on connections",
+			strategy:    utils.VICTIM_WEIGHTED,
+			weights:     [4]float64{0, 0, 1, 0},
+			order:       []string{"a", "b", "c", "d"},
+			connections: true,
+		},
+		{
+			// The youngest replicas score the lowest, so they are stopped first
+			name:     "weighted on age",
+			strategy: utils.VICTIM_WEIGHTED,
+			weights:  [4]float64{0, 0, 0, 1},
+			order:    []string{"d", "c", "b", "a"},
+		},
+		{
+			// Every score is 0, so only the names order the replicas
+			name:     "weighted without weights",
+			strategy: utils.VICTIM_WEIGHTED,
+			order:    []string{"a", "b", "c", "d"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &Config{Service: "web"}
+			config.VictimSelection.Strategy = test.strategy
+			weights := &config.VictimSelection.Weights
+			weights.CPU, weights.Memory, weights.Connections, weights.Age = test.weights[0], test.weights[1], test.weights[2], test.weights[3]
+			config.VictimSelection.Services = test.services
+
+			selector, err := NewVictimSelector(config)
+			if err != nil {
+				t.Fatalf("NewVictimSelector() error = %v", err)
+			}
+
+			replicas := testReplicas()
+			selector.Sort(replicas)
+
+			if got := names(replicas); !reflect.DeepEqual(got, test.order) {
+				t.Errorf("Sort() = %v, want %v", got, test.order)
+			}
+
+			if got := selector.NeedsConnections(); got != test.connections {
+				t.Errorf("NeedsConnections() = %v, want %v", got, test.connections)
+			}
+		})
+	}
+}
+
+func TestVictimSelectorUnknownStrategy(t *testing.T) {
+	config := &Config{Service: "web"}
+	config.VictimSelection.Strategy = "random"
+
+	if _, err := NewVictimSelector(config); err == nil {
+		t.Error("NewVictimSelector() error = nil, want an error")
+	}
+}
+
+func TestRatio(t *testing.T) {
+	tests := []struct {
+		value   float64
+		highest float64
+		ratio   float64
+	}{
+		{value: 5, highest: 10, ratio: 0.5},
+		{value: 10, highest: 10, ratio: 1},
+		{value: 0, highest: 10, ratio: 0},
+		// Every replica at 0, like no connections at all
+		{value: 0, highest: 0, ratio: 0},
+	}
+
+	for _, test := range tests {
+		if got := ratio(test.value, test.highest); got != test.ratio {
+			t.Errorf("ratio(%v, %v) = %v, want %v", test.value, test.highest, got, test.ratio)
+		}
+	}
+}
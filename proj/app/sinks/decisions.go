@@ -0,0 +1,62 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	clients "grs/common/clients"
+	. "grs/common/types"
+	utils "grs/common/utils"
+)
+
+// Answers "why did it scale": returns the decisions that started or stopped a container since the
+// given time, newest first. If containerID is not empty, only the decisions that affected a
+// container whose ID starts with it are returned
+func WhyDidItScale(ctx context.Context, es *clients.Elastic, config *Config, containerID string, since time.Time, limit int) ([]*Decision, error) {
+	filters := []any{
+		map[string]any{"range": map[string]any{"@timestamp": map[string]any{"gte": since.Format(time.RFC3339)}}},
+		map[string]any{"bool": map[string]any{"must_not": map[string]any{"term": map[string]any{"action": utils.ACTION_NONE}}}},
+	}
+
+	if containerID != "" {
+		filters = append(filters, map[string]any{"prefix": map[string]any{"containers": containerID}})
+	}
+
+	query := map[string]any{
+		"size":  limit,
+		"sort":  []any{map[string]any{"@timestamp": "desc"}},
+		"query": map[string]any{"bool": map[string]any{"filter": filters}},
+	}
+
+	body, err := json.Marshal(query)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("In WhyDidItScale: Failed to marshal query -> %s", err))
+	}
+
+	res, err := es.Search(ctx, config.Elasticsearch.DecisionIndex, body)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("In WhyDidItScale: Failed to search decisions -> %s", err))
+	}
+
+	var response struct {
+		Hits struct {
+			Hits []struct {
+				Source Decision `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+
+	if err := json.Unmarshal(res, &response); err != nil {
+		return nil, errors.New(fmt.Sprintf("In WhyDidItScale: Failed to parse search response -> %s", err))
+	}
+
+	decisions := make([]*Decision, 0, len(response.Hits.Hits))
+	for i := range response.Hits.Hits {
+		decisions = append(decisions, &response.Hits.Hits[i].Source)
+	}
+
+	return decisions, nil
+}
@@ -0,0 +1,403 @@
+// Implements the destinations the collected metrics are sent to
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	clients "grs/common/clients"
+	events "grs/common/events"
+	. "grs/common/types"
+)
+
+// One document waiting to be indexed
+type document struct {
+	Index string          `json:"index"`
+	Body  json.RawMessage `json:"document"`
+}
+
+// Counters of what happened to the documents given to a sink
+type SinkMetrics struct {
+	Indexed  int64
+	Spooled  int64
+	Replayed int64
+	Dropped  int64
+}
+
+// A data stream whose template is installed before anything is written to it
+type dataStream struct {
+	name       string
+	properties map[string]any
+}
+
+// Buffers documents and indexes them through the _bulk API, when the buffer reaches
+// BatchSize documents or every FlushInterval. Documents that can't be indexed because
+// Elasticsearch is down are written to a spool file and replayed once it is back
+type ElasticSink struct {
+	es            *clients.Elastic
+	config        *Config
+	batchSize     int
+	flushInterval time.Duration
+	spool         *Spool
+
+	dataStreams []dataStream
+	installed   bool
+	// Whether a regular index in the way of a data stream was reported, it is only reported once
+	conflictReported bool
+
+	mu      sync.Mutex
+	pending []document
+
+	flushMu sync.Mutex
+	full    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+
+	indexed  atomic.Int64
+	spooled  atomic.Int64
+	replayed atomic.Int64
+	dropped  atomic.Int64
+
+	// Counters as last logged by logMetrics
+	logged SinkMetrics
+}
+
+func NewElasticSink(es *clients.Elastic, config *Config) *ElasticSink {
+	return &ElasticSink{
+		es:            es,
+		config:        config,
+		batchSize:     config.Elasticsearch.BatchSize,
+		flushInterval: config.Elasticsearch.FlushInterval,
+		spool:         NewSpool(config.Elasticsearch.SpoolPath, config.Elasticsearch.SpoolMaxSize),
+		full:          make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+// Registers a data stream to install before the first flush. Must be called before Start
+func (s *ElasticSink) RegisterDataStream(name string, properties map[string]any) {
+	s.dataStreams = append(s.dataStreams, dataStream{name: name, properties: properties})
+}
+
+// Starts flushing in the background until Close is called
+func (s *ElasticSink) Start() {
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.flushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			case <-s.full:
+			}
+
+			s.Flush(context.Background())
+		}
+	}()
+}
+
+// Stops the background flushing and flushes what is still pending. If Elasticsearch
+// can't be reached before ctx is done, the pending documents are spooled. Returns an
+// error if documents were dropped, like when the spool is full
+func (s *ElasticSink) Close(ctx context.Context) error {
+	close(s.stop)
+	<-s.done
+
+	err := s.Flush(ctx)
+	s.logMetrics()
+
+	return err
+}
+
+func (s *ElasticSink) Name() string {
+	return "elasticsearch"
+}
+
+// Queues one document per Stats sample in the stats data stream
+func (s *ElasticSink) WriteStats(ctx context.Context, stats []*Stats, timestamp time.Time) error {
+	for _, stat := range stats {
+		output, err := json.Marshal(NewStatsDocument(stat))
+		if err != nil {
+			return errors.New(fmt.Sprintf("In ElasticSink.WriteStats: Failed to marshal data -> %s", err))
+		}
+
+		s.Add(s.config.Elasticsearch.Index, output)
+	}
+
+	return nil
+}
+
+// Queues the traffic of the load balancer in the load balancer data stream
+func (s *ElasticSink) WriteLoadBalancer(ctx context.Context, stats *LoadBalancerStats, timestamp time.Time) error {
+	output, err := json.Marshal(LoadBalancerDocument{Timestamp: timestamp, LoadBalancerStats: *stats})
+	if err != nil {
+		return errors.New(fmt.Sprintf("In ElasticSink.WriteLoadBalancer: Failed to marshal data -> %s", err))
+	}
+
+	s.Add(s.config.Elasticsearch.LoadBalancerIndex, output)
+
+	return nil
+}
+
+// Queues the decision in the decisions data stream
+func (s *ElasticSink) WriteDecision(ctx context.Context, decision *Decision) error {
+	output, err := json.Marshal(decision)
+	if err != nil {
+		return errors.New(fmt.Sprintf("In ElasticSink.WriteDecision: Failed to marshal decision -> %s", err))
+	}
+
+	s.Add(s.config.Elasticsearch.DecisionIndex, output)
+
+	return nil
+}
+
+// Queues a JSON document to be indexed into index
+func (s *ElasticSink) Add(index string, body []byte) {
+	s.mu.Lock()
+	s.pending = append(s.pending, document{Index: index, Body: body})
+	isFull := len(s.pending) >= s.batchSize
+	s.mu.Unlock()
+
+	if isFull {
+		select {
+		case s.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *ElasticSink) Metrics() SinkMetrics {
+	return SinkMetrics{
+		Indexed:  s.indexed.Load(),
+		Spooled:  s.spooled.Load(),
+		Replayed: s.replayed.Load(),
+		Dropped:  s.dropped.Load(),
+	}
+}
+
+// Replays the spool if Elasticsearch is reachable, then indexes the pending documents. Returns an
+// error if documents were dropped: rejected by Elasticsearch, or they couldn't be spooled
+func (s *ElasticSink) Flush(ctx context.Context) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	batch := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	if !s.installed && !s.es.IsDown() {
+		s.install(ctx)
+	}
+
+	if s.installed && !s.es.IsDown() && !s.spool.IsEmpty() {
+		s.replay(ctx)
+	}
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	// Writing before the templates are installed would create indices with dynamic mappings
+	if !s.installed || s.es.IsDown() {
+		return s.spoolDocuments(batch)
+	}
+
+	var errs []error
+
+	for start := 0; start < len(batch); start += s.batchSize {
+		end := min(start+s.batchSize, len(batch))
+
+		retry, err := s.send(ctx, batch[start:end])
+		if isRejected(err) {
+			events.Failure("elastic_sink", events.INDEX_FAILED, fmt.Sprintf("Dropping %d documents rejected by Elasticsearch", end-start), err)
+			s.dropped.Add(int64(end - start))
+			errs = append(errs, errors.New(fmt.Sprintf("In ElasticSink.Flush: Dropped %d documents rejected by Elasticsearch -> %s", end-start, err)))
+			continue
+		}
+
+		if err != nil {
+			events.Failure("elastic_sink", events.INDEX_FAILED, fmt.Sprintf("Spooling %d documents", len(batch)-start), err)
+			errs = append(errs, s.spoolDocuments(batch[start:]))
+			break
+		}
+
+		errs = append(errs, s.spoolDocuments(retry))
+	}
+
+	s.logMetrics()
+
+	return errors.Join(errs...)
+}
+
+func (s *ElasticSink) install(ctx context.Context) {
+	for _, ds := range s.dataStreams {
+		err := InstallDataStream(ctx, s.es, s.config, ds.name, ds.properties)
+		if errors.Is(err, ErrRegularIndex) {
+			if !s.conflictReported {
+				events.Failure("elastic_sink", events.INDEX_FAILED, fmt.Sprintf("Can't create data stream %s, documents will be spooled", ds.name), err)
+				s.conflictReported = true
+			}
+
+			return
+		}
+
+		if err != nil {
+			events.Failure("elastic_sink", events.INDEX_FAILED, fmt.Sprintf("Failed to install data stream %s, documents will be spooled", ds.name), err)
+			return
+		}
+	}
+
+	s.installed = true
+}
+
+func (s *ElasticSink) replay(ctx context.Context) {
+	sent, corrupted, err := s.spool.Replay(s.batchSize, func(docs []document) error {
+		retry, err := s.send(ctx, docs)
+		if isRejected(err) {
+			// Replaying them again would be rejected again, so they leave the spool
+			events.Failure("elastic_sink", events.INDEX_FAILED, fmt.Sprintf("Dropping %d spooled documents rejected by Elasticsearch", len(docs)), err)
+			s.dropped.Add(int64(len(docs)))
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// The spool is being replayed, so these go back to the buffer for the next flush
+		s.mu.Lock()
+		s.pending = append(s.pending, retry...)
+		s.mu.Unlock()
+
+		return nil
+	})
+
+	s.replayed.Add(int64(sent))
+	s.dropped.Add(int64(corrupted))
+
+	if err != nil {
+		events.Failure("elastic_sink", events.INDEX_FAILED, fmt.Sprintf("Replayed %d spooled documents before failing", sent), err)
+	}
+}
+
+// Sends docs through the _bulk API. Returns the documents that were rejected for a transient
+// reason and should be tried again; the ones rejected for good are counted as dropped
+func (s *ElasticSink) send(ctx context.Context, docs []document) ([]document, error) {
+	var body bytes.Buffer
+
+	for _, doc := range docs {
+		// Data streams only accept create
+		action, _ := json.Marshal(map[string]any{"create": map[string]string{"_index": doc.Index}})
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(doc.Body)
+		body.WriteByte('\n')
+	}
+
+	res, err := s.es.Bulk(ctx, body.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+
+	if err := json.Unmarshal(res, &response); err != nil {
+		return nil, errors.New(fmt.Sprintf("In ElasticSink.send: Failed to parse bulk response -> %s", err))
+	}
+
+	if !response.Errors {
+		s.indexed.Add(int64(len(docs)))
+		return nil, nil
+	}
+
+	var retry []document
+
+	for i, item := range response.Items {
+		for _, result := range item {
+			switch {
+			case result.Status < 300:
+				s.indexed.Add(1)
+			case result.Status == 429 || result.Status >= 500:
+				retry = append(retry, docs[i])
+			default:
+				s.logger().Warn("Dropping document rejected by Elasticsearch", "status", result.Status, "error", string(result.Error))
+				s.dropped.Add(1)
+			}
+		}
+	}
+
+	return retry, nil
+}
+
+// Returns true if err is Elasticsearch refusing the whole request, like a mapping conflict, which sending
+// it again won't change. Transport errors, 429 and 5xx are worth trying again
+func isRejected(err error) bool {
+	var statusErr *clients.StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+
+	return statusErr.StatusCode != 429 && statusErr.StatusCode < 500
+}
+
+// Writes docs to the spool. Returns an error if some of them were dropped
+func (s *ElasticSink) spoolDocuments(docs []document) error {
+	if len(docs) == 0 {
+		return nil
+	}
+
+	written, err := s.spool.Append(docs)
+
+	s.spooled.Add(int64(written))
+	s.dropped.Add(int64(len(docs) - written))
+
+	if err != nil {
+		events.Failure("elastic_sink", events.INDEX_FAILED, fmt.Sprintf("Dropped %d documents that couldn't be spooled", len(docs)-written), err)
+		return errors.New(fmt.Sprintf("In ElasticSink.spoolDocuments: Dropped %d documents that couldn't be spooled -> %s", len(docs)-written, err))
+	}
+
+	if written < len(docs) {
+		s.logger().Warn("Spool is full, dropping documents", "dropped", len(docs)-written)
+		return errors.New(fmt.Sprintf("In ElasticSink.spoolDocuments: Dropped %d documents, the spool is full", len(docs)-written))
+	}
+
+	return nil
+}
+
+func (s *ElasticSink) logger() *slog.Logger {
+	return slog.Default().With("component", "elastic_sink")
+}
+
+// Logs the counters at debug level, or at info level if documents were spooled, replayed or dropped since
+// they were last logged, so a healthy sink doesn't log every flush_interval
+func (s *ElasticSink) logMetrics() {
+	m := s.Metrics()
+
+	level := slog.LevelDebug
+	if m.Spooled != s.logged.Spooled || m.Replayed != s.logged.Replayed || m.Dropped != s.logged.Dropped {
+		level = slog.LevelInfo
+	}
+
+	s.logged = m
+
+	s.logger().Log(context.Background(), level, "Documents flushed", "indexed", m.Indexed, "spooled", m.Spooled, "replayed", m.Replayed, "dropped", m.Dropped)
+}
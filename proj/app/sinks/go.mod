@@ -0,0 +1,5 @@
+module grs/sinks
+
+go 1.22.2
+
+require github.com/prometheus/client_golang v1.19.1
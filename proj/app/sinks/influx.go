@@ -0,0 +1,126 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	clients "grs/common/clients"
+	"grs/common/resilience"
+	. "grs/common/types"
+)
+
+// Writes stats and decisions to InfluxDB using the line protocol over HTTP
+type InfluxSink struct {
+	url    string
+	token  string
+	client *http.Client
+	policy *resilience.Policy
+}
+
+// Error returned when InfluxDB answers with an error status
+type influxStatusError struct {
+	statusCode int
+	body       string
+}
+
+func (e *influxStatusError) Error() string {
+	return fmt.Sprintf("InfluxDB answered %d -> %s", e.statusCode, e.body)
+}
+
+// url is the full write endpoint, like http://localhost:8086/api/v2/write?org=grs&bucket=grs&precision=ns
+func NewInfluxSink(url string, token string) *InfluxSink {
+	return &InfluxSink{
+		url:    url,
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+		policy: &resilience.Policy{
+			Service: "influxdb",
+			Backoff: clients.DEFAULT_BACKOFF,
+			Breaker: resilience.NewCircuitBreaker(clients.BREAKER_THRESHOLD, clients.BREAKER_COOLDOWN),
+			Classify: func(err error) resilience.Kind {
+				var statusErr *influxStatusError
+				if errors.As(err, &statusErr) && statusErr.statusCode != 429 && statusErr.statusCode < 500 {
+					return resilience.Permanent
+				}
+				return resilience.Transient
+			},
+		},
+	}
+}
+
+func (s *InfluxSink) Name() string {
+	return "influxdb"
+}
+
+func (s *InfluxSink) WriteStats(ctx context.Context, stats []*Stats, timestamp time.Time) error {
+	var lines bytes.Buffer
+
+	for _, stat := range stats {
+		fmt.Fprintf(&lines, "grs_stats,container_name=%s,container_id=%s,image=%s cpu_usage=%g,memory_usage=%g,used_memory=%g,available_memory=%g,cpus=%di,interval_ms=%g %d\n",
+			escapeTag(stat.ContainerName), escapeTag(stat.ContainerID), escapeTag(stat.Image), stat.CPUUsage, stat.MemoryUsage,
+			stat.UsedMemory, stat.AvailableMemory, stat.NumberOfCPUs, stat.IntervalMs, stat.Timestamp.UnixNano())
+	}
+
+	return s.write(ctx, lines.Bytes())
+}
+
+// Escapes the characters of the line protocol in a tag value. An empty tag is not allowed, so it becomes "unknown"
+func escapeTag(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(value)
+}
+
+func (s *InfluxSink) WriteDecision(ctx context.Context, decision *Decision) error {
+	line := fmt.Sprintf("grs_decision,action=%s,outcome=%s,dry_run=%t current_replicas=%di,desired_replicas=%di,avg_cpu_usage=%g,avg_memory_usage=%g,duration_ms=%g,reason=%s,error=%s %d\n",
+		decision.Action, decision.Outcome, decision.DryRun, decision.CurrentReplicas, decision.DesiredReplicas, decision.Inputs.AvgCPUUsage,
+		decision.Inputs.AvgMemoryUsage, decision.DurationMs, strconv.Quote(decision.Reason), strconv.Quote(decision.Error),
+		decision.Timestamp.UnixNano())
+
+	return s.write(ctx, []byte(line))
+}
+
+func (s *InfluxSink) Close(ctx context.Context) error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+func (s *InfluxSink) write(ctx context.Context, lines []byte) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	return s.policy.Do(ctx, "write", true, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(lines))
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		if s.token != "" {
+			req.Header.Set("Authorization", fmt.Sprintf("Token %s", s.token))
+		}
+
+		res, err := s.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode >= 300 {
+			body, _ := io.ReadAll(res.Body)
+			return &influxStatusError{statusCode: res.StatusCode, body: string(body)}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,155 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	. "grs/common/types"
+)
+
+// Appends stats and decisions as JSON lines to a file. When the file grows over maxSize
+// it is rotated to path.1, path.1 to path.2 and so on, keeping maxBackups old files
+type JSONLSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// One line of the file
+type jsonlRecord struct {
+	Type      string         `json:"type"`
+	Timestamp time.Time      `json:"@timestamp"`
+	Stats     *StatsDocument `json:"stats,omitempty"`
+	Decision  *Decision      `json:"decision,omitempty"`
+}
+
+func NewJSONLSink(path string, maxSize int64, maxBackups int) (*JSONLSink, error) {
+	s := &JSONLSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *JSONLSink) Name() string {
+	return "jsonl"
+}
+
+func (s *JSONLSink) WriteStats(ctx context.Context, stats []*Stats, timestamp time.Time) error {
+	for _, stat := range stats {
+		if err := s.write(jsonlRecord{Type: "stats", Timestamp: timestamp, Stats: NewStatsDocument(stat)}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *JSONLSink) WriteDecision(ctx context.Context, decision *Decision) error {
+	return s.write(jsonlRecord{Type: "decision", Timestamp: decision.Timestamp, Decision: decision})
+}
+
+func (s *JSONLSink) Close(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	return s.file.Close()
+}
+
+func (s *JSONLSink) write(record jsonlRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return errors.New(fmt.Sprintf("In JSONLSink.write: Failed to marshal record -> %s", err))
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A rotation failed to open the new file, try again
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return errors.New(fmt.Sprintf("In JSONLSink.write: No file to write to -> %s", err))
+		}
+	}
+
+	if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("In JSONLSink.write: Failed to write to %s -> %s", s.path, err))
+	}
+
+	return nil
+}
+
+// Opens the file for appending. Must be called with s.mu held or before the sink is shared
+func (s *JSONLSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.New(fmt.Sprintf("In JSONLSink.open: Failed to open %s -> %s", s.path, err))
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.New(fmt.Sprintf("In JSONLSink.open: Failed to get info of %s -> %s", s.path, err))
+	}
+
+	s.file = f
+	s.size = info.Size()
+
+	return nil
+}
+
+// Must be called with s.mu held. If the new file can't be opened, s.file is left nil and the error returned
+func (s *JSONLSink) rotate() error {
+	err := s.file.Close()
+	s.file = nil
+
+	if err != nil {
+		return errors.Join(errors.New(fmt.Sprintf("In JSONLSink.rotate: Failed to close %s -> %s", s.path, err)), s.open())
+	}
+
+	// The oldest backup is overwritten by the one before it
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		from := fmt.Sprintf("%s.%d", s.path, i)
+		to := fmt.Sprintf("%s.%d", s.path, i+1)
+
+		if err := os.Rename(from, to); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return errors.Join(errors.New(fmt.Sprintf("In JSONLSink.rotate: Failed to rename %s -> %s", from, err)), s.open())
+		}
+	}
+
+	if err := os.Rename(s.path, fmt.Sprintf("%s.1", s.path)); err != nil {
+		// Keep appending to the current file rather than losing every record
+		return errors.Join(errors.New(fmt.Sprintf("In JSONLSink.rotate: Failed to rename %s -> %s", s.path, err)), s.open())
+	}
+
+	return s.open()
+}
@@ -0,0 +1,130 @@
+package sinks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	. "grs/common/types"
+)
+
+// Exposes the last stats and decisions on a /metrics endpoint for Prometheus to scrape
+type PrometheusSink struct {
+	server *http.Server
+
+	cpuUsage        *prometheus.GaugeVec
+	memoryUsage     *prometheus.GaugeVec
+	samples         prometheus.Counter
+	currentReplicas prometheus.Gauge
+	desiredReplicas prometheus.Gauge
+	decisions       *prometheus.CounterVec
+}
+
+func NewPrometheusSink(address string) (*PrometheusSink, error) {
+	s := &PrometheusSink{
+		cpuUsage: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "grs_replica_cpu_usage_percent",
+			Help: "CPU usage of the replicas in the last collection, aggregated over all replicas",
+		}, []string{"aggregation"}),
+		memoryUsage: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "grs_replica_memory_usage_percent",
+			Help: "Memory usage of the replicas in the last collection, aggregated over all replicas",
+		}, []string{"aggregation"}),
+		samples: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "grs_stats_samples_total",
+			Help: "Number of stats samples collected from the replicas",
+		}),
+		currentReplicas: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "grs_decision_current_replicas",
+			Help: "Replicas running when the last decision was taken",
+		}),
+		desiredReplicas: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "grs_decision_desired_replicas",
+			Help: "Replicas wanted by the last decision",
+		}),
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grs_scaling_decisions_total",
+			Help: "Scaling decisions taken, by action and outcome",
+		}, []string{"action", "outcome"}),
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(s.cpuUsage, s.memoryUsage, s.samples, s.currentReplicas, s.desiredReplicas, s.decisions)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	// Listen right away so a port already in use is reported when the sink is created
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("In NewPrometheusSink: Failed to listen on %s -> %s", address, err))
+	}
+
+	s.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Stopped serving metrics", "component", "prometheus_sink", "error", err)
+		}
+	}()
+
+	return s, nil
+}
+
+func (s *PrometheusSink) Name() string {
+	return "prometheus"
+}
+
+func (s *PrometheusSink) WriteStats(ctx context.Context, stats []*Stats, timestamp time.Time) error {
+	var cpu, memory []float64
+
+	for _, stat := range stats {
+		cpu = append(cpu, stat.CPUUsage)
+		memory = append(memory, stat.MemoryUsage)
+	}
+
+	setAggregations(s.cpuUsage, cpu)
+	setAggregations(s.memoryUsage, memory)
+	s.samples.Add(float64(len(stats)))
+
+	return nil
+}
+
+func (s *PrometheusSink) WriteDecision(ctx context.Context, decision *Decision) error {
+	s.currentReplicas.Set(float64(decision.CurrentReplicas))
+	s.desiredReplicas.Set(float64(decision.DesiredReplicas))
+	s.decisions.WithLabelValues(decision.Action, decision.Outcome).Inc()
+
+	return nil
+}
+
+func (s *PrometheusSink) Close(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// Sets the avg, min and max labels of gauge from values
+func setAggregations(gauge *prometheus.GaugeVec, values []float64) {
+	if len(values) == 0 {
+		gauge.Reset()
+		return
+	}
+
+	sum, lowest, highest := 0.0, values[0], values[0]
+
+	for _, v := range values {
+		sum += v
+		lowest = min(lowest, v)
+		highest = max(highest, v)
+	}
+
+	gauge.WithLabelValues("avg").Set(sum / float64(len(values)))
+	gauge.WithLabelValues("min").Set(lowest)
+	gauge.WithLabelValues("max").Set(highest)
+}
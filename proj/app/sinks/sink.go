@@ -0,0 +1,92 @@
+package sinks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	clients "grs/common/clients"
+	telemetry "grs/common/telemetry"
+	. "grs/common/types"
+	utils "grs/common/utils"
+	control "grs/control"
+	grafana "grs/grafana"
+)
+
+// Destination of the stats of every replica and of every scaling decision
+type MetricSink interface {
+	// Name used in logs and events
+	Name() string
+
+	// Receives the stats collected from every replica in one iteration
+	WriteStats(ctx context.Context, stats []*Stats, timestamp time.Time) error
+
+	// Receives the decision the scaler took in one iteration
+	WriteDecision(ctx context.Context, decision *Decision) error
+
+	// Flushes what is pending and releases the sink's resources
+	Close(ctx context.Context) error
+}
+
+// Implemented by the sinks that also keep the traffic of the load balancer
+type LoadBalancerWriter interface {
+	WriteLoadBalancer(ctx context.Context, stats *LoadBalancerStats, timestamp time.Time) error
+}
+
+// Creates and starts the sinks enabled in the config file
+func NewSinks(config *Config, es *clients.Elastic) ([]MetricSink, error) {
+	var sinks []MetricSink
+
+	for _, name := range config.Sinks {
+		var sink MetricSink
+		var err error
+
+		switch name {
+		case utils.SINK_ELASTICSEARCH:
+			elasticSink := NewElasticSink(es, config)
+			elasticSink.RegisterDataStream(config.Elasticsearch.Index, STATS_PROPERTIES)
+			elasticSink.RegisterDataStream(config.Elasticsearch.DecisionIndex, DECISION_PROPERTIES)
+			elasticSink.RegisterDataStream(config.Elasticsearch.LoadBalancerIndex, LOAD_BALANCER_PROPERTIES)
+			elasticSink.Start()
+			sink = elasticSink
+
+			err = telemetry.RegisterElasticsearchCounters(func() map[string]int64 {
+				m := elasticSink.Metrics()
+				return map[string]int64{"indexed": m.Indexed, "spooled": m.Spooled, "replayed": m.Replayed, "dropped": m.Dropped}
+			})
+			if err != nil {
+				elasticSink.Close(context.Background())
+			}
+		case utils.SINK_PROMETHEUS:
+			sink, err = NewPrometheusSink(config.Prometheus.Address)
+		case utils.SINK_INFLUXDB:
+			sink = NewInfluxSink(config.InfluxDB.URL, config.InfluxDB.Token)
+		case utils.SINK_GRAFANA:
+			sink = grafana.NewAnnotator(grafana.NewClient(config), config.Service)
+		case utils.SINK_GRAFANA_JSON:
+			var auth func(http.Handler) http.Handler
+			auth, err = control.DatasourceMiddleware(config)
+			if err == nil {
+				sink, err = grafana.NewDatasource(config.Grafana.DatasourceAddress, config.Service, auth)
+			}
+		case utils.SINK_JSONL:
+			sink, err = NewJSONLSink(config.JSONL.Path, config.JSONL.MaxSize, config.JSONL.MaxBackups)
+		default:
+			err = errors.New(fmt.Sprintf("unknown sink %s", name))
+		}
+
+		if err != nil {
+			// Don't leave the sinks that were already started running
+			for _, started := range sinks {
+				started.Close(context.Background())
+			}
+			return nil, errors.New(fmt.Sprintf("In NewSinks: Failed to create sink %s -> %s", name, err))
+		}
+
+		sinks = append(sinks, sink)
+	}
+
+	return sinks, nil
+}
@@ -0,0 +1,205 @@
+package sinks
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Append-only file where documents are kept while Elasticsearch is unreachable.
+// Each line holds one document and the index it goes to
+type Spool struct {
+	path    string
+	maxSize int64
+
+	mu sync.Mutex
+}
+
+func NewSpool(path string, maxSize int64) *Spool {
+	return &Spool{
+		path:    path,
+		maxSize: maxSize,
+	}
+}
+
+// Appends documents to the spool. Returns how many were written, the rest didn't fit
+func (sp *Spool) Append(docs []document) (int, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	f, err := os.OpenFile(sp.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("In Spool.Append: Failed to open spool file -> %s", err))
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("In Spool.Append: Failed to get spool file info -> %s", err))
+	}
+
+	size := info.Size()
+	written := 0
+
+	for _, doc := range docs {
+		line, err := json.Marshal(doc)
+		if err != nil {
+			return written, errors.New(fmt.Sprintf("In Spool.Append: Failed to marshal document -> %s", err))
+		}
+		line = append(line, '\n')
+
+		if size+int64(len(line)) > sp.maxSize {
+			break
+		}
+
+		if _, err := f.Write(line); err != nil {
+			return written, errors.New(fmt.Sprintf("In Spool.Append: Failed to write to spool file -> %s", err))
+		}
+
+		size += int64(len(line))
+		written++
+	}
+
+	return written, nil
+}
+
+// Returns true if there are spooled documents waiting to be replayed
+func (sp *Spool) IsEmpty() bool {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	info, err := os.Stat(sp.path)
+
+	return err != nil || info.Size() == 0
+}
+
+// Sends the spooled documents in batches of up to batchSize, oldest first. Each batch is removed from
+// the spool once send accepts it; on the first error it and the rest are kept. The spool is only locked
+// while a batch is read and removed, so Append doesn't wait for send, and only one batch is in memory.
+// Returns how many documents were sent and how many lines were unreadable and dropped
+func (sp *Spool) Replay(batchSize int, send func([]document) error) (int, int, error) {
+	sent, corrupted := 0, 0
+
+	for {
+		docs, size, unreadable, err := sp.head(batchSize)
+		if err != nil || size == 0 {
+			return sent, corrupted, err
+		}
+
+		if len(docs) > 0 {
+			if err := send(docs); err != nil {
+				return sent, corrupted, err
+			}
+		}
+
+		sent += len(docs)
+		corrupted += unreadable
+
+		if err := sp.drop(size); err != nil {
+			return sent, corrupted, err
+		}
+	}
+}
+
+// Reads the first documents of the spool, up to n. Returns them, the bytes they take at the start of the
+// file and how many lines among them were unreadable
+func (sp *Spool) head(n int) ([]document, int64, int, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	f, err := os.Open(sp.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, 0, nil
+	}
+	if err != nil {
+		return nil, 0, 0, errors.New(fmt.Sprintf("In Spool.head: Failed to open spool file -> %s", err))
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+
+	var docs []document
+	var size int64
+	corrupted := 0
+
+	for len(docs) < n {
+		line, err := reader.ReadBytes('\n')
+		size += int64(len(line))
+
+		if len(line) > 0 {
+			var doc document
+			// A line cut short by a crash can't be recovered
+			if jsonErr := json.Unmarshal(line, &doc); jsonErr != nil {
+				corrupted++
+			} else {
+				docs = append(docs, doc)
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, 0, 0, errors.New(fmt.Sprintf("In Spool.head: Failed to read spool file -> %s", err))
+		}
+	}
+
+	return docs, size, corrupted, nil
+}
+
+// Removes the first size bytes of the spool, the documents that were replayed. The documents appended
+// since they were read are kept
+func (sp *Spool) drop(size int64) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	f, err := os.Open(sp.path)
+	if err != nil {
+		return errors.New(fmt.Sprintf("In Spool.drop: Failed to open spool file -> %s", err))
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return errors.New(fmt.Sprintf("In Spool.drop: Failed to get spool file info -> %s", err))
+	}
+
+	if size >= info.Size() {
+		if err := os.Remove(sp.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return errors.New(fmt.Sprintf("In Spool.drop: Failed to remove spool file -> %s", err))
+		}
+
+		return nil
+	}
+
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		return errors.New(fmt.Sprintf("In Spool.drop: Failed to read spool file -> %s", err))
+	}
+
+	tmpPath := sp.path + ".tmp"
+
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return errors.New(fmt.Sprintf("In Spool.drop: Failed to create spool file -> %s", err))
+	}
+
+	if _, err := io.Copy(tmp, f); err != nil {
+		tmp.Close()
+		return errors.New(fmt.Sprintf("In Spool.drop: Failed to write spool file -> %s", err))
+	}
+
+	if err := tmp.Close(); err != nil {
+		return errors.New(fmt.Sprintf("In Spool.drop: Failed to close spool file -> %s", err))
+	}
+
+	// Renaming is atomic, so a crash leaves either the old or the new spool
+	if err := os.Rename(tmpPath, sp.path); err != nil {
+		return errors.New(fmt.Sprintf("In Spool.drop: Failed to replace spool file -> %s", err))
+	}
+
+	return nil
+}
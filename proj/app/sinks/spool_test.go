@@ -0,0 +1,262 @@
+package sinks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func documents(index string, n int) []document {
+	var docs []document
+	for i := 0; i < n; i++ {
+		docs = append(docs, document{Index: index, Body: json.RawMessage(fmt.Sprintf(`{"n":%d}`, i))})
+	}
+
+	return docs
+}
+
+func spoolSize(t *testing.T, path string) int64 {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return info.Size()
+}
+
+func TestSpoolAppend(t *testing.T) {
+	line, _ := json.Marshal(documents("containers", 1)[0])
+	lineSize := int64(len(line) + 1)
+
+	tests := []struct {
+		name    string
+		maxSize int64
+		// Documents appended by each call
+		appends []int
+		written []int
+	}{
+		{name: "fits", maxSize: 100 * lineSize, appends: []int{3, 2}, written: []int{3, 2}},
+		{name: "cut at the max size", maxSize: 4 * lineSize, appends: []int{3, 3}, written: []int{3, 1}},
+		{name: "full", maxSize: 2 * lineSize, appends: []int{2, 1}, written: []int{2, 0}},
+		{name: "smaller than a document", maxSize: lineSize - 1, appends: []int{1}, written: []int{0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spool := NewSpool(filepath.Join(t.TempDir(), "spool.jsonl"), test.maxSize)
+
+			var written []int
+			for _, n := range test.appends {
+				w, err := spool.Append(documents("containers", n))
+				if err != nil {
+					t.Fatalf("Append() error = %v", err)
+				}
+				written = append(written, w)
+			}
+
+			if !reflect.DeepEqual(written, test.written) {
+				t.Errorf("written = %v, want %v", written, test.written)
+			}
+
+			if size := spoolSize(t, spool.path); size > test.maxSize {
+				t.Errorf("spool size = %d, above the max size %d", size, test.maxSize)
+			}
+		})
+	}
+}
+
+func TestSpoolReplay(t *testing.T) {
+	failAt := func(call int) func(int) error {
+		return func(n int) error {
+			if n == call {
+				return errors.New("unreachable")
+			}
+			return nil
+		}
+	}
+
+	tests := []struct {
+		name      string
+		spooled   int
+		batchSize int
+		// Fails the send of the nth batch, starting at 0
+		fail      func(int) error
+		batches   []int
+		sent      int
+		remaining int
+		err       bool
+	}{
+		{name: "empty", spooled: 0, batchSize: 10, fail: failAt(-1)},
+		{name: "one batch", spooled: 3, batchSize: 10, fail: failAt(-1), batches: []int{3}, sent: 3},
+		{name: "several batches", spooled: 7, batchSize: 3, fail: failAt(-1), batches: []int{3, 3, 1}, sent: 7},
+		{name: "exact batches", spooled: 6, batchSize: 3, fail: failAt(-1), batches: []int{3, 3}, sent: 6},
+		{name: "first batch fails", spooled: 5, batchSize: 2, fail: failAt(0), batches: []int{2}, remaining: 5, err: true},
+		{name: "acknowledged batches removed", spooled: 5, batchSize: 2, fail: failAt(1), batches: []int{2, 2}, sent: 2, remaining: 3, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spool := NewSpool(filepath.Join(t.TempDir(), "spool.jsonl"), 1<<20)
+			if test.spooled > 0 {
+				if _, err := spool.Append(documents("containers", test.spooled)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var batches []int
+			var received []document
+
+			sent, corrupted, err := spool.Replay(test.batchSize, func(docs []document) error {
+				batches = append(batches, len(docs))
+				if err := test.fail(len(batches) - 1); err != nil {
+					return err
+				}
+				received = append(received, docs...)
+				return nil
+			})
+
+			if (err != nil) != test.err {
+				t.Fatalf("Replay() error = %v, want an error: %v", err, test.err)
+			}
+
+			if sent != test.sent || corrupted != 0 {
+				t.Errorf("Replay() = %d sent, %d corrupted, want %d sent, 0 corrupted", sent, corrupted, test.sent)
+			}
+
+			if !reflect.DeepEqual(batches, test.batches) {
+				t.Errorf("batches = %v, want %v", batches, test.batches)
+			}
+
+			// Oldest first
+			if test.sent > 0 && !reflect.DeepEqual(received, documents("containers", test.spooled)[:test.sent]) {
+				t.Errorf("received = %v, want the first %d documents in order", received, test.sent)
+			}
+
+			// The documents that are left are the ones after those sent
+			var left []document
+			spool.Replay(test.batchSize, func(docs []document) error {
+				left = append(left, docs...)
+				return nil
+			})
+
+			if len(left) != test.remaining {
+				t.Fatalf("%d documents left, want %d", len(left), test.remaining)
+			}
+
+			if test.remaining > 0 && !reflect.DeepEqual(left, documents("containers", test.spooled)[test.sent:]) {
+				t.Errorf("left = %v, want the last %d documents", left, test.remaining)
+			}
+
+			if !spool.IsEmpty() {
+				t.Error("IsEmpty() = false after a full replay")
+			}
+		})
+	}
+}
+
+func TestSpoolReplayCorrupted(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		sent      int
+		corrupted int
+	}{
+		{
+			name:      "line cut short by a crash",
+			content:   `{"index":"a","document":{"n":0}}` + "\n" + `{"index":"a","docu`,
+			sent:      1,
+			corrupted: 1,
+		},
+		{
+			name:      "garbage in the middle",
+			content:   `{"index":"a","document":{"n":0}}` + "\nnot json\n" + `{"index":"a","document":{"n":1}}` + "\n",
+			sent:      2,
+			corrupted: 1,
+		},
+		{
+			name:      "only garbage",
+			content:   "not json\n",
+			corrupted: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "spool.jsonl")
+			if err := os.WriteFile(path, []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			spool := NewSpool(path, 1<<20)
+
+			sent, corrupted, err := spool.Replay(10, func(docs []document) error { return nil })
+			if err != nil {
+				t.Fatalf("Replay() error = %v", err)
+			}
+
+			if sent != test.sent || corrupted != test.corrupted {
+				t.Errorf("Replay() = %d sent, %d corrupted, want %d, %d", sent, corrupted, test.sent, test.corrupted)
+			}
+
+			if !spool.IsEmpty() {
+				t.Error("IsEmpty() = false, the unreadable lines should be dropped")
+			}
+		})
+	}
+}
+
+// Documents appended while a batch is being sent are kept and replayed after the older ones
+func TestSpoolAppendDuringReplay(t *testing.T) {
+	spool := NewSpool(filepath.Join(t.TempDir(), "spool.jsonl"), 1<<20)
+	if _, err := spool.Append(documents("old", 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	var received []document
+
+	sent, _, err := spool.Replay(2, func(docs []document) error {
+		if len(received) == 0 {
+			// From another goroutine, which must not wait for send to return
+			appended := make(chan error, 1)
+			go func() {
+				_, err := spool.Append(documents("new", 1))
+				appended <- err
+			}()
+
+			select {
+			case err := <-appended:
+				if err != nil {
+					t.Errorf("Append() error = %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Append() blocked while a batch was sent")
+			}
+		}
+
+		received = append(received, docs...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+
+	expect := append(documents("old", 4), documents("new", 1)...)
+
+	if sent != len(expect) || !reflect.DeepEqual(received, expect) {
+		t.Errorf("Replay() sent %d: %v, want %v", sent, received, expect)
+	}
+
+	if !spool.IsEmpty() {
+		t.Error("IsEmpty() = false after a full replay")
+	}
+}
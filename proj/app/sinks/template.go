@@ -0,0 +1,261 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	clients "grs/common/clients"
+	. "grs/common/types"
+)
+
+// Version of the schema of the stats documents, written in each of them. It changes when a field is renamed or removed
+const STATS_SCHEMA_VERSION int = 2
+
+// Explicit mappings of the documents written to the stats data stream, see StatsDocument
+var STATS_PROPERTIES = map[string]any{
+	"@timestamp":       map[string]any{"type": "date"},
+	"schema_version":   map[string]any{"type": "short"},
+	"container_id":     map[string]any{"type": "keyword"},
+	"container_name":   map[string]any{"type": "keyword"},
+	"image":            map[string]any{"type": "keyword"},
+	"labels":           map[string]any{"type": "flattened"},
+	"interval_ms":      map[string]any{"type": "float"},
+	"used_memory":      map[string]any{"type": "double"},
+	"available_memory": map[string]any{"type": "double"},
+	"memory_usage":     map[string]any{"type": "float"},
+	"number_of_cpus":   map[string]any{"type": "short"},
+	"cpu_usage":        map[string]any{"type": "float"},
+}
+
+// Explicit mappings of the documents written to the decisions data stream
+var DECISION_PROPERTIES = map[string]any{
+	"@timestamp": map[string]any{"type": "date"},
+	"id":         map[string]any{"type": "keyword"},
+	"policy":     map[string]any{"type": "keyword"},
+	"inputs": map[string]any{
+		"properties": map[string]any{
+			"samples":              map[string]any{"type": "integer"},
+			"avg_cpu_usage":        map[string]any{"type": "float"},
+			"max_cpu_usage":        map[string]any{"type": "float"},
+			"avg_memory_usage":     map[string]any{"type": "float"},
+			"max_memory_usage":     map[string]any{"type": "float"},
+			"cpu_threshold":        map[string]any{"type": "float"},
+			"memory_threshold":     map[string]any{"type": "float"},
+			"min_replicas":         map[string]any{"type": "integer"},
+			"trigger_cpu_usage":    map[string]any{"type": "float"},
+			"trigger_memory_usage": map[string]any{"type": "float"},
+		},
+	},
+	"current_replicas": map[string]any{"type": "integer"},
+	"desired_replicas": map[string]any{"type": "integer"},
+	"action":           map[string]any{"type": "keyword"},
+	"reason":           map[string]any{"type": "text"},
+	"containers":       map[string]any{"type": "keyword"},
+	"upstream":         map[string]any{"type": "keyword"},
+	"dry_run":          map[string]any{"type": "boolean"},
+	"outcome":          map[string]any{"type": "keyword"},
+	"error":            map[string]any{"type": "text"},
+	"duration_ms":      map[string]any{"type": "float"},
+}
+
+// Explicit mappings of the documents written to the load balancer data stream
+var LOAD_BALANCER_PROPERTIES = map[string]any{
+	"@timestamp": map[string]any{"type": "date"},
+	"rx_bytes":   map[string]any{"type": "long"},
+	"tx_bytes":   map[string]any{"type": "long"},
+	"rx_packets": map[string]any{"type": "long"},
+	"tx_packets": map[string]any{"type": "long"},
+}
+
+// Document written to the load balancer data stream
+type LoadBalancerDocument struct {
+	Timestamp time.Time `json:"@timestamp"`
+	LoadBalancerStats
+}
+
+// Document written to the stats data stream for each Stats sample, and to the other sinks that write JSON.
+// @timestamp is when Docker read the stats of the container
+type StatsDocument struct {
+	SchemaVersion int `json:"schema_version"`
+	*Stats
+}
+
+func NewStatsDocument(stat *Stats) *StatsDocument {
+	return &StatsDocument{SchemaVersion: STATS_SCHEMA_VERSION, Stats: stat}
+}
+
+// Returned by InstallDataStream when a regular index has the name of the data stream, like the stats index
+// written by the versions before data streams. Elasticsearch can't create the data stream until it is gone
+var ErrRegularIndex = errors.New("a regular index has the name of the data stream")
+
+// Installs the ILM policy and the index template of a data stream, so documents written to it
+// get explicit mappings, roll over to a new backing index and are deleted after the retention period.
+// Both requests replace what is installed, so this can run on every startup. When the mappings of the
+// template change, the data stream rolls over, so the documents written from then on get the new ones
+func InstallDataStream(ctx context.Context, es *clients.Elastic, config *Config, name string, properties map[string]any) error {
+	if err := checkNoRegularIndex(ctx, es, name); err != nil {
+		return err
+	}
+
+	policyName := fmt.Sprintf("%s-policy", name)
+
+	policy := map[string]any{
+		"policy": map[string]any{
+			"phases": map[string]any{
+				"hot": map[string]any{
+					"actions": map[string]any{
+						"rollover": map[string]any{
+							"max_age":                config.Elasticsearch.RolloverMaxAge,
+							"max_primary_shard_size": config.Elasticsearch.RolloverMaxSize,
+						},
+					},
+				},
+				"delete": map[string]any{
+					"min_age": config.Elasticsearch.Retention,
+					"actions": map[string]any{
+						"delete": map[string]any{},
+					},
+				},
+			},
+		},
+	}
+
+	body, err := json.Marshal(policy)
+	if err != nil {
+		return errors.New(fmt.Sprintf("In InstallDataStream: Failed to marshal ILM policy -> %s", err))
+	}
+
+	if err := es.PutLifecycle(ctx, policyName, body); err != nil {
+		return errors.New(fmt.Sprintf("In InstallDataStream: Failed to install ILM policy %s -> %s", policyName, err))
+	}
+
+	template := map[string]any{
+		"index_patterns": []string{name},
+		"data_stream":    map[string]any{},
+		"priority":       200,
+		"template": map[string]any{
+			"settings": map[string]any{
+				"index.lifecycle.name": policyName,
+			},
+			"mappings": map[string]any{
+				// Fields without an explicit mapping are strings like names and labels, which we only filter on
+				"dynamic_templates": []any{
+					map[string]any{
+						"strings_as_keywords": map[string]any{
+							"match_mapping_type": "string",
+							"mapping":            map[string]any{"type": "keyword"},
+						},
+					},
+				},
+				"properties": properties,
+			},
+		},
+	}
+
+	body, err = json.Marshal(template)
+	if err != nil {
+		return errors.New(fmt.Sprintf("In InstallDataStream: Failed to marshal index template -> %s", err))
+	}
+
+	templateName := fmt.Sprintf("%s-template", name)
+
+	changed, err := mappingsChanged(ctx, es, templateName, template["template"].(map[string]any)["mappings"])
+	if err != nil {
+		return errors.New(fmt.Sprintf("In InstallDataStream: Failed to read index template %s -> %s", templateName, err))
+	}
+
+	if err := es.PutIndexTemplate(ctx, templateName, body); err != nil {
+		return errors.New(fmt.Sprintf("In InstallDataStream: Failed to install index template %s -> %s", templateName, err))
+	}
+
+	if !changed {
+		return nil
+	}
+
+	// The backing index being written keeps the mappings it was created with. A data stream that doesn't
+	// exist yet is created with the new ones
+	if err := es.Rollover(ctx, name); err != nil && !clients.IsNotFound(err) {
+		return errors.New(fmt.Sprintf("In InstallDataStream: Failed to roll over data stream %s -> %s", name, err))
+	}
+
+	return nil
+}
+
+// Returns whether the index template called name is missing or has other mappings than mappings
+func mappingsChanged(ctx context.Context, es *clients.Elastic, name string, mappings any) (bool, error) {
+	body, err := es.GetIndexTemplate(ctx, name)
+	if clients.IsNotFound(err) {
+		return true, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	var installed struct {
+		IndexTemplates []struct {
+			IndexTemplate struct {
+				Template struct {
+					Mappings any `json:"mappings"`
+				} `json:"template"`
+			} `json:"index_template"`
+		} `json:"index_templates"`
+	}
+
+	if err := json.Unmarshal(body, &installed); err != nil {
+		return false, err
+	}
+
+	if len(installed.IndexTemplates) == 0 {
+		return true, nil
+	}
+
+	// Both sides go through JSON, so they are compared with the same types
+	var wanted any
+	encoded, err := json.Marshal(mappings)
+	if err != nil {
+		return false, err
+	}
+
+	if err := json.Unmarshal(encoded, &wanted); err != nil {
+		return false, err
+	}
+
+	return !reflect.DeepEqual(installed.IndexTemplates[0].IndexTemplate.Template.Mappings, wanted), nil
+}
+
+// Returns an error wrapping ErrRegularIndex, with the way out, if name is a regular index rather than a data stream
+func checkNoRegularIndex(ctx context.Context, es *clients.Elastic, name string) error {
+	body, err := es.ResolveIndex(ctx, name)
+	if clients.IsNotFound(err) {
+		return nil
+	}
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("In checkNoRegularIndex: Failed to resolve %s -> %s", name, err))
+	}
+
+	var resolved struct {
+		Indices []struct {
+			Name       string `json:"name"`
+			DataStream string `json:"data_stream"`
+		} `json:"indices"`
+	}
+
+	if err := json.Unmarshal(body, &resolved); err != nil {
+		return errors.New(fmt.Sprintf("In checkNoRegularIndex: Failed to parse the answer -> %s", err))
+	}
+
+	for _, index := range resolved.Indices {
+		if index.Name == name && index.DataStream == "" {
+			return fmt.Errorf("In checkNoRegularIndex: Index %s was created by an older version, reindex it into another index "+
+				"and delete it (DELETE /%s), or write to another data stream with elasticsearch.index: %w", name, name, ErrRegularIndex)
+		}
+	}
+
+	return nil
+}
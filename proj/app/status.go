@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	logging "grs/common/logging"
+	. "grs/common/types"
+	control "grs/control"
+)
+
+// Talks to the control API of a running instance
+type controlClient struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// Adds the flags to reach the control API to flags. The defaults come from the control section of config
+func controlFlags(flags *flag.FlagSet, config *Config) func() (*controlClient, error) {
+	address := flags.String("address", config.Control.Address, "address of the control API")
+	token := flags.String("token", os.Getenv("GRS_CONTROL_TOKEN"), "bearer token, $GRS_CONTROL_TOKEN by default")
+	caFile := flags.String("ca", "", "CA that signed the certificate of the control API, the system CAs by default")
+	certFile := flags.String("cert", "", "client certificate, if the control API requires one")
+	keyFile := flags.String("key", "", "key of the client certificate")
+
+	return func() (*controlClient, error) {
+		c := &controlClient{url: "http://" + *address, token: *token, client: &http.Client{Timeout: 10 * time.Second}}
+
+		if config.Control.TLS.CertFile == "" && *caFile == "" && *certFile == "" {
+			return c, nil
+		}
+
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+		if *caFile != "" {
+			pem, err := os.ReadFile(*caFile)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("In controlFlags: Failed to read the CA -> %s", err))
+			}
+
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, errors.New(fmt.Sprintf("In controlFlags: No certificate found in %s", *caFile))
+			}
+		}
+
+		if *certFile != "" {
+			cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("In controlFlags: Failed to load the client certificate -> %s", err))
+			}
+
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+
+		c.url = "https://" + *address
+		c.client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+
+		return c, nil
+	}
+}
+
+// Sends a request to the control API and decodes the response into out. An error response is returned as an error
+func (c *controlClient) do(method string, path string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		reader = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequest(method, c.url+path, reader)
+	if err != nil {
+		return err
+	}
+
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return errors.New(fmt.Sprintf("In controlClient.do: Failed to reach the control API -> %s", err))
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(response.Body).Decode(&apiErr)
+
+		return errors.New(fmt.Sprintf("In controlClient.do: %s %s returned %s -> %s", method, path, response.Status, apiErr.Error))
+	}
+
+	return json.NewDecoder(response.Body).Decode(out)
+}
+
+// Prints the status of every service of a running instance.
+// Usage: go run . status [-json]
+func status(config *Config, args []string) {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the raw JSON of the control API")
+	newClient := controlFlags(flags, config)
+	flags.Parse(args)
+
+	client, err := newClient()
+	if err != nil {
+		logging.Fatal("Failed to create the control API client", err)
+	}
+
+	var statuses []control.ServiceStatus
+	if err := client.do(http.MethodGet, "/services", nil, &statuses); err != nil {
+		logging.Fatal("Failed to get the status", err)
+	}
+
+	if *asJSON {
+		output, _ := json.MarshalIndent(statuses, "", "  ")
+		fmt.Println(string(output))
+		return
+	}
+
+	for _, s := range statuses {
+		printStatus(s)
+	}
+}
+
+// Holds a service of a running instance at a number of replicas.
+// Usage: go run . scale [-ttl 15m] <service> <replicas>
+func scale(config *Config, args []string) {
+	flags := flag.NewFlagSet("scale", flag.ExitOnError)
+	ttl := flags.Duration("ttl", 0, "how long to hold the replicas before autoscaling resumes, until resumed if 0")
+	newClient := controlFlags(flags, config)
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: scale [-ttl 15m] <service> <replicas>")
+		os.Exit(2)
+	}
+
+	replicas, err := strconv.Atoi(flags.Arg(1))
+	if err != nil {
+		logging.Fatal("Invalid number of replicas", err)
+	}
+
+	client, err := newClient()
+	if err != nil {
+		logging.Fatal("Failed to create the control API client", err)
+	}
+
+	request := map[string]any{"replicas": replicas}
+	if *ttl > 0 {
+		request["ttl"] = ttl.String()
+	}
+
+	var s control.ServiceStatus
+	if err := client.do(http.MethodPost, "/services/"+flags.Arg(0)+"/scale", request, &s); err != nil {
+		logging.Fatal("Failed to scale", err)
+	}
+
+	printStatus(s)
+}
+
+func printStatus(s control.ServiceStatus) {
+	mode := "autoscaling"
+	switch {
+	case s.Paused:
+		mode = "paused"
+	case s.Override != nil && s.Override.Expires != nil:
+		mode = fmt.Sprintf("held at %d replicas until %s", s.Override.Replicas, s.Override.Expires.Local().Format(time.RFC3339))
+	case s.Override != nil:
+		mode = fmt.Sprintf("held at %d replicas until resumed", s.Override.Replicas)
+	}
+
+	fmt.Printf("%s: %d replicas, %s\n", s.Service, s.Replicas, mode)
+
+	if !s.LastUpdate.IsZero() {
+		fmt.Printf("    last update %s\n", s.LastUpdate.Local().Format(time.RFC3339))
+	}
+
+	for i, stat := range s.LastStats {
+		fmt.Printf("    replica %d (%s): CPU %.3f%%, memory %.3f%%\n", i+1, stat.ContainerName, stat.CPUUsage, stat.MemoryUsage)
+	}
+
+	if d := s.LastDecision; d != nil {
+		fmt.Printf("    last decision %s: %s (%s), %d -> %d replicas, because %s\n",
+			d.Timestamp.Local().Format(time.RFC3339), d.Action, d.Outcome, d.CurrentReplicas, d.DesiredReplicas, d.Reason)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	. "grs/common/types"
+	. "grs/common/utils"
+	control "grs/control"
+)
+
+// Checks the config file at path and prints what is wrong with it. Returns the exit code: 0 if it is valid, 1 otherwise.
+// Usage: go run . validate
+func validate(path string) int {
+	config, err := loadConfig(path)
+	if err != nil {
+		printConfigProblems(path, err)
+		return 1
+	}
+
+	problems := configProblems(config)
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "%s is invalid: %s\n", path, problem)
+	}
+
+	if len(problems) > 0 {
+		return 1
+	}
+
+	fmt.Printf("%s is valid\n", path)
+
+	return 0
+}
+
+// Prints the problems of a config error, at their line in the file at path or with the
+// environment variable or flag they come from
+func printConfigProblems(path string, err error) {
+	for _, problem := range ConfigErrors(err) {
+		switch {
+		case problem.Source != "":
+			fmt.Fprintf(os.Stderr, "%s\n", problem)
+		case problem.Line == 0:
+			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", path, problem.Field, problem.Message)
+		default:
+			fmt.Fprintf(os.Stderr, "%s:%d:%d: %s: %s\n", path, problem.Line, problem.Column, problem.Field, problem.Message)
+		}
+	}
+}
+
+// Returns what ConfigParser doesn't check: the files the application loads
+func configProblems(config *Config) []error {
+	var problems []error
+
+	if err := control.CheckConfig(config); err != nil {
+		problems = append(problems, err)
+	}
+
+	return problems
+}
+
+// Prints the JSON Schema of the config file, which editors use to complete and check it.
+// Usage: go run . schema > config.schema.json
+func schema() int {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(ConfigSchema()); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print the schema: %s\n", err)
+		return 1
+	}
+
+	return 0
+}
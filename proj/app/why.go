@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	clients "grs/common/clients"
+	logging "grs/common/logging"
+	. "grs/common/types"
+	sinks "grs/sinks"
+)
+
+// Prints the decisions that started or stopped containers and why.
+// Usage: go run . why [-since 24h] [-limit 20] [container ID]
+func why(config *Config, args []string) {
+	flags := flag.NewFlagSet("why", flag.ExitOnError)
+	since := flags.Duration("since", 24*time.Hour, "how far back to look")
+	limit := flags.Int("limit", 20, "maximum number of decisions to print")
+	flags.Parse(args)
+
+	es, err := clients.NewElastic()
+	if err != nil {
+		logging.Fatal("Failed to create Elasticsearch client", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	decisions, err := sinks.WhyDidItScale(ctx, es, config, flags.Arg(0), time.Now().Add(-*since), *limit)
+	if err != nil {
+		logging.Fatal("Failed to query the scaling decisions", err)
+	}
+
+	if len(decisions) == 0 {
+		fmt.Printf("No scaling decisions in the last %s\n", *since)
+		return
+	}
+
+	for _, d := range decisions {
+		fmt.Printf("%s %s (%s): %d -> %d replicas, containers [%s], policy %s, took %.1fms\n",
+			d.Timestamp.Local().Format(time.RFC3339), d.Action, d.Outcome, d.CurrentReplicas, d.DesiredReplicas,
+			strings.Join(d.Containers, ", "), d.Policy, d.DurationMs)
+		fmt.Printf("    because %s\n", d.Reason)
+		fmt.Printf("    %d samples, CPU avg %.3f%% max %.3f%%, memory avg %.3f%% max %.3f%%\n",
+			d.Inputs.Samples, d.Inputs.AvgCPUUsage, d.Inputs.MaxCPUUsage, d.Inputs.AvgMemoryUsage, d.Inputs.MaxMemoryUsage)
+
+		if d.Error != "" {
+			fmt.Printf("    error: %s\n", d.Error)
+		}
+	}
+}
@@ -1,8 +1,21 @@
 #!/bin/bash
 
-GRAFANA_URL="http://localhost:3000"
-GRAFANA_USER="admin"
-GRAFANA_PASSWORD="admin"
+CONFIG_FILE="${CONFIG_FILE:-$(dirname "$0")/app/config.yaml}"
+
+# Prints the value of a key of the grafana section of the config file
+grafana_config() {
+    awk -v key="$1" '
+        /^grafana:/ { section = 1; next }
+        /^[^ \t#]/ { section = 0 }
+        section && $1 == key ":" { value = $2; gsub(/["\047]/, "", value); print value }
+    ' "$CONFIG_FILE"
+}
+
+# The environment takes precedence over the config file, with the same GRS_ variables as the application
+GRAFANA_URL="${GRAFANA_URL:-${GRS_GRAFANA_URL:-$(grafana_config url)}}"
+GRAFANA_URL="${GRAFANA_URL:-http://localhost:3000}"
+GRAFANA_USER="${GRAFANA_USER:-${GRS_GRAFANA_USER:-$(grafana_config user)}}"
+GRAFANA_PASSWORD="${GRAFANA_PASSWORD:-${GRS_GRAFANA_PASSWORD:-$(grafana_config password)}}"
 
 # Get a list of all data sources
 DATA_SOURCES=$(curl -s -X GET "$GRAFANA_URL/api/datasources" \